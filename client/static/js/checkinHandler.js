@@ -0,0 +1,55 @@
+document.addEventListener("DOMContentLoaded", function () {
+    const checkinElement = document.getElementById("checkin");
+    const checkinButton = document.getElementById("checkin-btn");
+    const checkinMessage = document.getElementById("checkin-message");
+
+    if (!checkinElement || !checkinButton) {
+        return;
+    }
+
+    const questId = checkinElement.getAttribute("data-quest-id");
+
+    checkinButton.addEventListener("click", function () {
+        if (!navigator.geolocation) {
+            checkinMessage.textContent = t("checkin_unsupported");
+            return;
+        }
+
+        checkinButton.disabled = true;
+        checkinMessage.textContent = t("checkin_locating");
+
+        navigator.geolocation.getCurrentPosition(function (position) {
+            const body = new URLSearchParams();
+            body.append("quest_id", questId);
+            body.append("latitude", position.coords.latitude);
+            body.append("longitude", position.coords.longitude);
+            body.append("accuracy", position.coords.accuracy);
+
+            // Let the server check the position against the geofence
+            fetch('/checkin', { method: 'POST', body: body })
+                .then(response => {
+                    if (!response.ok) {
+                        throw new Error('Network response was not ok');
+                    }
+                    return response.json();
+                })
+                .then(data => {
+                    checkinMessage.textContent = data.message;
+                    if (data.success) {
+                        window.location.reload();
+                    } else {
+                        checkinButton.disabled = false;
+                    }
+                })
+                .catch(error => {
+                    console.error("There was a problem with the check-in:", error);
+                    checkinMessage.textContent = t("checkin_error");
+                    checkinButton.disabled = false;
+                });
+        }, function (error) {
+            console.error("Geolocation error:", error);
+            checkinMessage.textContent = t("checkin_denied");
+            checkinButton.disabled = false;
+        }, { enableHighAccuracy: true, timeout: 20000, maximumAge: 0 });
+    });
+});
@@ -1,33 +1,19 @@
 document.addEventListener("DOMContentLoaded", function () {
     var hintButton = document.getElementById("hintButton");
     var hintText = document.getElementById("hintText");
+    var hintContent = document.getElementById("hintContent");
     var hintCount = document.getElementById("hintCount");
     var questId = hintButton ? hintButton.getAttribute("data-quest-id") : null;
+    var hintRevealed = hintButton ? hintButton.getAttribute("data-hint-revealed") === "true" : false;
     // console.log("questId: " + questId);
 
-
-    // Check if the hint has already been shown by looking in localStorage
-    if (localStorage.getItem("hintShown_" + questId) === "true") {
-        if (hintText) {
-            hintText.style.display = "block";
-        }
-        if (hintButton) {
-            hintButton.disabled = true;
-        }
-    }
-
     if (hintButton) {
         hintButton.addEventListener("click", function () {
             if (hintText) {
-                hintText.style.display = "block";
-
                 // Disable the hint button
                 hintButton.disabled = true;
 
-                // Store the hint status in localStorage
-                localStorage.setItem("hintShown_" + questId, "true");
-
-                // Send a request to increment the hint count
+                // Ask the server for the hint, which also increments the hint count
                 fetch(`/hint/${questId}`, { method: 'POST' })
                     .then(response => {
                         if (!response.ok) {
@@ -38,17 +24,23 @@ document.addEventListener("DOMContentLoaded", function () {
                     .then(data => {
                         if (data.success) {
                             console.log("Hint count incremented.");
+                            hintRevealed = true;
+                            // The server renders the Markdown of the hint and escapes any HTML
+                            hintContent.innerHTML = data.hintHtml;
+                            hintText.style.display = "block";
                             // Update the hint count display
                             if (hintCount) {
                                 var currentHintCount = parseInt(hintCount.textContent.split(": ")[1]);
-                                hintCount.textContent = "Hints used: " + (currentHintCount + 1);
+                                hintCount.textContent = t("hints_used") + " " + (currentHintCount + 1);
                             }
                         } else {
                             console.error("Failed to increment hint count.");
+                            hintButton.disabled = false;
                         }
                     })
                     .catch(error => {
                         console.error("There was a problem with the fetch operation:", error);
+                        hintButton.disabled = false;
                     });
             }
         });
@@ -95,7 +87,7 @@ document.addEventListener("DOMContentLoaded", function () {
 
                 // Fetch and reload the current URL
                 // const currentUrl = window.location.href;
-                hintButton.disabled = false;
+                hintButton.disabled = hintRevealed;
                 timerRemainingElement.textContent = "";
 
                 // window.location.reload(); // Reload the page with the current URL
@@ -114,7 +106,7 @@ document.addEventListener("DOMContentLoaded", function () {
                 console.log('Formatted time:', `${hours}:${minutes}:${seconds}`);
             }
 
-            timerRemainingElement.textContent = `Hint Timer: ${minutes}:${seconds}`;
+            timerRemainingElement.textContent = `${t("hint_timer")} ${minutes}:${seconds}`;
 
         }
 
@@ -0,0 +1,5 @@
+// Returns a message in the language of the page. The messages are rendered
+// into window.messages by the server; the key is shown if one is missing.
+function t(key) {
+    return (window.messages && window.messages[key]) || key;
+}
@@ -0,0 +1,63 @@
+document.addEventListener("DOMContentLoaded", function () {
+    // Ordering puzzles: the hidden inputs are sent in the order of the list
+    const orderList = document.getElementById("order-list");
+    if (orderList) {
+        let dragged = null;
+
+        orderList.addEventListener("click", function (event) {
+            const button = event.target.closest("button");
+            if (!button) {
+                return;
+            }
+            const item = button.closest("li");
+            if (button.classList.contains("order-up") && item.previousElementSibling) {
+                orderList.insertBefore(item, item.previousElementSibling);
+            } else if (button.classList.contains("order-down") && item.nextElementSibling) {
+                orderList.insertBefore(item.nextElementSibling, item);
+            }
+        });
+
+        // Drag and drop for mouse users, the buttons also work on phones
+        orderList.addEventListener("dragstart", function (event) {
+            dragged = event.target.closest("li");
+            event.dataTransfer.effectAllowed = "move";
+        });
+
+        orderList.addEventListener("dragover", function (event) {
+            const target = event.target.closest("li");
+            if (!dragged || !target || target === dragged) {
+                return;
+            }
+            event.preventDefault();
+
+            const box = target.getBoundingClientRect();
+            const after = event.clientY > box.top + box.height / 2;
+            orderList.insertBefore(dragged, after ? target.nextElementSibling : target);
+        });
+
+        orderList.addEventListener("dragend", function () {
+            dragged = null;
+        });
+    }
+
+    // Letter grids: move to the next cell after typing a letter
+    const grid = document.getElementById("puzzle-grid");
+    if (grid) {
+        const cells = Array.from(grid.querySelectorAll("input"));
+
+        cells.forEach(function (cell, index) {
+            cell.addEventListener("input", function () {
+                cell.value = cell.value.toUpperCase();
+                if (cell.value && index + 1 < cells.length) {
+                    cells[index + 1].focus();
+                }
+            });
+
+            cell.addEventListener("keydown", function (event) {
+                if (event.key === "Backspace" && !cell.value && index > 0) {
+                    cells[index - 1].focus();
+                }
+            });
+        });
+    }
+});
@@ -37,7 +37,7 @@ document.addEventListener("DOMContentLoaded", function() {
             }
             
             if (remainingTime <= 0) {
-                timerRemainingElement.textContent = "Time's up!";
+                timerRemainingElement.textContent = t("times_up");
                 clearInterval(timerInterval);
                 if (DEBUG) {
                     console.log("Timer expired");
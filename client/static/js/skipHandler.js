@@ -0,0 +1,43 @@
+document.addEventListener("DOMContentLoaded", function () {
+    const skipForm = document.getElementById("skip-form");
+    const skipButton = document.getElementById("skip-btn");
+    const skipTimerElement = document.getElementById("skip-timer");
+    const unlockTimeElement = document.getElementById("skip-unlock-time");
+
+    if (!skipForm || !skipButton) {
+        return;
+    }
+
+    // Ask for confirmation before skipping the quest
+    skipForm.addEventListener("submit", function (event) {
+        if (!confirm(t("skip_confirm"))) {
+            event.preventDefault();
+        }
+    });
+
+    // Keep the button locked until the quest becomes skippable
+    if (unlockTimeElement && !skipButton.disabled) {
+        const unlockTime = new Date(unlockTimeElement.getAttribute("data-unlock-time"));
+
+        function updateSkipTimer() {
+            const remainingTime = unlockTime - new Date();
+
+            if (remainingTime <= 0) {
+                skipButton.disabled = false;
+                skipTimerElement.textContent = "";
+                clearInterval(skipInterval);
+                return;
+            }
+
+            skipButton.disabled = true;
+
+            const minutes = String(Math.floor(remainingTime / (1000 * 60))).padStart(2, '0');
+            const seconds = String(Math.floor((remainingTime % (1000 * 60)) / 1000)).padStart(2, '0');
+
+            skipTimerElement.textContent = `(${minutes}:${seconds})`;
+        }
+
+        const skipInterval = setInterval(updateSkipTimer, 1000);
+        updateSkipTimer();
+    }
+});
@@ -5,8 +5,8 @@ document.addEventListener("DOMContentLoaded", function() {
 
     if (startTimeElement && countdownElement) {
         const startTime = new Date(startTimeElement.getAttribute("data-start-time"));
-        const twoHours = 2 * 60 * 60 * 1000; // 2 hours in milliseconds
-        const endTime = new Date(startTime.getTime() + twoHours);
+        const duration = parseInt(startTimeElement.getAttribute("data-game-duration"), 10) || 2 * 60 * 60 * 1000; // 2 hours unless configured
+        const endTime = new Date(startTime.getTime() + duration);
 
         function updateCountdown() {
             const now = new Date();
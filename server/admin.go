@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles of the organizers
+const (
+	roleAdmin   = "admin"   // Edits the games and everything else
+	roleJudge   = "judge"   // Reviews photos and overrides answers
+	roleMarshal = "marshal" // Confirms checkpoints on site
+	roleViewer  = "viewer"  // Only watches the dashboards
+)
+
+// Permissions of the organizer pages
+const (
+	permView    = "view"    // Dashboards: timeline, analytics, check-ins and results
+	permJudge   = "judge"   // Photo review and answer overrides
+	permMarshal = "marshal" // Checkpoint codes and check-ins without GPS
+	permEdit    = "edit"    // Quest editor and media library
+)
+
+// rolePermissions lists what every role may do
+var rolePermissions = map[string][]string{
+	roleAdmin:   {permView, permJudge, permMarshal, permEdit},
+	roleJudge:   {permView, permJudge},
+	roleMarshal: {permView, permMarshal},
+	roleViewer:  {permView},
+}
+
+// organizer is an account of the organizer pages. Password is a PBKDF2 hash,
+// apart from the ADMIN_USER account whose password comes from the environment.
+type organizer struct {
+	Username string
+	Password string
+	Role     string
+	plain    bool
+}
+
+// can reports whether the role of the organizer has a permission
+func (o *organizer) can(permission string) bool {
+	for _, p := range rolePermissions[o.Role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Path of the organizers file, see useDataDir
+var organizersPath = "data/organizers.csv"
+
+var (
+	// organizers maps a username to its account. Like the games it is only
+	// written while loading.
+	organizers = map[string]*organizer{}
+
+	// verified remembers the hashes of the passwords that matched, so a
+	// PBKDF2 hash isn't computed again on every request
+	verified   = map[string][32]byte{}
+	verifiedMu sync.Mutex
+)
+
+// loadOrganizers reads the organizers file, with the columns Username,
+// Password and Role. Passwords are hashes made with the hash-password
+// command.
+func loadOrganizers(filePath string) (map[string]*organizer, error) {
+	records, err := readCSV(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := map[string]*organizer{}
+	for i, record := range records {
+		account := &organizer{
+			Username: strings.TrimSpace(record[0]),
+			Password: strings.TrimSpace(optionalField(record, 1)),
+			Role:     strings.TrimSpace(optionalField(record, 2)),
+		}
+		switch {
+		case account.Username == "" || loaded[account.Username] != nil:
+			return nil, fmt.Errorf("row %d: missing or duplicate username %q", i+2, account.Username)
+		case !strings.HasPrefix(account.Password, passwordHashPrefix):
+			return nil, fmt.Errorf("row %d: the password of %s is not a hash, make one with the hash-password command", i+2, account.Username)
+		case rolePermissions[account.Role] == nil:
+			return nil, fmt.Errorf("row %d: unknown role %q, use admin, judge, marshal or viewer", i+2, account.Role)
+		}
+		loaded[account.Username] = account
+	}
+	return loaded, nil
+}
+
+// setupOrganizers loads the organizer accounts: those of the organizers file
+// if there is one, and an admin with the ADMIN_USER and ADMIN_PASS
+// credentials of the environment. Without any account the admin area stays
+// closed.
+func setupOrganizers() error {
+	loaded := map[string]*organizer{}
+	if _, err := os.Stat(organizersPath); err == nil {
+		if loaded, err = loadOrganizers(organizersPath); err != nil {
+			return fmt.Errorf("organizers: %v", err)
+		}
+	}
+
+	user, pass := os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASS")
+	if user != "" && pass != "" {
+		if loaded[user] != nil {
+			return fmt.Errorf("organizers: %s is both ADMIN_USER and in %s", user, organizersPath)
+		}
+		loaded[user] = &organizer{Username: user, Password: pass, Role: roleAdmin, plain: true}
+	}
+
+	organizers = loaded
+	verifiedMu.Lock()
+	verified = map[string][32]byte{}
+	verifiedMu.Unlock()
+	return nil
+}
+
+// authenticate returns the organizer of a username and password
+func authenticate(username, password string) (*organizer, bool) {
+	account, ok := organizers[username]
+	if !ok {
+		return nil, false
+	}
+	if account.plain {
+		return account, subtle.ConstantTimeCompare([]byte(password), []byte(account.Password)) == 1
+	}
+
+	sum := sha256.Sum256([]byte(password))
+	verifiedMu.Lock()
+	known, ok := verified[username]
+	verifiedMu.Unlock()
+	if ok && subtle.ConstantTimeCompare(sum[:], known[:]) == 1 {
+		return account, true
+	}
+
+	if !checkPasswordHash(account.Password, password) {
+		return nil, false
+	}
+	verifiedMu.Lock()
+	verified[username] = sum
+	verifiedMu.Unlock()
+	return account, true
+}
+
+// Passwords are hashed with PBKDF2-HMAC-SHA256 and stored as
+// pbkdf2-sha256$iterations$salt$key, with the salt and key in base64
+const (
+	passwordHashPrefix = "pbkdf2-sha256$"
+	passwordIterations = 310000
+	passwordSaltSize   = 16
+	passwordKeySize    = 32
+)
+
+// pbkdf2 derives a key from a password as in RFC 8018, with HMAC-SHA256
+func pbkdf2(password, salt []byte, iterations, keySize int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	var counter [4]byte
+	for block := uint32(1); len(key) < keySize; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], block)
+		prf.Write(counter[:])
+		u := prf.Sum(nil)
+
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keySize]
+}
+
+// passwordHash hashes a password with a salt
+func passwordHash(password string, salt []byte, iterations int) string {
+	key := pbkdf2([]byte(password), salt, iterations, passwordKeySize)
+	return fmt.Sprintf("%s%d$%s$%s", passwordHashPrefix, iterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// hashPassword hashes a password with a new random salt
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return passwordHash(password, salt, passwordIterations), nil
+}
+
+// checkPasswordHash reports whether a password matches a hash
+func checkPasswordHash(hash, password string) bool {
+	parts := strings.Split(strings.TrimPrefix(hash, passwordHashPrefix), "$")
+	if !strings.HasPrefix(hash, passwordHashPrefix) || len(parts) != 3 {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[0])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(key) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare(pbkdf2([]byte(password), salt, iterations, len(key)), key) == 1
+}
+
+// runHashPassword is the hash-password command: it reads a password from
+// standard input and prints its hash for the organizers file
+func runHashPassword(args []string) error {
+	flags := flag.NewFlagSet("hash-password", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return fmt.Errorf("no password: %v", err)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
+
+type organizerKey struct{}
+
+// currentOrganizer returns the organizer of a request of the admin area
+func currentOrganizer(r *http.Request) *organizer {
+	account, _ := r.Context().Value(organizerKey{}).(*organizer)
+	if account == nil {
+		return &organizer{}
+	}
+	return account
+}
+
+// requireOrganizer protects an organizer handler with HTTP basic
+// authentication and lets only the roles with the permission in. Every
+// change and every refused request is recorded in the event log.
+func requireOrganizer(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		var account *organizer
+		if ok {
+			// Guesses are refused before the password is hashed
+			key := loginKey(r, user)
+			if wait := loginWait(key); wait > 0 {
+				auditRefused(r, user, http.StatusTooManyRequests)
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())+1))
+				http.Error(w, "Твърде много неуспешни опити, опитайте по-късно", http.StatusTooManyRequests)
+				return
+			}
+			if account, ok = authenticate(user, pass); ok {
+				forgetLoginFailures(key)
+			} else {
+				recordLoginFailure(key)
+				auditRefused(r, user, http.StatusUnauthorized)
+			}
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Treasure Hunt Admin", charset="UTF-8"`)
+			http.Error(w, "Неоторизиран достъп", http.StatusUnauthorized)
+			return
+		}
+		if !account.can(permission) {
+			auditRefused(r, account.Username, http.StatusForbidden)
+			http.Error(w, "Нямате права за тази страница", http.StatusForbidden)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), organizerKey{}, account))
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		if !sameOrigin(r) {
+			auditRefused(r, account.Username, http.StatusForbidden)
+			http.Error(w, "Заявката идва от друг сайт", http.StatusForbidden)
+			return
+		}
+
+		// Record every change made by an organizer
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		auditAdmin(r, account.Username, account.Role, recorder.status)
+	}
+}
+
+// After loginFreeAttempts failed logins for a username from an address,
+// every further attempt waits twice as long as the one before, up to
+// loginMaxWait
+const (
+	loginFreeAttempts = 5
+	loginMaxWait      = 15 * time.Minute
+)
+
+// loginFailures counts the failed logins for a username from an address
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+var (
+	failedLogins   = map[string]*loginFailures{}
+	failedLoginsMu sync.Mutex
+)
+
+// loginKey returns the key of the failed logins of a request: its address
+// with the username it tries. Keyed on the username alone, anyone could lock
+// an organizer out by guessing wrong passwords.
+func loginKey(r *http.Request, username string) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host + " " + username
+}
+
+// loginWait returns how long the key has to wait before the next login
+func loginWait(key string) time.Duration {
+	failedLoginsMu.Lock()
+	defer failedLoginsMu.Unlock()
+
+	failures := failedLogins[key]
+	if failures == nil || failures.count < loginFreeAttempts {
+		return 0
+	}
+	delay := min(time.Second<<min(failures.count-loginFreeAttempts, 10), loginMaxWait)
+	return time.Until(failures.last.Add(delay))
+}
+
+// recordLoginFailure counts a failed login for the key
+func recordLoginFailure(key string) {
+	failedLoginsMu.Lock()
+	defer failedLoginsMu.Unlock()
+
+	now := time.Now()
+	for key, failures := range failedLogins {
+		if now.Sub(failures.last) > loginMaxWait {
+			delete(failedLogins, key)
+		}
+	}
+	if failedLogins[key] == nil {
+		failedLogins[key] = &loginFailures{}
+	}
+	failedLogins[key].count++
+	failedLogins[key].last = now
+}
+
+// forgetLoginFailures clears the failed logins of the key after a login
+func forgetLoginFailures(key string) {
+	failedLoginsMu.Lock()
+	defer failedLoginsMu.Unlock()
+	delete(failedLogins, key)
+}
+
+// sameOrigin reports whether a request was sent by a page of this site. The
+// browser remembers the basic authentication, so without this check any other
+// site could make it post forms to the admin area. Requests without Origin and
+// Referer don't come from a browser and are let through.
+func sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+
+	parsed, err := url.Parse(source)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	if strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	public, err := url.Parse(publicURL)
+	return err == nil && public.Host != "" && strings.EqualFold(parsed.Host, public.Host)
+}
+
+// auditRefused records a refused request of the admin area in the event log.
+// The body of the request is left unread, as it may come from anyone.
+func auditRefused(r *http.Request, user string, status int) {
+	logEvent(event{Type: eventAdmin, RequestID: requestID(r), Details: map[string]string{
+		"user":   user,
+		"method": r.Method,
+		"path":   r.URL.Path,
+		"status": strconv.Itoa(status),
+	}})
+}
+
+// auditAdmin records a request of an organizer in the event log
+func auditAdmin(r *http.Request, user, role string, status int) {
+	logEvent(event{Type: eventAdmin, Game: adminGame(r).Key, RequestID: requestID(r), Details: map[string]string{
+		"user":   user,
+		"role":   role,
+		"method": r.Method,
+		"path":   r.URL.Path,
+		"query":  r.URL.RawQuery,
+		"action": r.PostFormValue("action"),
+		"status": strconv.Itoa(status),
+	}})
+}
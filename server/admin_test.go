@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	publicURL = "https://hunt.example.org"
+	defer func() { publicURL = "" }()
+
+	tests := []struct {
+		name, origin, referer string
+		want                  bool
+	}{
+		{"no headers", "", "", true},
+		{"same host", "http://localhost:8080", "", true},
+		{"public url", "https://hunt.example.org", "", true},
+		{"referer of the site", "", "http://localhost:8080/admin/review", true},
+		{"other site", "https://evil.example.com", "", false},
+		{"other site referer", "", "https://evil.example.com/form", false},
+		{"origin wins over referer", "https://evil.example.com", "http://localhost:8080/admin", false},
+		{"opaque origin", "null", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "http://localhost:8080/admin/review/answer", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			if got := sameOrigin(r); got != tt.want {
+				t.Errorf("sameOrigin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailedLoginsBackOff(t *testing.T) {
+	handler := newTestServer(t)
+	defer func() { failedLogins = map[string]*loginFailures{} }()
+
+	login := func(user, pass, address string) int {
+		r := httptest.NewRequest("GET", "/admin/timeline", nil)
+		r.SetBasicAuth(user, pass)
+		r.RemoteAddr = address
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i := 0; i < loginFreeAttempts; i++ {
+		if code := login(testAdminUser, "guess", "192.0.2.1:1234"); code != 401 {
+			t.Fatalf("attempt %d: status %d, want 401", i+1, code)
+		}
+	}
+	tests := []struct {
+		name, user, pass, address string
+		want                      int
+	}{
+		{"right password after the guesses", testAdminUser, testAdminPass, "192.0.2.1:1234", 429},
+		{"same address from another port", testAdminUser, "guess", "192.0.2.1:5678", 429},
+		// The guesses don't lock the organizer out elsewhere
+		{"same username from another address", testAdminUser, testAdminPass, "192.0.2.2:1234", 200},
+		{"other username from the same address", "someone", "guess", "192.0.2.1:5678", 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := login(tt.user, tt.pass, tt.address); code != tt.want {
+				t.Errorf("status %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Difficulty flags of a quest
+const (
+	flagSlow     = "slow"     // Median solve time over twice the usual
+	flagFast     = "fast"     // Median solve time under half the usual
+	flagSkipped  = "skipped"  // Half of the teams skipped or failed it
+	flagHinted   = "hinted"   // Three of four teams needed the hint
+	flagGuessing = "guessing" // Three wrong answers per team or more
+)
+
+const (
+	minTeamsFlag = 2 // Teams that must reach a quest before it is flagged
+	topWrong     = 5 // Most common wrong answers listed per quest
+)
+
+// Names of the difficulty flags for the organizers
+var flagLabels = map[string]string{
+	flagSlow:     "бавна",
+	flagFast:     "лесна",
+	flagSkipped:  "често пропускана",
+	flagHinted:   "нужна подсказка",
+	flagGuessing: "много грешни отговори",
+}
+
+// answerCount is a wrong answer and how many times it was given
+type answerCount struct {
+	Answer string `json:"answer"`
+	Count  int    `json:"count"`
+}
+
+// questAnalytics describes how hard a quest was for the teams
+type questAnalytics struct {
+	Rank          int           `json:"rank"` // 1 is the hardest
+	Key           string        `json:"key"`
+	Position      int           `json:"position"`
+	Reached       int           `json:"reached"` // Teams that started or closed it
+	Solved        int           `json:"solved"`
+	MedianSeconds int           `json:"medianSeconds"`
+	MinSeconds    int           `json:"minSeconds"`
+	MaxSeconds    int           `json:"maxSeconds"`
+	HintRate      float64       `json:"hintRate"` // Share of the teams that reached it
+	SkipRate      float64       `json:"skipRate"`
+	FailRate      float64       `json:"failRate"`
+	WrongAnswers  int           `json:"wrongAnswers"`
+	CommonWrong   []answerCount `json:"commonWrong"`
+	Difficulty    float64       `json:"difficulty"`
+	Flags         []string      `json:"flags"`
+}
+
+// medianSeconds returns the median of a list of durations
+func medianSeconds(seconds []int) int {
+	if len(seconds) == 0 {
+		return 0
+	}
+	sorted := append([]int(nil), seconds...)
+	sort.Ints(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// ratio returns part/whole, 0 for nothing
+func ratio(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
+
+// collectAnalytics computes the analytics of every quest of a game,
+// hardest first. The difficulty adds up the solve time relative to the
+// usual quest, the share of teams skipping or failing it and half the
+// share of teams using its hint.
+func collectAnalytics(game *Game) []questAnalytics {
+	results := collectResults(game)
+
+	// Wrong answers as the teams wrote them, grouped regardless of case
+	var records []GameEvent
+	db.Where("type = ? AND team_name IN (?) AND run = ?", eventWrongAnswer, gameTeamNames(game.Key), currentRun).Find(&records)
+	wrongAnswers := map[string]map[string]int{}
+	for _, record := range records {
+		answer := strings.ToLower(strings.TrimSpace(record.details()["answer"]))
+		if answer == "" {
+			continue
+		}
+		if wrongAnswers[record.QuestKey] == nil {
+			wrongAnswers[record.QuestKey] = map[string]int{}
+		}
+		wrongAnswers[record.QuestKey][answer]++
+	}
+
+	analytics := make([]questAnalytics, len(results.Quests))
+	var medians []int
+	for i, stats := range results.Quests {
+		a := questAnalytics{Key: stats.Key, Position: stats.Position, WrongAnswers: stats.WrongAnswers}
+
+		var times []int
+		hinted, skipped, failed := 0, 0, 0
+		for _, team := range results.Teams {
+			for _, quest := range team.Quests {
+				if quest.Key != stats.Key || (quest.Started.IsZero() && quest.Result == "open") {
+					continue
+				}
+				a.Reached++
+				if quest.Hints > 0 {
+					hinted++
+				}
+				switch quest.Result {
+				case "completed":
+					a.Solved++
+					if quest.Seconds > 0 {
+						times = append(times, quest.Seconds)
+					}
+				case "skipped", "timed_out":
+					skipped++
+				case "failed":
+					failed++
+				}
+			}
+		}
+
+		if len(times) > 0 {
+			a.MedianSeconds = medianSeconds(times)
+			a.MinSeconds, a.MaxSeconds = times[0], times[0]
+			for _, seconds := range times {
+				a.MinSeconds = min(a.MinSeconds, seconds)
+				a.MaxSeconds = max(a.MaxSeconds, seconds)
+			}
+			medians = append(medians, a.MedianSeconds)
+		}
+		a.HintRate = ratio(hinted, a.Reached)
+		a.SkipRate = ratio(skipped, a.Reached)
+		a.FailRate = ratio(failed, a.Reached)
+
+		for answer, count := range wrongAnswers[stats.Key] {
+			a.CommonWrong = append(a.CommonWrong, answerCount{Answer: answer, Count: count})
+		}
+		sort.Slice(a.CommonWrong, func(i, j int) bool {
+			if a.CommonWrong[i].Count != a.CommonWrong[j].Count {
+				return a.CommonWrong[i].Count > a.CommonWrong[j].Count
+			}
+			return a.CommonWrong[i].Answer < a.CommonWrong[j].Answer
+		})
+		if len(a.CommonWrong) > topWrong {
+			a.CommonWrong = a.CommonWrong[:topWrong]
+		}
+
+		analytics[i] = a
+	}
+
+	// The usual quest is the median of the quest medians
+	usual := medianSeconds(medians)
+	for i := range analytics {
+		a := &analytics[i]
+		relative := 1.0
+		if usual > 0 && a.MedianSeconds > 0 {
+			relative = float64(a.MedianSeconds) / float64(usual)
+		}
+		a.Difficulty = relative + a.SkipRate + a.FailRate + a.HintRate/2
+
+		if a.Reached < minTeamsFlag {
+			continue
+		}
+		if usual > 0 && a.MedianSeconds > 2*usual {
+			a.Flags = append(a.Flags, flagSlow)
+		}
+		if usual > 0 && a.MedianSeconds > 0 && a.MedianSeconds < usual/2 {
+			a.Flags = append(a.Flags, flagFast)
+		}
+		if a.SkipRate+a.FailRate >= 0.5 {
+			a.Flags = append(a.Flags, flagSkipped)
+		}
+		if a.HintRate >= 0.75 {
+			a.Flags = append(a.Flags, flagHinted)
+		}
+		if ratio(a.WrongAnswers, a.Reached) >= 3 {
+			a.Flags = append(a.Flags, flagGuessing)
+		}
+	}
+
+	sort.SliceStable(analytics, func(i, j int) bool { return analytics[i].Difficulty > analytics[j].Difficulty })
+	for i := range analytics {
+		analytics[i].Rank = i + 1
+	}
+	return analytics
+}
+
+// formatSeconds writes a number of seconds as a duration, empty for none
+func formatSeconds(seconds int) string {
+	if seconds == 0 {
+		return ""
+	}
+	return formatDuration(time.Duration(seconds) * time.Second)
+}
+
+// formatWrong writes the most common wrong answers, e.g. "1907 (3), 1908 (1)"
+func formatWrong(answers []answerCount) string {
+	parts := make([]string, len(answers))
+	for i, answer := range answers {
+		parts[i] = fmt.Sprintf("%s (%d)", answer.Answer, answer.Count)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// handleAdminAnalytics shows the quests of the organizer's game from the
+// hardest to the easiest
+func handleAdminAnalytics(w http.ResponseWriter, r *http.Request) {
+	type row struct {
+		questAnalytics
+		Median, Min, Max string
+		Hint, Skip, Fail string
+		Wrong            string
+		FlagNames        []string
+	}
+	data := struct {
+		GameSwitcher gameSwitcher
+		Quests       []row
+	}{
+		GameSwitcher: newGameSwitcher(r),
+	}
+
+	percent := func(rate float64) string { return fmt.Sprintf("%.0f%%", rate*100) }
+	for _, a := range collectAnalytics(adminGame(r)) {
+		quest := row{
+			questAnalytics: a,
+			Median:         formatSeconds(a.MedianSeconds),
+			Min:            formatSeconds(a.MinSeconds),
+			Max:            formatSeconds(a.MaxSeconds),
+			Hint:           percent(a.HintRate),
+			Skip:           percent(a.SkipRate),
+			Fail:           percent(a.FailRate),
+			Wrong:          formatWrong(a.CommonWrong),
+		}
+		for _, name := range a.Flags {
+			quest.FlagNames = append(quest.FlagNames, flagLabels[name])
+		}
+		data.Quests = append(data.Quests, quest)
+	}
+
+	if err := templates.ExecuteTemplate(w, "admin_analytics.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeAnalyticsText writes the analytics as a table for the terminal
+func writeAnalyticsText(w io.Writer, analytics []questAnalytics) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Rank\tQuest\tReached\tSolved\tMedian\tMin\tMax\tHints\tSkips\tFails\tWrong\tFlags\tCommon wrong answers")
+	for _, a := range analytics {
+		fmt.Fprintf(table, "%d\t%s\t%d\t%d\t%s\t%s\t%s\t%.0f%%\t%.0f%%\t%.0f%%\t%d\t%s\t%s\n",
+			a.Rank, a.Key, a.Reached, a.Solved,
+			formatSeconds(a.MedianSeconds), formatSeconds(a.MinSeconds), formatSeconds(a.MaxSeconds),
+			a.HintRate*100, a.SkipRate*100, a.FailRate*100, a.WrongAnswers,
+			strings.Join(a.Flags, ","), formatWrong(a.CommonWrong))
+	}
+	return table.Flush()
+}
+
+// runAnalytics is the analytics command: it prints the quest analytics of a
+// game of the database of a configuration
+func runAnalytics(args []string) error {
+	flags := flag.NewFlagSet("analytics", flag.ContinueOnError)
+	format := flags.String("format", "text", "text or json")
+	gameKey := flags.String("game", "", "key of the game, the first game if empty")
+
+	done, err := setupCommand(flags, args)
+	if err != nil {
+		return err
+	}
+	defer done()
+	game, err := commandGame(*gameKey)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "text":
+		return writeAnalyticsText(os.Stdout, collectAnalytics(game))
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(collectAnalytics(game))
+	}
+	return fmt.Errorf("unknown format %q, use text or json", *format)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// analyticsOf returns the analytics of a quest key in a game
+func analyticsOf(t *testing.T, game *Game, key string) questAnalytics {
+	t.Helper()
+	for _, a := range collectAnalytics(game) {
+		if a.Key == key {
+			return a
+		}
+	}
+	t.Fatalf("%s has no analytics of %s", game.Key, key)
+	return questAnalytics{}
+}
+
+// Two games with a quest of the same key count only their own teams
+func TestAnalyticsStayInTheirGame(t *testing.T) {
+	handler := newTestServer(t)
+	mainGame, _ := findGame(defaultGameKey)
+	other := addTestGame(t, "analytics-other")
+	addTestTeam(t, "ANALYTICS1")
+	addTestTeam(t, "ANALYTICS2")
+	moveTestTeam("ANALYTICS2", other.Key)
+
+	const key = "shared-analytics"
+	add := func(teamName, gameKey string, quest Quest) {
+		definition := QuestDefinition{Game: gameKey, Key: key, Position: 99}
+		if err := db.Create(&definition).Error; err != nil {
+			t.Fatal(err)
+		}
+		quest.TeamName, quest.QuestNumber, quest.DefinitionID = teamName, 1, definition.ID
+		if err := db.Create(&quest).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("ANALYTICS1", mainGame.Key, Quest{Completed: true, HintsUsed: 1})
+	add("ANALYTICS2", other.Key, Quest{Skipped: true})
+
+	wrong := func(teamName, answer string) {
+		logEvent(event{Type: eventWrongAnswer, Team: teamName, Quest: 1, QuestKey: key, Details: map[string]string{"answer": answer}})
+	}
+	wrong("ANALYTICS1", "main-answer")
+	wrong("ANALYTICS1", "Main-Answer ")
+	wrong("ANALYTICS2", "other-answer")
+
+	a := analyticsOf(t, mainGame, key)
+	if a.Reached != 1 || a.Solved != 1 || a.SkipRate != 0 || a.HintRate != 1 {
+		t.Errorf("main game = %+v, want only its own team", a)
+	}
+	if len(a.CommonWrong) != 1 || a.CommonWrong[0] != (answerCount{"main-answer", 2}) {
+		t.Errorf("main game wrong answers = %v", a.CommonWrong)
+	}
+
+	b := analyticsOf(t, other, key)
+	if b.Reached != 1 || b.Solved != 0 || b.SkipRate != 1 || b.HintRate != 0 {
+		t.Errorf("other game = %+v, want only its own team", b)
+	}
+	if len(b.CommonWrong) != 1 || b.CommonWrong[0] != (answerCount{"other-answer", 1}) {
+		t.Errorf("other game wrong answers = %v", b.CommonWrong)
+	}
+	if all := collectAnalytics(other); len(all) != 1 {
+		t.Errorf("other game has %d quests, want only its own", len(all))
+	}
+
+	// The page shows the game the organizer works on
+	for gameKey, shown := range map[string]string{mainGame.Key: "main-answer", other.Key: "other-answer"} {
+		w := adminGet(handler, "/admin/analytics", gameKey)
+		body := w.Body.String()
+		if w.Code != 200 || !strings.Contains(body, shown) {
+			t.Errorf("analytics of %s: status %d, missing %s", gameKey, w.Code, shown)
+		}
+		for _, hidden := range []string{"main-answer", "other-answer"} {
+			if hidden != shown && strings.Contains(body, hidden) {
+				t.Errorf("analytics of %s show %s", gameKey, hidden)
+			}
+		}
+	}
+}
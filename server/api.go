@@ -0,0 +1,442 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The JSON API of the player flow, version 1. Every endpoint answers JSON,
+// errors as {"error": {"code": …, "message": …}} where the code is stable
+// and the message is in the team's language.
+
+// Lifetime of the bearer tokens, long enough for a whole game day
+const apiTokenLifetime = 24 * time.Hour
+
+// apiToken returns the bearer token of a team, as name.expiry.signature. It
+// is signed with the media secret, so it stays valid across restarts only
+// when MEDIA_SECRET is set.
+func apiToken(teamName string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(teamName)) + "." + expiry + "." +
+		base64.RawURLEncoding.EncodeToString(apiSignature(teamName, expiry))
+}
+
+// apiSignature signs a token with the password of the team, so a new
+// password ends the tokens given for the old one
+func apiSignature(teamName, expiry string) []byte {
+	mu.Lock()
+	var password string
+	if team, ok := teams[teamName]; ok {
+		password = team.Password
+	}
+	mu.Unlock()
+
+	mac := hmac.New(sha256.New, mediaSecret)
+	for _, part := range []string{"api", teamName, expiry, password} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+	return mac.Sum(nil)
+}
+
+// apiTeam returns the team of the bearer token of an API request, if it has
+// a valid one that hasn't expired. The cookie of the player pages holds just
+// the team name, so it isn't accepted.
+func apiTeam(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	name, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !time.Now().Before(time.Unix(expires, 0)) {
+		return "", false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, apiSignature(string(name), parts[1])) {
+		return "", false
+	}
+
+	mu.Lock()
+	_, ok = teams[string(name)]
+	mu.Unlock()
+	return string(name), ok
+}
+
+// writeJSON answers a value as JSON
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Error writing API response: %v", err)
+	}
+}
+
+// apiError answers an error. Errors other than refused actions are internal.
+func apiError(w http.ResponseWriter, r *http.Request, teamName string, err error) {
+	refused, ok := err.(*actionError)
+	if !ok {
+		log.Printf("API error: %v", err)
+		refused = refuse(http.StatusInternalServerError, "internal_error")
+	}
+
+	lang := requestLanguage(r, teamName)
+	writeJSON(w, refused.Status, map[string]interface{}{
+		"error": map[string]string{
+			"code":    refused.Code,
+			"message": translate(lang, refused.Code),
+		},
+	})
+}
+
+// apiParams reads the parameters of a request from its JSON object, its
+// form or its query. JSON arrays become repeated values, as in a form.
+func apiParams(r *http.Request) (url.Values, error) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "application/json":
+		var body map[string]interface{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&body); err != nil {
+			return nil, refuse(http.StatusBadRequest, "bad_request")
+		}
+
+		params := url.Values{}
+		for key, value := range body {
+			if values, ok := value.([]interface{}); ok {
+				for _, value := range values {
+					params.Add(key, fmt.Sprint(value))
+				}
+			} else if value != nil {
+				params.Set(key, fmt.Sprint(value))
+			}
+		}
+		return params, nil
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB limit for uploaded files
+			return nil, refuse(http.StatusBadRequest, "form_error")
+		}
+		return r.Form, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, refuse(http.StatusBadRequest, "form_error")
+	}
+	return r.Form, nil
+}
+
+// apiQuest loads the quest named by the questId parameter
+func apiQuest(teamName string, params url.Values) (Quest, error) {
+	quest, err := teamQuest(teamName, params.Get("questId"))
+	if err != nil {
+		return quest, refuse(http.StatusNotFound, "quest_not_found")
+	}
+	return quest, nil
+}
+
+// teamAPI serves an endpoint to a logged in team
+func teamAPI(method string, handler func(w http.ResponseWriter, r *http.Request, teamName string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			apiError(w, r, "", refuse(http.StatusMethodNotAllowed, "invalid_method"))
+			return
+		}
+
+		teamName, ok := apiTeam(r)
+		if !ok {
+			apiError(w, r, "", refuse(http.StatusUnauthorized, "unauthorized"))
+			return
+		}
+
+		if err := handler(w, r, teamName); err != nil {
+			apiError(w, r, teamName, err)
+		}
+	}
+}
+
+// handleAPILogin checks the credentials of a team and returns its token
+func handleAPILogin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		apiError(w, r, "", refuse(http.StatusMethodNotAllowed, "invalid_method"))
+		return
+	}
+
+	params, err := apiParams(r)
+	if err != nil {
+		apiError(w, r, "", err)
+		return
+	}
+
+	teamName, err := loginTeam(r, params.Get("game"), params.Get("username"), params.Get("password"))
+	if err != nil {
+		apiError(w, r, "", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"game":     teamGame(teamName).Key,
+		"team":     teamName,
+		"token":    apiToken(teamName, time.Now().Add(apiTokenLifetime)),
+		"language": requestLanguage(r, teamName),
+	})
+}
+
+// apiSkipState tells whether the team may skip its quest
+type apiSkipState struct {
+	Allowed   bool       `json:"allowed"`
+	Left      int        `json:"left"` // -1 for unlimited skips
+	UnlocksAt *time.Time `json:"unlocksAt,omitempty"`
+}
+
+// apiQuestState is the current quest of a team with its timers
+type apiQuestState struct {
+	Game             string        `json:"game"`
+	Team             string        `json:"team"`
+	Language         string        `json:"language"`
+	StartedAt        time.Time     `json:"startedAt"`
+	GameEndsAt       time.Time     `json:"gameEndsAt"`
+	TotalQuests      int64         `json:"totalQuests"`
+	Quest            questView     `json:"quest"`
+	QuestTimerEndsAt *time.Time    `json:"questTimerEndsAt,omitempty"`
+	HintAvailableAt  *time.Time    `json:"hintAvailableAt,omitempty"`
+	CanAnswer        bool          `json:"canAnswer"` // False while a wait timer runs or a check-in is missing
+	Skip             apiSkipState  `json:"skip"`
+	OtherQuests      []questChoice `json:"otherQuests"`
+}
+
+// handleAPIQuest returns the quest the team is working on and starts it
+func handleAPIQuest(w http.ResponseWriter, r *http.Request, teamName string) error {
+	if gameOver(teamName) {
+		return refuse(http.StatusConflict, "game_finished")
+	}
+
+	quest, open, _ := currentQuest(teamName)
+	startQuest(r, &quest)
+
+	// Deadline timers close the quest when they run out, move on to the next
+	if quest.deadlinePassed() && expireQuest(r, &quest) {
+		return handleAPIQuest(w, r, teamName)
+	}
+
+	mu.Lock()
+	stopwatch := teams[teamName].Stopwatch
+	mu.Unlock()
+
+	game := teamGame(teamName)
+	lang := requestLanguage(r, teamName)
+	state := apiQuestState{
+		Game:        game.Key,
+		Team:        teamName,
+		Language:    lang,
+		StartedAt:   stopwatch,
+		GameEndsAt:  game.endFor(stopwatch),
+		Quest:       newQuestView(teamName, lang, quest),
+		CanAnswer:   !quest.blocksAnswers() && !quest.needsCheckIn(),
+		OtherQuests: questChoices(open, quest, lang),
+	}
+	db.Model(&Quest{}).Where("team_name = ?", teamName).Count(&state.TotalQuests)
+	if state.OtherQuests == nil {
+		state.OtherQuests = []questChoice{}
+	}
+
+	if quest.Definition.QuestTimerRequired {
+		state.QuestTimerEndsAt = &quest.QuestTimerEndTime
+	}
+	if quest.Definition.HintTimerRequired {
+		state.HintAvailableAt = &quest.HintTimerEndTime
+	}
+
+	state.Skip.Left = game.Skips.skipsLeft(teamName)
+	state.Skip.Allowed = state.Skip.Left != 0
+	if game.Skips.UnlockAfter > 0 {
+		unlock := game.Skips.unlockTime(quest)
+		state.Skip.UnlocksAt = &unlock
+		state.Skip.Allowed = state.Skip.Allowed && !time.Now().Before(unlock)
+	}
+
+	writeJSON(w, http.StatusOK, state)
+	return nil
+}
+
+// handleAPIChoose switches the team to another of its open quests
+func handleAPIChoose(w http.ResponseWriter, r *http.Request, teamName string) error {
+	if err := stillPlaying(teamName); err != nil {
+		return err
+	}
+	params, err := apiParams(r)
+	if err != nil {
+		return err
+	}
+	quest, err := apiQuest(teamName, params)
+	if err != nil {
+		return err
+	}
+	if err := chooseQuest(r, teamName, quest); err != nil {
+		return err
+	}
+	return handleAPIQuest(w, r, teamName)
+}
+
+// handleAPISubmit checks an answer. A wrong answer is not an error: it
+// answers 200 with correct set to false. Photo quests take the photo in the
+// same multipart request or from an earlier upload.
+func handleAPISubmit(w http.ResponseWriter, r *http.Request, teamName string) error {
+	if err := stillPlaying(teamName); err != nil {
+		return err
+	}
+	params, err := apiParams(r)
+	if err != nil {
+		return err
+	}
+	quest, err := apiQuest(teamName, params)
+	if err != nil {
+		return err
+	}
+	if err := canAnswer(r, teamName, &quest); err != nil {
+		return err
+	}
+
+	if quest.Definition.FileRequired {
+		if err := apiSavePhoto(r, teamName, &quest); err != nil {
+			return err
+		}
+		if quest.Upload == "" {
+			return refuse(http.StatusBadRequest, "no_file")
+		}
+	}
+
+	correct := answerQuest(r, teamName, &quest, params.Get("answer"), params)
+	writeJSON(w, http.StatusOK, map[string]bool{
+		"correct":  correct,
+		"late":     quest.Late,
+		"finished": gameOver(teamName),
+	})
+	return nil
+}
+
+// apiSavePhoto saves the photo of a multipart request, if it has one
+func apiSavePhoto(r *http.Request, teamName string, quest *Quest) error {
+	if r.MultipartForm == nil || len(r.MultipartForm.File["photo"]) == 0 {
+		return nil
+	}
+
+	file, header, err := r.FormFile("photo")
+	if err != nil {
+		return refuse(http.StatusBadRequest, "no_file")
+	}
+	defer file.Close()
+	return saveUpload(r, teamName, quest, file, header)
+}
+
+// handleAPIUpload saves the photo of a quest before its answer is submitted
+func handleAPIUpload(w http.ResponseWriter, r *http.Request, teamName string) error {
+	if err := stillPlaying(teamName); err != nil {
+		return err
+	}
+	params, err := apiParams(r)
+	if err != nil {
+		return err
+	}
+	if r.MultipartForm == nil || len(r.MultipartForm.File["photo"]) == 0 {
+		return refuse(http.StatusBadRequest, "no_file")
+	}
+	quest, err := apiQuest(teamName, params)
+	if err != nil {
+		return err
+	}
+	if err := canAnswer(r, teamName, &quest); err != nil {
+		return err
+	}
+	if err := apiSavePhoto(r, teamName, &quest); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]bool{"uploaded": true})
+	return nil
+}
+
+// handleAPIHint reveals the hint of a quest
+func handleAPIHint(w http.ResponseWriter, r *http.Request, teamName string) error {
+	if err := stillPlaying(teamName); err != nil {
+		return err
+	}
+	params, err := apiParams(r)
+	if err != nil {
+		return err
+	}
+	quest, err := apiQuest(teamName, params)
+	if err != nil {
+		return err
+	}
+	if err := revealHint(r, teamName, &quest); err != nil {
+		return err
+	}
+
+	view := newQuestView(teamName, requestLanguage(r, teamName), quest)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"hint":     view.Hint,
+		"hintHtml": view.HintHTML,
+	})
+	return nil
+}
+
+// handleAPISkip skips a quest if the skip policy allows it
+func handleAPISkip(w http.ResponseWriter, r *http.Request, teamName string) error {
+	if err := stillPlaying(teamName); err != nil {
+		return err
+	}
+	params, err := apiParams(r)
+	if err != nil {
+		return err
+	}
+	quest, err := apiQuest(teamName, params)
+	if err != nil {
+		return err
+	}
+	if err := skipQuest(r, teamName, &quest); err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"skipped":   true,
+		"skipsLeft": skipPolicyOf(teamName).skipsLeft(teamName),
+		"finished":  gameOver(teamName),
+	})
+	return nil
+}
+
+// handleAPIFinish returns whether the team has finished and its results
+func handleAPIFinish(w http.ResponseWriter, r *http.Request, teamName string) error {
+	writeJSON(w, http.StatusOK, struct {
+		Finished bool `json:"finished"`
+		teamSummary
+	}{gameOver(teamName), summarize(teamName)})
+	return nil
+}
+
+// handleAPINotFound answers the unknown API paths
+func handleAPINotFound(w http.ResponseWriter, r *http.Request) {
+	apiError(w, r, "", refuse(http.StatusNotFound, "not_found"))
+}
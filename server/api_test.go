@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiRequest sends a JSON request to the API with a bearer token, if given
+func apiRequest(handler http.Handler, method, path, token string, body map[string]interface{}) *httptest.ResponseRecorder {
+	var r *http.Request
+	if body != nil {
+		encoded, _ := json.Marshal(body)
+		r = httptest.NewRequest(method, path, strings.NewReader(string(encoded)))
+		r.Header.Set("Content-Type", "application/json")
+	} else {
+		r = httptest.NewRequest(method, path, nil)
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// apiErrorCode returns the code of an API error response
+func apiErrorCode(w *httptest.ResponseRecorder) string {
+	var body struct {
+		Error struct{ Code string }
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return body.Error.Code
+}
+
+// apiLogin logs a test team in and returns its token
+func apiLogin(t *testing.T, handler http.Handler, teamName string) string {
+	t.Helper()
+	w := apiRequest(handler, http.MethodPost, "/api/v1/login", "", map[string]interface{}{
+		"game": defaultGameKey, "username": strings.ToLower(teamName), "password": "pass-" + teamName,
+	})
+	var body struct{ Token string }
+	if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != http.StatusOK || err != nil || body.Token == "" {
+		t.Fatalf("login: %d %s", w.Code, w.Body.String())
+	}
+	return body.Token
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	handler := newTestServer(t)
+	const teamName = "APIAUTH"
+	addTestTeam(t, teamName)
+	addTestQuest(t, teamName, 1, QuestDefinition{Key: "auth", Text: "Auth quest", CorrectAnswers: "yes"})
+
+	w := apiRequest(handler, http.MethodPost, "/api/v1/login", "", map[string]interface{}{
+		"game": defaultGameKey, "username": "apiauth", "password": "wrong",
+	})
+	if w.Code != http.StatusUnauthorized || apiErrorCode(w) != "invalid_credentials" {
+		t.Errorf("wrong password: %d %s", w.Code, w.Body.String())
+	}
+
+	token := apiLogin(t, handler, teamName)
+	if w := apiRequest(handler, http.MethodGet, "/api/v1/quest", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("quest with the token: %d %s", w.Code, w.Body.String())
+	}
+
+	// The cookie of the player pages holds just the team name
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/quest", nil)
+	r.AddCookie(&http.Cookie{Name: "logged_in_team", Value: teamName})
+	cookieOnly := httptest.NewRecorder()
+	handler.ServeHTTP(cookieOnly, r)
+
+	parts := strings.Split(token, ".")
+	for _, tc := range []struct {
+		name string
+		w    *httptest.ResponseRecorder
+	}{
+		{"no token", apiRequest(handler, http.MethodGet, "/api/v1/quest", "", nil)},
+		{"cookie only", cookieOnly},
+		{"garbage", apiRequest(handler, http.MethodGet, "/api/v1/quest", "not-a-token", nil)},
+		{"forged signature", apiRequest(handler, http.MethodGet, "/api/v1/quest", parts[0]+"."+parts[1]+".AAAA", nil)},
+		{"expired", apiRequest(handler, http.MethodGet, "/api/v1/quest", apiToken(teamName, time.Now().Add(-time.Minute)), nil)},
+		{"other team", apiRequest(handler, http.MethodGet, "/api/v1/quest", "VEVBTTE."+parts[1]+"."+parts[2], nil)},
+	} {
+		if tc.w.Code != http.StatusUnauthorized || apiErrorCode(tc.w) != "unauthorized" {
+			t.Errorf("%s: %d %s, want 401 unauthorized", tc.name, tc.w.Code, tc.w.Body.String())
+		}
+	}
+
+	// A new password ends the tokens given for the old one
+	mu.Lock()
+	teams[teamName].Password = "changed"
+	mu.Unlock()
+	if w := apiRequest(handler, http.MethodGet, "/api/v1/quest", token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("token after a password change: %d, want 401", w.Code)
+	}
+}
+
+func TestAPIAnswers(t *testing.T) {
+	handler := newTestServer(t)
+	const teamName = "APIANSWER"
+	addTestTeam(t, teamName)
+	quest := addTestQuest(t, teamName, 1, QuestDefinition{Key: "answer", Text: "Answer quest", CorrectAnswers: "bell", Hint: "Listen"})
+	token := apiLogin(t, handler, teamName)
+
+	submit := func(answer string) (*httptest.ResponseRecorder, map[string]bool) {
+		w := apiRequest(handler, http.MethodPost, "/api/v1/submit", token, map[string]interface{}{"questId": quest.ID, "answer": answer})
+		result := map[string]bool{}
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w, result
+	}
+
+	if w := apiRequest(handler, http.MethodPost, "/api/v1/submit", token, map[string]interface{}{"questId": 999999, "answer": "bell"}); w.Code != http.StatusNotFound {
+		t.Errorf("unknown quest: %d, want 404", w.Code)
+	}
+
+	w, result := submit("clock")
+	if w.Code != http.StatusOK || result["correct"] || result["finished"] {
+		t.Errorf("wrong answer: %d %s", w.Code, w.Body.String())
+	}
+
+	w, result = submit("bell")
+	if w.Code != http.StatusOK || !result["correct"] || !result["finished"] {
+		t.Errorf("right answer: %d %s", w.Code, w.Body.String())
+	}
+
+	// The only quest is solved, so the game of the team is over
+	if w := apiRequest(handler, http.MethodGet, "/api/v1/quest", token, nil); w.Code != http.StatusConflict || apiErrorCode(w) != "game_finished" {
+		t.Errorf("quest after the last answer: %d %s", w.Code, w.Body.String())
+	}
+	if w := apiRequest(handler, http.MethodGet, "/api/v1/finish", token, nil); !strings.Contains(w.Body.String(), `"finished":true`) {
+		t.Errorf("finish: %s", w.Body.String())
+	}
+}
+
+func TestAPIRefusesFinishedGames(t *testing.T) {
+	handler := newTestServer(t)
+	const teamName = "APIFINISHED"
+	addTestTeam(t, teamName)
+	quest := addTestQuest(t, teamName, 1, QuestDefinition{Key: "finished", Text: "Finished quest", CorrectAnswers: "bell", Hint: "Listen"})
+	token := apiLogin(t, handler, teamName)
+
+	for _, finish := range []struct {
+		name  string
+		apply func(team *Team)
+	}{
+		{"finished", func(team *Team) { team.GameFinished = true }},
+		{"time up", func(team *Team) { team.Stopwatch = time.Now().Add(-1000 * time.Hour) }},
+	} {
+		mu.Lock()
+		finish.apply(teams[teamName])
+		mu.Unlock()
+
+		for _, path := range []string{"/api/v1/submit", "/api/v1/hint", "/api/v1/skip", "/api/v1/upload", "/api/v1/quest/choose"} {
+			w := apiRequest(handler, http.MethodPost, path, token, map[string]interface{}{"questId": quest.ID, "answer": "bell"})
+			if w.Code != http.StatusConflict || apiErrorCode(w) != "game_finished" {
+				t.Errorf("%s, %s: %d %s, want 409 game_finished", finish.name, path, w.Code, w.Body.String())
+			}
+		}
+
+		mu.Lock()
+		teams[teamName].GameFinished = false
+		teams[teamName].Stopwatch = time.Now()
+		mu.Unlock()
+	}
+
+	var stored Quest
+	db.First(&stored, quest.ID)
+	if stored.Completed || stored.Skipped || stored.HintsUsed != 0 {
+		t.Errorf("the refused actions changed the quest: %+v", stored)
+	}
+}
+
+func TestAPIMediaWithBearerToken(t *testing.T) {
+	handler := newTestServer(t)
+	const (
+		teamName = "APIMEDIA"
+		image    = "/static/img/key.png"
+	)
+	addTestTeam(t, teamName)
+	addTestQuest(t, teamName, 1, QuestDefinition{Key: "media", Text: "Media quest", CorrectAnswers: "key", ImagePath: image})
+	token := apiLogin(t, handler, teamName)
+
+	// Loading the quest starts it, which unlocks its media
+	if w := apiRequest(handler, http.MethodGet, "/api/v1/quest", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("quest: %d %s", w.Code, w.Body.String())
+	}
+
+	url := mediaURL(teamName, image)
+	if w := apiRequest(handler, http.MethodGet, url, token, nil); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("media with the token: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := apiRequest(handler, http.MethodGet, url, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("media without a token: %d, want 404", w.Code)
+	}
+	if w := apiRequest(handler, http.MethodGet, url, apiToken(teamName, time.Now().Add(-time.Minute)), nil); w.Code != http.StatusNotFound {
+		t.Errorf("media with an expired token: %d, want 404", w.Code)
+	}
+	if w := apiRequest(handler, http.MethodGet, fmt.Sprintf("/media/%s.png", mediaToken("TEAM1", image)), token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("media of another team: %d, want 404", w.Code)
+	}
+}
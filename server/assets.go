@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	_ "image/gif"
+)
+
+// Asset folders inside client/static, by kind of media
+const (
+	assetKindImage = "img"
+	assetKindAudio = "audio"
+	assetKindVideo = "video"
+	assetKindFile  = "files" // Downloads such as PDFs
+)
+
+// File extensions accepted for every kind of asset
+var assetExtensions = map[string]string{
+	".jpg":  assetKindImage,
+	".jpeg": assetKindImage,
+	".png":  assetKindImage,
+	".gif":  assetKindImage,
+	".webp": assetKindImage,
+	".mp3":  assetKindAudio,
+	".m4a":  assetKindAudio,
+	".ogg":  assetKindAudio,
+	".wav":  assetKindAudio,
+	".mp4":  assetKindVideo,
+	".webm": assetKindVideo,
+	".mov":  assetKindVideo,
+	".pdf":  assetKindFile,
+}
+
+// Uploaded photos are scaled down to fit phones
+const (
+	assetMaxImageSide = 1600
+	assetJPEGQuality  = 82
+	assetMaxUpload    = 200 << 20
+)
+
+// asset is a media file in the asset folders
+type asset struct {
+	Path       string // Path as used in the catalog, e.g. /static/img/lion.jpg
+	Kind       string
+	Size       int64
+	References []string // Keys of the quests that use the asset
+}
+
+// SizeKB returns the size of the asset in kilobytes, for the admin page
+func (a asset) SizeKB() int64 {
+	return (a.Size + 1023) / 1024
+}
+
+// assetKind returns the kind of a media file from its extension
+func assetKind(name string) (string, bool) {
+	kind, ok := assetExtensions[strings.ToLower(path.Ext(name))]
+	return kind, ok
+}
+
+// assetFile resolves an asset path to its file, accepting only files of
+// the asset folders
+func assetFile(assetPath string) (string, bool) {
+	kind, ok := assetKind(assetPath)
+	if !ok || path.Dir(path.Clean(assetPath)) != path.Join("/static", kind) {
+		return "", false
+	}
+	return mediaFile(assetPath)
+}
+
+// assetReferences maps the media paths to the keys of the quests using them
+func assetReferences() map[string][]string {
+	var definitions []QuestDefinition
+	db.Order("position").Find(&definitions)
+
+	paths := mediaPaths(definitions)
+	references := map[string][]string{}
+	for _, definition := range definitions {
+		for _, mediaPath := range paths[definition.ID] {
+			references[mediaPath] = append(references[mediaPath], definition.Key)
+		}
+	}
+	return references
+}
+
+// listAssets returns the files of the asset folders with the quests using them
+func listAssets(references map[string][]string) []asset {
+	var assets []asset
+	for _, kind := range []string{assetKindImage, assetKindAudio, assetKindVideo, assetKindFile} {
+		dir, ok := mediaFile(path.Join("/static", kind))
+		if !ok {
+			continue
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || entry.IsDir() {
+				continue
+			}
+
+			assetPath := path.Join("/static", kind, entry.Name())
+			assets = append(assets, asset{
+				Path:       assetPath,
+				Kind:       kind,
+				Size:       info.Size(),
+				References: references[assetPath],
+			})
+		}
+	}
+	return assets
+}
+
+// missingAssets returns the referenced media paths without a file
+func missingAssets(references map[string][]string) []asset {
+	var missing []asset
+	for mediaPath, keys := range references {
+		filePath, ok := mediaFile(mediaPath)
+		if ok {
+			if info, err := os.Stat(filePath); err == nil && !info.IsDir() {
+				continue
+			}
+		}
+		missing = append(missing, asset{Path: mediaPath, References: keys})
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].Path < missing[j].Path })
+	return missing
+}
+
+// saveAsset stores an uploaded media file in the folder of its kind and
+// returns its path for the catalog. Existing files are never overwritten.
+func saveAsset(file multipart.File, header *multipart.FileHeader) (string, error) {
+	name := filepath.Base(filepath.Clean("/" + header.Filename))
+	name = strings.NewReplacer(" ", "_", "/", "_", "\\", "_").Replace(name)
+
+	kind, ok := assetKind(name)
+	if !ok {
+		return "", fmt.Errorf("unsupported file type %q", header.Filename)
+	}
+
+	// Only the photos that may be optimized are read into memory, the other
+	// files are copied to the folder as they come
+	var data []byte
+	if kind == assetKindImage && optimizable(name) {
+		var err error
+		if data, err = io.ReadAll(file); err != nil {
+			return "", err
+		}
+		data = optimizeImage(name, data)
+	}
+
+	dir, ok := mediaFile(path.Join("/static", kind))
+	if !ok {
+		return "", fmt.Errorf("invalid asset folder %q", kind)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	// Add a number to the name until it is free
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; ; i++ {
+		dst, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			name = fmt.Sprintf("%s-%d%s", base, i, ext)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if data != nil {
+			_, err = dst.Write(data)
+		} else {
+			_, err = io.Copy(dst, file)
+		}
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dst.Name())
+			return "", err
+		}
+		return path.Join("/static", kind, name), nil
+	}
+}
+
+// optimizable reports whether an image is a JPEG or PNG photo, which
+// optimizeImage can scale down
+func optimizable(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".jpg" || ext == ".jpeg" || ext == ".png"
+}
+
+// optimizeImage scales large JPEG and PNG photos down for phones and turns
+// JPEG photos upright by their EXIF orientation, which is lost when they are
+// encoded again. Other images, and images that fail to decode, are kept as
+// they are.
+func optimizeImage(name string, data []byte) []byte {
+	if !optimizable(name) {
+		return data
+	}
+	ext := strings.ToLower(path.Ext(name))
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Image %s could not be decoded, stored as uploaded: %v", name, err)
+		return data
+	}
+
+	bounds := img.Bounds()
+	orientation := jpegOrientation(data)
+	if bounds.Dx() <= assetMaxImageSide && bounds.Dy() <= assetMaxImageSide && orientation <= 1 {
+		return data
+	}
+
+	resized := orientImage(img, orientation)
+	if resized.Bounds().Dx() > assetMaxImageSide || resized.Bounds().Dy() > assetMaxImageSide {
+		resized = resizeImage(resized, assetMaxImageSide)
+	}
+
+	var buf bytes.Buffer
+	if ext == ".png" {
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buf, resized)
+	} else {
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: assetJPEGQuality})
+	}
+	// A turned photo is kept even if it came out larger
+	if err != nil || (buf.Len() >= len(data) && orientation <= 1) {
+		return data
+	}
+
+	log.Printf("Image %s resized from %dx%d to %dx%d", name, bounds.Dx(), bounds.Dy(), resized.Bounds().Dx(), resized.Bounds().Dy())
+	return buf.Bytes()
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG photo, from 1 to 8,
+// or 0 if it has none. Phones store photos as the sensor saw them and set
+// the orientation to turn them upright.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0
+	}
+
+	// Walk the segments of the header up to the image data
+	for offset := 2; offset+4 <= len(data); {
+		if data[offset] != 0xFF {
+			return 0
+		}
+		marker := data[offset+1]
+		size := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if marker == 0xDA || size < 2 || offset+2+size > len(data) {
+			return 0
+		}
+		segment := data[offset+4 : offset+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		offset += 2 + size
+	}
+	return 0
+}
+
+// exifOrientation reads the orientation tag from the first IFD of the TIFF
+// data of an EXIF segment
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orientImage turns and flips an image upright by its EXIF orientation
+func orientImage(img image.Image, orientation int) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	// Orientations 5 to 8 swap the width and the height
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Flipped left to right
+				dx, dy = w-1-x, y
+			case 3: // Upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // Flipped top to bottom
+				dx, dy = x, h-1-y
+			case 5: // Flipped along the diagonal
+				dx, dy = y, x
+			case 6: // Turned a quarter to the left, so turned right
+				dx, dy = h-1-y, x
+			case 7: // Flipped along the other diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // Turned a quarter to the right, so turned left
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:][:4], src.Pix[y*src.Stride+x*4:][:4])
+		}
+	}
+	return dst
+}
+
+// resizeImage scales an image to fit a square of the given side, averaging
+// the source pixels covered by every target pixel
+func resizeImage(img image.Image, side int) *image.RGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := side, srcH*side/srcW
+	if srcH > srcW {
+		dstW, dstH = srcW*side/srcH, side
+	}
+	dstW, dstH = max(dstW, 1), max(dstH, 1)
+
+	src := image.NewRGBA(image.Rect(0, 0, srcW, srcH))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := y*srcH/dstH, max((y+1)*srcH/dstH, y*srcH/dstH+1)
+		for x := 0; x < dstW; x++ {
+			x0, x1 := x*srcW/dstW, max((x+1)*srcW/dstW, x*srcW/dstW+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+
+			count := (y1 - y0) * (x1 - x0)
+			offset := y*dst.Stride + x*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return dst
+}
+
+// handleAdminAssets lists the media assets and accepts uploads
+func handleAdminAssets(w http.ResponseWriter, r *http.Request) {
+	var message string
+
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, assetMaxUpload)
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			// http.Error(w, "The upload is too large", http.StatusBadRequest)
+			http.Error(w, "Файловете са твърде големи", http.StatusBadRequest)
+			return
+		}
+
+		var saved []string
+		for _, header := range r.MultipartForm.File["files"] {
+			file, err := header.Open()
+			if err != nil {
+				continue
+			}
+			assetPath, err := saveAsset(file, header)
+			file.Close()
+			if err != nil {
+				log.Printf("Asset upload failed: %v", err)
+				// message = "Some files could not be uploaded, see the server log."
+				message = "Някои файлове не бяха качени, вижте лога на сървъра."
+				continue
+			}
+			saved = append(saved, assetPath)
+		}
+		log.Printf("Assets uploaded: %s", strings.Join(saved, ", "))
+
+		if message == "" {
+			http.Redirect(w, r, "/admin/assets", http.StatusSeeOther)
+			return
+		}
+	}
+
+	references := assetReferences()
+	data := struct {
+		Assets  []asset
+		Missing []asset
+		Message string
+	}{
+		Assets:  listAssets(references),
+		Missing: missingAssets(references),
+		Message: message,
+	}
+
+	if err := templates.ExecuteTemplate(w, "admin_assets.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleAdminAssetFile serves an asset to the organizers
+func handleAdminAssetFile(w http.ResponseWriter, r *http.Request) {
+	filePath, ok := assetFile(r.URL.Query().Get("path"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filePath)
+}
+
+// handleAdminAssetDelete deletes an asset that no quest uses
+func handleAdminAssetDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/assets", http.StatusSeeOther)
+		return
+	}
+
+	assetPath := path.Clean("/" + r.FormValue("path"))
+	filePath, ok := assetFile(assetPath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if len(assetReferences()[assetPath]) > 0 {
+		http.Error(w, "Файлът се използва от задача", http.StatusBadRequest)
+		return
+	}
+
+	if err := os.Remove(filePath); err != nil {
+		log.Printf("Failed to delete asset %s: %v", assetPath, err)
+	} else {
+		log.Printf("Asset %s deleted", assetPath)
+	}
+	http.Redirect(w, r, "/admin/assets", http.StatusSeeOther)
+}
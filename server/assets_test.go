@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+// withOrientation inserts an EXIF segment with an orientation after the
+// start of a JPEG photo
+func withOrientation(photo []byte, order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 26)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)       // First IFD
+	order.PutUint16(tiff[8:], 1)       // One entry
+	order.PutUint16(tiff[10:], 0x0112) // Orientation
+	order.PutUint16(tiff[12:], 3)      // SHORT
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	header := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+
+	result := append([]byte{}, photo[:2]...)
+	result = append(result, header...)
+	result = append(result, segment...)
+	return append(result, photo[2:]...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	var photo bytes.Buffer
+	jpeg.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil)
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"no exif", photo.Bytes(), 0},
+		{"intel", withOrientation(photo.Bytes(), binary.LittleEndian, 6), 6},
+		{"motorola", withOrientation(photo.Bytes(), binary.BigEndian, 8), 8},
+		{"out of range", withOrientation(photo.Bytes(), binary.BigEndian, 9), 0},
+		{"not a jpeg", []byte("GIF89a"), 0},
+		{"cut short", withOrientation(photo.Bytes(), binary.BigEndian, 3)[:20], 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("jpegOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// A photo taken with the phone on its side is stored upright
+func TestOptimizeImageTurnsPhotosUpright(t *testing.T) {
+	// Red on the left, blue on the right
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	draw.Draw(img, image.Rect(0, 0, 16, 16), &image.Uniform{color.RGBA{255, 0, 0, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(16, 0, 32, 16), &image.Uniform{color.RGBA{0, 0, 255, 255}}, image.Point{}, draw.Src)
+	var photo bytes.Buffer
+	jpeg.Encode(&photo, img, &jpeg.Options{Quality: 95})
+
+	// Turned right, the left side comes on top
+	optimized, err := jpeg.Decode(bytes.NewReader(optimizeImage("photo.jpg", withOrientation(photo.Bytes(), binary.LittleEndian, 6))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := optimized.Bounds().Size(); size != (image.Point{16, 32}) {
+		t.Fatalf("size %v, want 16x32", size)
+	}
+	top, _, _, _ := optimized.At(8, 4).RGBA()
+	bottom, _, _, _ := optimized.At(8, 28).RGBA()
+	if top < 0x8000 || bottom > 0x8000 {
+		t.Errorf("red at the top %#x and at the bottom %#x, want the red side on top", top, bottom)
+	}
+}
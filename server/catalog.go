@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// QuestDefinition is the content of a quest, stored once and shared by all
+// teams. The progress of a team on it is kept in Quest.
+type QuestDefinition struct {
+	gorm.Model
+	Game           string `gorm:"unique_index:idx_game_key"`
+	Key            string `gorm:"unique_index:idx_game_key"`
+	Position       int
+	Text           string
+	CorrectAnswers string
+	Hint           string
+	AudioPath      string
+	ImagePath      string
+	FileRequired   bool
+
+	QuestTimerRequired bool
+	QuestTimerDuration time.Duration
+	QuestTimerMode     string
+
+	HintTimerRequired bool
+	HintTimerDuration time.Duration
+
+	Prerequisites string
+	Fixed         string // "first" or "last" pins the quest in generated routes
+	Location      string
+
+	QuestType string
+	Latitude  float64
+	Longitude float64
+	Radius    float64 // Radius of the geofence in meters
+
+	Options string // Items of a puzzle quest, separated by |
+}
+
+// Columns of the quest catalog, in order
+var catalogHeader = []string{
+	"Key", "Text", "CorrectAnswers", "Hint", "AudioPath", "ImagePath", "FileRequired",
+	"QuestTimerRequired", "QuestTimerDuration", "HintTimerRequired", "HintTimerDuration",
+	"QuestTimerMode", "Prerequisites", "Fixed", "Location", "QuestType", "Latitude", "Longitude", "Radius",
+	"Options",
+}
+
+// Pinned positions of a quest in generated routes
+const (
+	fixedFirst = "first"
+	fixedLast  = "last"
+)
+
+// readCSV reads all records of a CSV file, skipping the header row
+func readCSV(filePath string) ([][]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // Columns added later are optional
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) > 0 {
+		records = records[1:]
+	}
+	return records, nil
+}
+
+// loadCatalog reads the quest definitions from a CSV file
+func loadCatalog(filePath string) ([]QuestDefinition, error) {
+	records, err := readCSV(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var definitions []QuestDefinition
+	for i, record := range records {
+		// Replace literal \n with actual newlines in text fields
+		for j, field := range record {
+			record[j] = strings.ReplaceAll(field, `\n`, "\n")
+		}
+
+		// Quests without a key are keyed by their position in the catalog
+		key := strings.TrimSpace(record[0])
+		if key == "" {
+			key = strconv.Itoa(i + 1)
+		}
+
+		definitions = append(definitions, QuestDefinition{
+			Key:                key,
+			Position:           i + 1,
+			Text:               optionalField(record, 1),
+			CorrectAnswers:     optionalField(record, 2),
+			Hint:               optionalField(record, 3),
+			AudioPath:          optionalField(record, 4),
+			ImagePath:          optionalField(record, 5),
+			FileRequired:       parseBool(optionalField(record, 6)),
+			QuestTimerRequired: parseBool(optionalField(record, 7)),
+			QuestTimerDuration: parseDuration(optionalField(record, 8)),
+			HintTimerRequired:  parseBool(optionalField(record, 9)),
+			HintTimerDuration:  parseDuration(optionalField(record, 10)),
+			QuestTimerMode:     parseTimerMode(optionalField(record, 11)),
+			Prerequisites:      optionalField(record, 12),
+			Fixed:              strings.ToLower(strings.TrimSpace(optionalField(record, 13))),
+			Location:           strings.TrimSpace(optionalField(record, 14)),
+			QuestType:          strings.ToLower(strings.TrimSpace(optionalField(record, 15))),
+			Latitude:           parseFloat(optionalField(record, 16)),
+			Longitude:          parseFloat(optionalField(record, 17)),
+			Radius:             parseFloat(optionalField(record, 18)),
+			Options:            optionalField(record, 19),
+		})
+	}
+	return definitions, nil
+}
+
+// writeCatalog writes the quest definitions back to a CSV file, escaping
+// newlines the way loadCatalog expects them
+func writeCatalog(filePath string, definitions []QuestDefinition) error {
+	formatBool := func(value bool) string {
+		if value {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	formatDuration := func(value time.Duration) string {
+		if value == 0 {
+			return ""
+		}
+		return value.String()
+	}
+	formatFloat := func(value float64) string {
+		if value == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	escape := strings.NewReplacer("\r\n", `\n`, "\n", `\n`, "\r", "")
+
+	// Write to a temporary file first so a failed write keeps the old catalog
+	temp := filePath + ".tmp"
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	writer.UseCRLF = true
+	writer.Write(catalogHeader)
+	for _, definition := range definitions {
+		record := []string{
+			definition.Key,
+			definition.Text,
+			definition.CorrectAnswers,
+			definition.Hint,
+			definition.AudioPath,
+			definition.ImagePath,
+			formatBool(definition.FileRequired),
+			formatBool(definition.QuestTimerRequired),
+			formatDuration(definition.QuestTimerDuration),
+			formatBool(definition.HintTimerRequired),
+			formatDuration(definition.HintTimerDuration),
+			definition.QuestTimerMode,
+			definition.Prerequisites,
+			definition.Fixed,
+			definition.Location,
+			definition.QuestType,
+			formatFloat(definition.Latitude),
+			formatFloat(definition.Longitude),
+			formatFloat(definition.Radius),
+			definition.Options,
+		}
+		for i, field := range record {
+			record[i] = escape.Replace(field)
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		file.Close()
+		os.Remove(temp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, filePath)
+}
+
+// seedGames clears the quests of the database and seeds the catalog and the
+// routes of every game
+func seedGames(db *gorm.DB) {
+	db.Exec("DELETE FROM quests")
+	db.Exec("DELETE FROM quest_definitions")
+	db.Unscoped().Delete(&QuestMedia{})
+	db.Unscoped().Delete(&QuestTranslation{})
+
+	// Checkpoint codes made before there were several games belong to the first
+	db.Model(&Checkpoint{}).Where("game = ?", "").Update("game", games[0].Key)
+
+	for _, game := range games {
+		seedQuestCatalog(db, game, gameTeamNames(game.Key))
+	}
+}
+
+// seedQuestCatalog stores the quest catalog of a game and gives every team
+// of the game its route
+func seedQuestCatalog(db *gorm.DB, game *Game, teamNames []string) {
+	definitions, err := loadCatalog(game.catalogPath())
+	if err != nil {
+		fmt.Printf("Error reading quest catalog of game %s: %v\n", game.Key, err)
+		return
+	}
+
+	media, err := loadQuestMedia(game.mediaPath())
+	if err != nil {
+		fmt.Printf("Error reading quest media of game %s: %v\n", game.Key, err)
+		return
+	}
+
+	translations, err := loadQuestTranslations(game.translationsPath())
+	if err != nil {
+		fmt.Printf("Error reading quest translations of game %s: %v\n", game.Key, err)
+		return
+	}
+
+	routes, err := assignRoutes(game.RouteMode, definitions, teamNames, game.routesPath())
+	if err != nil {
+		fmt.Printf("Error assigning routes of game %s: %v\n", game.Key, err)
+		return
+	}
+
+	byKey := make(map[string]uint, len(definitions))
+	for i := range definitions {
+		definitions[i].Game = game.Key
+		db.Create(&definitions[i])
+		byKey[definitions[i].Key] = definitions[i].ID
+
+		for _, item := range media[definitions[i].Key] {
+			item.DefinitionID = definitions[i].ID
+			db.Create(&item)
+		}
+		for _, translation := range translations[definitions[i].Key] {
+			translation.DefinitionID = definitions[i].ID
+			db.Create(&translation)
+		}
+	}
+	ensureCheckpoints(db, definitions, teamNames)
+
+	for _, teamName := range teamNames {
+		for number, key := range routes[teamName] {
+			quest := Quest{
+				TeamName:     teamName,
+				QuestNumber:  number + 1,
+				DefinitionID: byKey[key],
+			}
+			db.Create(&quest)
+		}
+	}
+
+	fmt.Printf("Game %s seeded with %d quests and %s routes for %d teams.\n", game.Key, len(definitions), game.RouteMode, len(teamNames))
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Checkpoint is a code printed at a location of checkpoint quests. The
+// checkpoint quests with the same Location share the code; a quest without a
+// location has a code of its own. A code without a team name is valid for
+// every team.
+type Checkpoint struct {
+	gorm.Model
+	Game     string
+	Location string // See checkpointPlace
+	QuestKey string // First quest at the location
+	TeamName string
+	Code     string `gorm:"unique_index"`
+}
+
+// checkpointPlace returns the location a checkpoint quest shares its code
+// with, the key of the quest when it has no location
+func checkpointPlace(definition QuestDefinition) string {
+	if location := strings.TrimSpace(definition.Location); location != "" {
+		return location
+	}
+	return definition.Key
+}
+
+// covers reports whether a code validates a checkpoint quest.
+// Codes made before they were shared by location name just their quest.
+func (checkpoint Checkpoint) covers(definition QuestDefinition) bool {
+	if checkpoint.Location == "" {
+		return definition.Key == checkpoint.QuestKey
+	}
+	return checkpointPlace(definition) == checkpoint.Location
+}
+
+// Letters and digits that can't be confused when a code is typed by hand
+const checkpointAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const checkpointCodeLength = 8
+
+var (
+	// checkpointPerTeam gives every team its own code at each checkpoint
+	checkpointPerTeam bool
+
+	// publicURL is the address of the site printed in the QR codes
+	publicURL string
+)
+
+// loadCheckpointSettings reads the CHECKPOINT_PER_TEAM and PUBLIC_URL
+// environment variables
+func loadCheckpointSettings() (bool, string) {
+	return parseBool(os.Getenv("CHECKPOINT_PER_TEAM")), strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
+}
+
+// newCheckpointCode returns a random checkpoint code
+func newCheckpointCode() (string, error) {
+	random := make([]byte, checkpointCodeLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	code := make([]byte, checkpointCodeLength)
+	for i, b := range random {
+		code[i] = checkpointAlphabet[int(b)%len(checkpointAlphabet)]
+	}
+	return string(code), nil
+}
+
+// normalizeCheckpointCode makes typed codes match the printed ones
+func normalizeCheckpointCode(code string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.ToUpper(code))
+}
+
+// ensureCheckpoints generates the missing codes of the checkpoint locations.
+// Codes are kept between restarts, so printed sheets stay valid. teamNames
+// are the teams of the game of the quests.
+func ensureCheckpoints(db *gorm.DB, definitions []QuestDefinition, teamNames []string) {
+	scopes := []string{""}
+	if checkpointPerTeam {
+		scopes = teamNames
+	}
+
+	for _, definition := range definitions {
+		if definition.QuestType != questTypeCheckpoint {
+			continue
+		}
+		place := checkpointPlace(definition)
+
+		for _, teamName := range scopes {
+			var count int
+			db.Model(&Checkpoint{}).Where("game = ? AND location = ? AND team_name = ?", definition.Game, place, teamName).Count(&count)
+			if count > 0 {
+				continue
+			}
+
+			// A code of the quest made before codes were shared by location
+			// becomes the code of the location, so its sheet stays valid
+			adopted := db.Model(&Checkpoint{}).Where("game = ? AND location = ? AND quest_key = ? AND team_name = ?", definition.Game, "", definition.Key, teamName).
+				Update("location", place)
+			if adopted.RowsAffected > 0 {
+				continue
+			}
+
+			// Retry in the unlikely case of a duplicate code
+			for attempt := 0; attempt < 5; attempt++ {
+				code, err := newCheckpointCode()
+				if err != nil {
+					log.Fatalf("Failed to generate checkpoint code: %v", err)
+				}
+				if db.Create(&Checkpoint{Game: definition.Game, Location: place, QuestKey: definition.Key, TeamName: teamName, Code: code}).Error == nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+// checkpointURL returns the address encoded in the QR code of a checkpoint
+func checkpointURL(r *http.Request, code string) string {
+	base := publicURL
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base + "/checkpoint/" + code
+}
+
+// handleCheckpoint checks a team in with a checkpoint code, either scanned
+// as /checkpoint/{code} or typed into the quest page
+func handleCheckpoint(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("logged_in_team")
+	if err != nil {
+		// A phone camera opens the link in the browser where the team is logged in
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	teamName := cookie.Value
+
+	code := strings.TrimPrefix(r.URL.Path, "/checkpoint")
+	code = strings.TrimPrefix(code, "/")
+	if r.Method == http.MethodPost {
+		code = r.FormValue("code")
+	}
+	code = normalizeCheckpointCode(code)
+
+	redirect := func(result string) {
+		http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s&checkpoint=%s", teamName, result), http.StatusSeeOther)
+	}
+
+	var checkpoint Checkpoint
+	if code == "" || db.Where("code = ?", code).First(&checkpoint).Error != nil ||
+		checkpoint.Game != teamGame(teamName).Key ||
+		(checkpoint.TeamName != "" && checkpoint.TeamName != teamName) {
+		logEvent(event{Type: eventBadCheckpoint, Team: teamName, RequestID: requestID(r), Details: map[string]string{"code": code}})
+		redirect("invalid")
+		return
+	}
+
+	// The quests of the team at the location, the open ones are checked in
+	var definitions []QuestDefinition
+	db.Where("game = ? AND quest_type = ?", checkpoint.Game, questTypeCheckpoint).Find(&definitions)
+	var definitionIDs []uint
+	for _, definition := range definitions {
+		if checkpoint.covers(definition) {
+			definitionIDs = append(definitionIDs, definition.ID)
+		}
+	}
+
+	var quests []Quest
+	db.Preload("Definition").Where("team_name = ? AND definition_id IN (?)", teamName, definitionIDs).Order("quest_number").Find(&quests)
+	if len(quests) == 0 {
+		// The checkpoint isn't on the team's route
+		redirect("invalid")
+		return
+	}
+
+	result := "done"
+	for i := range quests {
+		quest := &quests[i]
+		if quest.Completed || quest.CheckedIn {
+			continue
+		}
+		if !isQuestOpen(teamName, *quest) {
+			if result == "done" {
+				result = "locked"
+			}
+			continue
+		}
+		checkIn(r, quest, "checkpoint code")
+		result = "ok"
+	}
+	redirect(result)
+}
+
+// checkpointSheet is a printable checkpoint code
+type checkpointSheet struct {
+	Checkpoint
+	Quests []string // Keys of the quests at the location
+	URL    string
+}
+
+// checkpointSheets lists the checkpoint codes of the organizer's game by
+// location, in catalog order
+func checkpointSheets(r *http.Request) []checkpointSheet {
+	game := adminGame(r)
+
+	var definitions []QuestDefinition
+	db.Where("game = ? AND quest_type = ?", game.Key, questTypeCheckpoint).Order("position").Find(&definitions)
+
+	var places []string
+	quests := map[string][]string{}
+	for _, definition := range definitions {
+		place := checkpointPlace(definition)
+		if quests[place] == nil {
+			places = append(places, place)
+		}
+		quests[place] = append(quests[place], definition.Key)
+	}
+
+	var sheets []checkpointSheet
+	for _, place := range places {
+		var checkpoints []Checkpoint
+		db.Where("game = ? AND location = ?", game.Key, place).Order("team_name").Find(&checkpoints)
+		for _, checkpoint := range checkpoints {
+			sheets = append(sheets, checkpointSheet{
+				Checkpoint: checkpoint,
+				Quests:     quests[place],
+				URL:        checkpointURL(r, checkpoint.Code),
+			})
+		}
+	}
+	return sheets
+}
+
+// handleAdminCheckpoints shows the checkpoint codes with their QR codes
+func handleAdminCheckpoints(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		GameSwitcher gameSwitcher
+		Sheets       []checkpointSheet
+	}{
+		GameSwitcher: newGameSwitcher(r),
+		Sheets:       checkpointSheets(r),
+	}
+
+	if err := templates.ExecuteTemplate(w, "admin_checkpoints.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleAdminCheckpointQR renders the QR code of a checkpoint as PNG
+func handleAdminCheckpointQR(w http.ResponseWriter, r *http.Request) {
+	var checkpoint Checkpoint
+	if err := db.Where("code = ?", r.URL.Query().Get("code")).First(&checkpoint).Error; err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	qr, err := encodeQR(checkpointURL(r, checkpoint.Code))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	image, err := qr.png(8)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(image)
+}
+
+// handleAdminCheckpointsPDF renders one printable page per checkpoint code
+func handleAdminCheckpointsPDF(w http.ResponseWriter, r *http.Request) {
+	var document pdfDocument
+	for _, sheet := range checkpointSheets(r) {
+		qr, err := encodeQR(sheet.URL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		page := &pdfPage{}
+		// The location is often in Cyrillic, which Helvetica can't show
+		page.text(72, 760, 28, "Checkpoint "+strings.Join(sheet.Quests, ", "))
+		if sheet.TeamName != "" {
+			page.text(72, 728, 18, sheet.TeamName)
+		}
+		page.qr(qr, 97.5, 280, 400)
+		page.text(72, 220, 40, sheet.Code)
+		page.text(72, 190, 12, sheet.URL)
+		document.addPage(page)
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="checkpoints-%s.pdf"`, adminGame(r).Key))
+	w.Write(document.bytes())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The checkpoint quests at one location share a code, which checks the team
+// in on every open one of them
+func TestCheckpointCodesPerLocation(t *testing.T) {
+	handler := newTestServer(t)
+	const teamName = "CHECKPOINTS"
+	addTestTeam(t, teamName)
+	oldPerTeam := checkpointPerTeam
+	checkpointPerTeam = false
+	t.Cleanup(func() { checkpointPerTeam = oldPerTeam })
+
+	const place = "Старата камбана"
+	first := addTestQuest(t, teamName, 1, QuestDefinition{Key: "bell-arrive", QuestType: questTypeCheckpoint, Location: place})
+	second := addTestQuest(t, teamName, 2, QuestDefinition{Key: "bell-count", QuestType: questTypeCheckpoint, Location: " " + place + " ", CorrectAnswers: "3"})
+	alone := addTestQuest(t, teamName, 3, QuestDefinition{Key: "alone", QuestType: questTypeCheckpoint, CorrectAnswers: "x"})
+
+	// A code made for a single quest before codes were shared by location
+	legacyQuest := addTestQuest(t, teamName, 4, QuestDefinition{Key: "legacy", QuestType: questTypeCheckpoint, CorrectAnswers: "x"})
+	db.Create(&Checkpoint{Game: defaultGameKey, QuestKey: legacyQuest.Definition.Key, Code: "LEGACY23"})
+
+	definitions := []QuestDefinition{first.Definition, second.Definition, alone.Definition, legacyQuest.Definition}
+	ensureCheckpoints(db, definitions, nil)
+	ensureCheckpoints(db, definitions, nil) // Codes are kept on restarts
+
+	codes := map[string][]string{}
+	for _, location := range []string{place, alone.Definition.Key, legacyQuest.Definition.Key} {
+		var found []string
+		db.Model(&Checkpoint{}).Where("game = ? AND location = ?", defaultGameKey, location).Pluck("code", &found)
+		codes[location] = found
+		if len(found) != 1 {
+			t.Fatalf("%s has %d codes, want 1", location, len(found))
+		}
+	}
+	if codes[legacyQuest.Definition.Key][0] != "LEGACY23" {
+		t.Errorf("the old code of the quest was replaced by %s", codes[legacyQuest.Definition.Key][0])
+	}
+
+	scan := func(code string) string {
+		w := teamGet(handler, teamName, "/checkpoint/"+code)
+		_, result, _ := strings.Cut(w.Header().Get("Location"), "checkpoint=")
+		return result
+	}
+
+	// The first quest needs nothing but the code, so the second one opens and
+	// is checked in by the same scan
+	if result := scan(codes[place][0]); result != "ok" {
+		t.Fatalf("scan: %s, want ok", result)
+	}
+	var stored [3]Quest
+	for i, quest := range []Quest{first, second, alone} {
+		db.First(&stored[i], quest.ID)
+	}
+	if !stored[0].Completed {
+		t.Error("the first quest at the location wasn't completed")
+	}
+	if !stored[1].CheckedIn || stored[1].Completed {
+		t.Errorf("the second quest at the location: %+v, want checked in and open", stored[1])
+	}
+	if stored[2].CheckedIn {
+		t.Error("the code of the location checked in a quest elsewhere")
+	}
+
+	if result := scan(codes[place][0]); result != "done" {
+		t.Errorf("second scan: %s, want done", result)
+	}
+	if result := scan(codes[alone.Definition.Key][0]); result != "locked" {
+		t.Errorf("scan of a later quest: %s, want locked", result)
+	}
+	if result := scan("NOTACODE"); result != "invalid" {
+		t.Errorf("unknown code: %s, want invalid", result)
+	}
+}
@@ -0,0 +1,544 @@
+// Command routegen builds balanced per-team routes from the quest catalog.
+//
+// Quests are grouped by their Location column and every team visits the
+// locations in its own order, keeping the quests of a location in catalog
+// order. The routes are searched so that teams rarely stand at the same
+// location at the same time and every team walks about the same distance.
+// Quests with "first" or "last" in the Fixed column keep their place, and
+// every location comes after the locations of the quests its quests need in
+// the Prerequisites column.
+//
+// Usage:
+//
+//	go run ./cmd/routegen -teams TEAM1,TEAM2,TEAM3,TEAM4
+//	go run ./cmd/routegen -travel data/travel.csv -teams TEAM1,TEAM2,TEAM3,TEAM4
+//
+// The optional travel CSV has the columns From,To,Minutes. Missing reverse
+// directions use the same time, other missing pairs use -default-travel. The result is
+// written in the format of data/routes.csv, for ROUTE_MODE="explicit". An
+// existing file is overwritten only with -force.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// quest is a catalog row as far as routes are concerned
+type quest struct {
+	Key           string
+	Location      string
+	Fixed         string
+	Prerequisites string
+}
+
+// block is a location with the quests solved there, in catalog order
+type block struct {
+	Location string
+	Keys     []string
+}
+
+// planner scores candidate routes
+type planner struct {
+	travel        map[[2]string]float64
+	defaultTravel float64
+	questMinutes  float64
+	first, last   []block
+	blocks        []block
+
+	// For every block, groups of blocks of which one must come before it
+	requires [][][]int
+}
+
+func main() {
+	catalogPath := flag.String("catalog", "data/catalog.csv", "quest catalog with Key, Location, Fixed and Prerequisites columns")
+	travelPath := flag.String("travel", "", "travel times with From, To and Minutes columns, -default-travel for all locations if empty")
+	outPath := flag.String("out", "data/routes.csv", "where to write the routes")
+	teamList := flag.String("teams", "TEAM1,TEAM2,TEAM3,TEAM4", "comma separated team names")
+	questMinutes := flag.Float64("quest-minutes", 5, "estimated minutes spent on a quest")
+	defaultTravel := flag.Float64("default-travel", 10, "minutes between locations missing from the travel CSV")
+	iterations := flag.Int("iterations", 50000, "search iterations")
+	seed := flag.Int64("seed", 1, "random seed, for reproducible routes")
+	force := flag.Bool("force", false, "overwrite the -out file if it exists")
+	flag.Parse()
+
+	if _, err := os.Stat(*outPath); err == nil && !*force {
+		log.Fatalf("%s already exists, add -force to overwrite it", *outPath)
+	}
+
+	teamNames := strings.Split(*teamList, ",")
+	for i := range teamNames {
+		teamNames[i] = strings.TrimSpace(teamNames[i])
+	}
+
+	quests, err := readQuests(*catalogPath)
+	if err != nil {
+		log.Fatalf("Error reading quest catalog: %v", err)
+	}
+
+	travel, err := readTravel(*travelPath)
+	if err != nil {
+		log.Fatalf("Error reading travel times: %v", err)
+	}
+
+	p := &planner{
+		travel:        travel,
+		defaultTravel: *defaultTravel,
+		questMinutes:  *questMinutes,
+	}
+	p.first, p.blocks, p.last = groupBlocks(quests)
+	if p.requires, err = blockRequirements(quests, p.blocks); err != nil {
+		log.Fatalf("Error ordering the prerequisites: %v", err)
+	}
+
+	routes, err := p.search(len(teamNames), *iterations, rand.New(rand.NewSource(*seed)))
+	if err != nil {
+		log.Fatalf("Error ordering the prerequisites: %v", err)
+	}
+
+	if err := writeRoutes(*outPath, teamNames, routes); err != nil {
+		log.Fatalf("Error writing routes: %v", err)
+	}
+
+	collisions := p.collisionMinutes(routes)
+	for i, teamName := range teamNames {
+		fmt.Printf("%s: %d locations, %.0f minutes of travel\n", teamName, len(routes[i]), p.travelMinutes(routes[i]))
+	}
+	fmt.Printf("Overlapping minutes at the same location: %.0f\n", collisions)
+	fmt.Printf("Routes written to %s\n", *outPath)
+}
+
+// readCSV reads a CSV file and indexes its columns by the header names
+func readCSV(filePath string) ([][]string, map[string]int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("%s is empty", filePath)
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	return records[1:], columns, nil
+}
+
+// field returns a named column of a record, or "" if it's missing
+func field(record []string, columns map[string]int, name string) string {
+	i, ok := columns[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// readQuests reads the quests of the catalog
+func readQuests(filePath string) ([]quest, error) {
+	records, columns, err := readCSV(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var quests []quest
+	for i, record := range records {
+		key := field(record, columns, "Key")
+		if key == "" {
+			key = strconv.Itoa(i + 1)
+		}
+
+		// A quest without a location is a location of its own
+		location := field(record, columns, "Location")
+		if location == "" {
+			location = "quest " + key
+		}
+
+		quests = append(quests, quest{
+			Key:           key,
+			Location:      location,
+			Fixed:         strings.ToLower(field(record, columns, "Fixed")),
+			Prerequisites: field(record, columns, "Prerequisites"),
+		})
+	}
+	return quests, nil
+}
+
+// readTravel reads the travel minutes between locations, none without a file
+func readTravel(filePath string) (map[[2]string]float64, error) {
+	if filePath == "" {
+		return map[[2]string]float64{}, nil
+	}
+	records, columns, err := readCSV(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	travel := map[[2]string]float64{}
+	for _, record := range records {
+		from := field(record, columns, "From")
+		to := field(record, columns, "To")
+		minutes, err := strconv.ParseFloat(field(record, columns, "Minutes"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minutes from %s to %s: %v", from, to, err)
+		}
+
+		travel[[2]string{from, to}] = minutes
+		if _, ok := travel[[2]string{to, from}]; !ok {
+			travel[[2]string{to, from}] = minutes
+		}
+	}
+	return travel, nil
+}
+
+// groupBlocks groups the quests into locations, keeping the fixed ones apart
+func groupBlocks(quests []quest) (first, middle, last []block) {
+	add := func(blocks []block, q quest) []block {
+		for i := range blocks {
+			if blocks[i].Location == q.Location {
+				blocks[i].Keys = append(blocks[i].Keys, q.Key)
+				return blocks
+			}
+		}
+		return append(blocks, block{Location: q.Location, Keys: []string{q.Key}})
+	}
+
+	for _, q := range quests {
+		switch q.Fixed {
+		case "first":
+			first = append(first, block{Location: q.Location, Keys: []string{q.Key}})
+		case "last":
+			last = append(last, block{Location: q.Location, Keys: []string{q.Key}})
+		default:
+			middle = add(middle, q)
+		}
+	}
+	return first, middle, last
+}
+
+// blockRequirements turns the prerequisites of the quests into the blocks
+// that must come before each block. A group such as "2|3" needs one of its
+// quests; negated conditions like "!3" and answers don't change the order.
+// Quests fixed first, earlier quests of the same location and unknown keys
+// are always before.
+func blockRequirements(quests []quest, blocks []block) ([][][]int, error) {
+	blockOf := map[string]int{}
+	for i, b := range blocks {
+		for _, key := range b.Keys {
+			blockOf[key] = i
+		}
+	}
+	fixed := map[string]string{}
+	for _, q := range quests {
+		fixed[q.Key] = q.Fixed
+	}
+
+	requires := make([][][]int, len(blocks))
+	for i, b := range blocks {
+		for position, key := range b.Keys {
+			var prerequisites string
+			for _, q := range quests {
+				if q.Key == key {
+					prerequisites = q.Prerequisites
+				}
+			}
+			if prerequisites == "" || prerequisites == "-" {
+				continue
+			}
+
+			for _, group := range strings.Split(prerequisites, ";") {
+				var candidates []int
+				satisfied := false
+				for _, condition := range strings.Split(group, "|") {
+					condition = strings.TrimSpace(condition)
+					if strings.HasPrefix(condition, "!") {
+						satisfied = true
+						continue
+					}
+					needed, _, _ := strings.Cut(condition, "=")
+					needed = strings.TrimSpace(needed)
+
+					j, ok := blockOf[needed]
+					switch {
+					case fixed[needed] == "last":
+						// Never before a movable location
+					case !ok:
+						satisfied = true
+					case j == i:
+						if indexOf(b.Keys, needed) < position {
+							satisfied = true
+						}
+					default:
+						candidates = append(candidates, j)
+					}
+				}
+				if satisfied {
+					continue
+				}
+				if len(candidates) == 0 {
+					return nil, fmt.Errorf("quest %s needs %q, which can't come before it", key, group)
+				}
+				requires[i] = append(requires[i], candidates)
+			}
+		}
+	}
+	return requires, nil
+}
+
+// indexOf returns the position of a key, or -1
+func indexOf(keys []string, key string) int {
+	for i, k := range keys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// ordered reports whether an order of the blocks meets their prerequisites
+func (p *planner) ordered(order []int) bool {
+	placed := make([]bool, len(p.blocks))
+	for _, i := range order {
+		if !p.ready(i, placed) {
+			return false
+		}
+		placed[i] = true
+	}
+	return true
+}
+
+// ready reports whether the prerequisites of a block are among the placed
+// blocks
+func (p *planner) ready(i int, placed []bool) bool {
+	for _, group := range p.requires[i] {
+		met := false
+		for _, j := range group {
+			met = met || placed[j]
+		}
+		if !met {
+			return false
+		}
+	}
+	return true
+}
+
+// topological reorders blocks to meet their prerequisites, keeping the
+// given order where it can
+func (p *planner) topological(preferred []int) ([]int, error) {
+	placed := make([]bool, len(p.blocks))
+	var order []int
+	for len(order) < len(preferred) {
+		next := -1
+		for _, i := range preferred {
+			if !placed[i] && p.ready(i, placed) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var stuck []string
+			for _, i := range preferred {
+				if !placed[i] {
+					stuck = append(stuck, p.blocks[i].Location)
+				}
+			}
+			return nil, fmt.Errorf("the prerequisites of %s form a cycle", strings.Join(stuck, ", "))
+		}
+		order = append(order, next)
+		placed[next] = true
+	}
+	return order, nil
+}
+
+// minutesBetween returns the travel time between two locations
+func (p *planner) minutesBetween(from, to string) float64 {
+	if from == to {
+		return 0
+	}
+	if minutes, ok := p.travel[[2]string{from, to}]; ok {
+		return minutes
+	}
+	return p.defaultTravel
+}
+
+// fullRoute adds the fixed quests around the order of the movable locations
+func (p *planner) fullRoute(order []int) []block {
+	route := append([]block{}, p.first...)
+	for _, i := range order {
+		route = append(route, p.blocks[i])
+	}
+	return append(route, p.last...)
+}
+
+// travelMinutes returns the total travel time of a route
+func (p *planner) travelMinutes(route []block) float64 {
+	total := 0.0
+	for i := 1; i < len(route); i++ {
+		total += p.minutesBetween(route[i-1].Location, route[i].Location)
+	}
+	return total
+}
+
+// visit is the time a team spends at a location
+type visit struct {
+	Location   string
+	Start, End float64
+}
+
+// schedule estimates when a team is at each location of its route
+func (p *planner) schedule(route []block) []visit {
+	var visits []visit
+	now := 0.0
+	for i, b := range route {
+		if i > 0 {
+			now += p.minutesBetween(route[i-1].Location, b.Location)
+		}
+		end := now + float64(len(b.Keys))*p.questMinutes
+		visits = append(visits, visit{Location: b.Location, Start: now, End: end})
+		now = end
+	}
+	return visits
+}
+
+// collisionMinutes returns how long teams overlap at the same location
+func (p *planner) collisionMinutes(routes [][]block) float64 {
+	schedules := make([][]visit, len(routes))
+	for i, route := range routes {
+		schedules[i] = p.schedule(route)
+	}
+
+	total := 0.0
+	for a := 0; a < len(schedules); a++ {
+		for b := a + 1; b < len(schedules); b++ {
+			for _, va := range schedules[a] {
+				for _, vb := range schedules[b] {
+					if va.Location != vb.Location {
+						continue
+					}
+					if overlap := math.Min(va.End, vb.End) - math.Max(va.Start, vb.Start); overlap > 0 {
+						total += overlap
+					}
+				}
+			}
+		}
+	}
+	return total
+}
+
+// cost combines the collisions with the spread of the travel times
+func (p *planner) cost(orders [][]int) float64 {
+	routes := make([][]block, len(orders))
+	for i, order := range orders {
+		routes[i] = p.fullRoute(order)
+	}
+
+	minTravel, maxTravel := math.Inf(1), math.Inf(-1)
+	for _, route := range routes {
+		minutes := p.travelMinutes(route)
+		minTravel = math.Min(minTravel, minutes)
+		maxTravel = math.Max(maxTravel, minutes)
+	}
+
+	// Collisions matter most, then fair and short walks
+	return p.collisionMinutes(routes)*10 + (maxTravel-minTravel)*5 + maxTravel
+}
+
+// search starts from rotated orders and improves them with random swaps,
+// accepting worse orders with a falling probability to escape local minima.
+// Orders that break the prerequisites are never taken.
+func (p *planner) search(teamCount, iterations int, rnd *rand.Rand) ([][]block, error) {
+	n := len(p.blocks)
+	orders := make([][]int, teamCount)
+	for team := range orders {
+		offset := 0
+		if n > 0 {
+			offset = team * n / teamCount
+		}
+		var rotated []int
+		for i := 0; i < n; i++ {
+			rotated = append(rotated, (offset+i)%n)
+		}
+		order, err := p.topological(rotated)
+		if err != nil {
+			return nil, err
+		}
+		orders[team] = order
+	}
+
+	current := p.cost(orders)
+	best, bestOrders := current, cloneOrders(orders)
+
+	for i := 0; i < iterations && n > 1; i++ {
+		team := rnd.Intn(teamCount)
+		a, b := rnd.Intn(n), rnd.Intn(n)
+		orders[team][a], orders[team][b] = orders[team][b], orders[team][a]
+		if !p.ordered(orders[team]) {
+			orders[team][a], orders[team][b] = orders[team][b], orders[team][a]
+			continue
+		}
+
+		candidate := p.cost(orders)
+		temperature := 1 - float64(i)/float64(iterations)
+		if candidate <= current || rnd.Float64() < math.Exp((current-candidate)/(temperature*10+1e-9)) {
+			current = candidate
+			if current < best {
+				best, bestOrders = current, cloneOrders(orders)
+			}
+		} else {
+			orders[team][a], orders[team][b] = orders[team][b], orders[team][a]
+		}
+	}
+
+	routes := make([][]block, teamCount)
+	for i, order := range bestOrders {
+		routes[i] = p.fullRoute(order)
+	}
+	return routes, nil
+}
+
+// cloneOrders copies the location orders of all teams
+func cloneOrders(orders [][]int) [][]int {
+	clone := make([][]int, len(orders))
+	for i, order := range orders {
+		clone[i] = append([]int{}, order...)
+	}
+	return clone
+}
+
+// writeRoutes writes the routes in the format of data/routes.csv
+func writeRoutes(filePath string, teamNames []string, routes [][]block) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.UseCRLF = true
+	writer.Write([]string{"TeamName", "Route"})
+	for i, teamName := range teamNames {
+		record := []string{teamName}
+		for _, b := range routes[i] {
+			record = append(record, b.Keys...)
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// config holds the settings of the server itself. Every setting comes from
+// the config file, the environment or a flag, in increasing priority.
+type config struct {
+	Port         int
+	Database     string
+	ClientDir    string // Templates and static files
+	UploadsDir   string // Photos uploaded by the teams
+	DataDir      string // Catalog, routes, media lists and messages
+	ActionLog    string // Events of the teams and organizers as JSON lines
+	FinishedLog  string
+	GameDuration time.Duration
+	EnvFile      string // Team credentials and game settings
+
+	ActionLogMaxSize int64 // Bytes, the log is rotated beyond
+	ActionLogBackups int   // Rotated logs kept
+
+	ReadTimeout     time.Duration // Reading a whole request, uploads included
+	WriteTimeout    time.Duration // Writing a whole response, media included
+	IdleTimeout     time.Duration // Keeping an idle connection open
+	ShutdownTimeout time.Duration // Waiting for requests in flight on shutdown
+}
+
+// cfg is the configuration the server was started with
+var cfg config
+
+// Config file read when no other file is given
+const defaultConfigFile = "treasurehunt.toml"
+
+// configSetting describes a setting by its key in the config file, its
+// environment variable and its flag
+type configSetting struct {
+	key   string
+	env   string
+	flag  string
+	value string // Default
+	path  bool   // Relative paths in the config file start at the file's folder
+	usage string
+}
+
+var configSettings = []configSetting{
+	{"port", "PORT", "port", "8080", false, "port of the web server"},
+	{"database", "DATABASE", "database", "treasure_hunt.db", true, "SQLite database file"},
+	{"client_dir", "CLIENT_DIR", "client-dir", "../client", true, "folder of the templates and static files"},
+	{"uploads_dir", "UPLOADS_DIR", "uploads-dir", "uploads", true, "folder of the photos uploaded by the teams"},
+	{"data_dir", "DATA_DIR", "data-dir", "data", true, "folder of the quest catalog, routes and messages"},
+	{"action_log", "ACTION_LOG", "action-log", "team_actions.log", true, "event log of the teams and organizers"},
+	{"action_log_max_mb", "ACTION_LOG_MAX_MB", "action-log-max-mb", "10", false, "size in MB at which the event log is rotated"},
+	{"action_log_backups", "ACTION_LOG_BACKUPS", "action-log-backups", "5", false, "number of rotated event logs kept"},
+	{"finished_log", "FINISHED_LOG", "finished-log", "teams_finished.log", true, "log of the final results"},
+	{"game_duration", "GAME_DURATION", "game-duration", "2h", false, "length of the game, e.g. 2h or 90m"},
+	{"env_file", "ENV_FILE", "env-file", ".env", true, "file with the team credentials and game settings"},
+	{"read_timeout", "READ_TIMEOUT", "read-timeout", "1m", false, "time to read a request, photo uploads included"},
+	{"write_timeout", "WRITE_TIMEOUT", "write-timeout", "5m", false, "time to write a response, videos included"},
+	{"idle_timeout", "IDLE_TIMEOUT", "idle-timeout", "2m", false, "time to keep an idle connection open"},
+	{"shutdown_timeout", "SHUTDOWN_TIMEOUT", "shutdown-timeout", "30s", false, "time to finish the requests in flight on shutdown"},
+}
+
+// loadConfig reads the configuration from the config file, the environment
+// and the command-line arguments. The file given with -config or CONFIG_FILE
+// must exist; treasurehunt.toml in the working directory is optional.
+// Commands may register their own flags on the flag set first.
+func loadConfig(flags *flag.FlagSet, args []string) (config, error) {
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "config file (default "+defaultConfigFile+" if present)")
+	flagValues := map[string]*string{}
+	for _, setting := range configSettings {
+		flagValues[setting.key] = flags.String(setting.flag, "", setting.usage+" (default "+strconv.Quote(setting.value)+")")
+	}
+	if err := flags.Parse(args); err != nil {
+		return config{}, err
+	}
+
+	values := map[string]string{}
+	explicit := map[string]bool{} // Settings not left at their default
+	for _, setting := range configSettings {
+		values[setting.key] = setting.value
+	}
+
+	// The config file
+	path := *configFile
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			path = defaultConfigFile
+		}
+	}
+	if path != "" {
+		fileValues, err := readConfigFile(path)
+		if err != nil {
+			return config{}, err
+		}
+		for key, value := range fileValues {
+			values[key] = value
+			explicit[key] = true
+		}
+	}
+
+	// The env file is loaded first, so it can override the config file too
+	envFile := values["env_file"]
+	if value := os.Getenv("ENV_FILE"); value != "" {
+		envFile, explicit["env_file"] = value, true
+	}
+	if value := *flagValues["env_file"]; value != "" {
+		envFile, explicit["env_file"] = value, true
+	}
+	if err := loadEnvFile(envFile, explicit["env_file"]); err != nil {
+		return config{}, err
+	}
+
+	// The environment, then the flags
+	for _, setting := range configSettings {
+		if value := os.Getenv(setting.env); value != "" {
+			values[setting.key] = value
+		}
+		if value := *flagValues[setting.key]; value != "" {
+			values[setting.key] = value
+		}
+	}
+	values["env_file"] = envFile
+
+	return parseConfig(values)
+}
+
+// readConfigFile reads a config file written in a subset of TOML: one
+// key = value pair per line with strings, integers or booleans, and #
+// comments. Relative paths are made relative to the file's folder.
+func readConfigFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %v", err)
+	}
+	defer file.Close()
+
+	settings := map[string]configSetting{}
+	for _, setting := range configSettings {
+		settings[setting.key] = setting
+	}
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, raw, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, line)
+		}
+		key = strings.TrimSpace(key)
+		setting, known := settings[key]
+		if !known {
+			return nil, fmt.Errorf("%s:%d: unknown setting %q", path, line, key)
+		}
+
+		value, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", path, line, key, err)
+		}
+		if setting.path && value != "" && !filepath.IsAbs(value) {
+			value = filepath.Join(filepath.Dir(path), value)
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("config file: %v", err)
+	}
+	return values, nil
+}
+
+// parseConfigValue reads a TOML string, integer or boolean, followed by an
+// optional comment
+func parseConfigValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		// Basic string with escapes, find its closing quote
+		for end := 1; end < len(raw); end++ {
+			if raw[end] == '\\' {
+				end++
+				continue
+			}
+			if raw[end] == '"' {
+				if err := checkTrailing(raw[end+1:]); err != nil {
+					return "", err
+				}
+				return strconv.Unquote(raw[:end+1])
+			}
+		}
+		return "", errors.New("unterminated string")
+
+	case strings.HasPrefix(raw, "'"):
+		// Literal string without escapes
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		if err := checkTrailing(raw[end+2:]); err != nil {
+			return "", err
+		}
+		return raw[1 : end+1], nil
+	}
+
+	value, _, _ := strings.Cut(raw, "#")
+	value = strings.TrimSpace(value)
+	if value == "true" || value == "false" {
+		return value, nil
+	}
+	if _, err := strconv.ParseInt(strings.ReplaceAll(value, "_", ""), 10, 64); err == nil {
+		return strings.ReplaceAll(value, "_", ""), nil
+	}
+	return "", fmt.Errorf("invalid value %q, strings need quotes", value)
+}
+
+// checkTrailing accepts only a comment after a value
+func checkTrailing(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected %q after the value", rest)
+	}
+	return nil
+}
+
+// loadEnvFile loads the environment variables of the env file, keeping the
+// ones already set. A missing default file is fine, e.g. when everything is
+// set in the environment.
+func loadEnvFile(path string, required bool) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) && !required {
+		fmt.Printf("No %s file, using the environment only.\n", path)
+		return nil
+	}
+	if err := godotenv.Load(path); err != nil {
+		return fmt.Errorf("env file: %v", err)
+	}
+	return nil
+}
+
+// parseConfig converts and validates the settings. All problems are
+// reported at once.
+func parseConfig(values map[string]string) (config, error) {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	c := config{
+		Database:    values["database"],
+		ClientDir:   values["client_dir"],
+		UploadsDir:  values["uploads_dir"],
+		DataDir:     values["data_dir"],
+		ActionLog:   values["action_log"],
+		FinishedLog: values["finished_log"],
+		EnvFile:     values["env_file"],
+	}
+
+	port, err := strconv.Atoi(values["port"])
+	if err != nil || port < 1 || port > 65535 {
+		problem("port: %q is not a port number between 1 and 65535", values["port"])
+	}
+	c.Port = port
+
+	maxSize, err := strconv.Atoi(values["action_log_max_mb"])
+	if err != nil || maxSize < 1 {
+		problem("action_log_max_mb: %q is not a positive number of megabytes", values["action_log_max_mb"])
+	}
+	c.ActionLogMaxSize = int64(maxSize) << 20
+
+	backups, err := strconv.Atoi(values["action_log_backups"])
+	if err != nil || backups < 0 {
+		problem("action_log_backups: %q is not a number of files", values["action_log_backups"])
+	}
+	c.ActionLogBackups = backups
+
+	duration := func(key string) time.Duration {
+		value, err := time.ParseDuration(values[key])
+		if err != nil || value <= 0 {
+			problem("%s: %q is not a positive duration such as 2h or 90m", key, values[key])
+		}
+		return value
+	}
+	c.GameDuration = duration("game_duration")
+	c.ReadTimeout = duration("read_timeout")
+	c.WriteTimeout = duration("write_timeout")
+	c.IdleTimeout = duration("idle_timeout")
+	c.ShutdownTimeout = duration("shutdown_timeout")
+
+	requireFile := func(key, path string) {
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			problem("%s: %s not found", key, path)
+		}
+	}
+	requireDir := func(key, path string) bool {
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			problem("%s: folder %s not found", key, path)
+			return false
+		}
+		return true
+	}
+
+	if requireDir("client_dir", c.ClientDir) {
+		requireFile("client_dir", filepath.Join(c.ClientDir, "treasurehunt.html"))
+	}
+	if requireDir("data_dir", c.DataDir) {
+		requireFile("data_dir", filepath.Join(c.DataDir, "catalog.csv"))
+		requireFile("data_dir", filepath.Join(c.DataDir, "messages.csv"))
+	}
+
+	// Files are created when missing, but their folders must exist
+	for _, file := range []struct{ key, path string }{
+		{"database", c.Database},
+		{"uploads_dir", c.UploadsDir},
+		{"action_log", c.ActionLog},
+		{"finished_log", c.FinishedLog},
+	} {
+		if file.path == "" {
+			problem("%s: must not be empty", file.key)
+			continue
+		}
+		requireDir(file.key, filepath.Dir(file.path))
+	}
+
+	return c, errors.Join(problems...)
+}
+
+// setupCommand prepares a command that reads the database of a game, such
+// as export. The returned function closes the database again.
+func setupCommand(flags *flag.FlagSet, args []string) (func(), error) {
+	c, err := loadConfig(flags, args)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(c.Database); err != nil {
+		return nil, fmt.Errorf("database: %v", err)
+	}
+
+	setup(c)
+	currentRun = latestRun()
+	return func() {
+		events.close()
+		db.Close()
+	}, nil
+}
+
+// useDataDir points the paths of the server data files to a data folder. The
+// quest data files of a game are in the data folder of the game.
+func useDataDir(dir string) {
+	gamesPath = filepath.Join(dir, "games.csv")
+	teamsPath = filepath.Join(dir, "teams.csv")
+	messagesPath = filepath.Join(dir, "messages.csv")
+	organizersPath = filepath.Join(dir, "organizers.csv")
+}
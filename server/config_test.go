@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigValue(t *testing.T) {
+	tests := []struct {
+		raw, want string
+		ok        bool
+	}{
+		{`"plain"`, "plain", true},
+		{`"with # hash" # comment`, "with # hash", true},
+		{`"escaped \"quote\" and \\ slash"`, `escaped "quote" and \ slash`, true},
+		{`"tab\there"`, "tab\there", true},
+		{`'C:\data\hunt.db'`, `C:\data\hunt.db`, true},
+		{`'literal' # comment`, "literal", true},
+		{`""`, "", true},
+		{`8080`, "8080", true},
+		{`10_000 # bytes`, "10000", true},
+		{`-3`, "-3", true},
+		{`true`, "true", true},
+		{`false#comment`, "false", true},
+		{`"unterminated`, "", false},
+		{`"ends with escape\"`, "", false},
+		{`'unterminated`, "", false},
+		{`"value" trailing`, "", false},
+		{`'value' trailing`, "", false},
+		{`bare`, "", false},
+		{`2h`, "", false},
+		{``, "", false},
+	}
+	for _, test := range tests {
+		got, err := parseConfigValue(test.raw)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("parseConfigValue(%s) = %q, %v, want %q, ok %v", test.raw, got, err, test.want, test.ok)
+		}
+	}
+}
+
+// writeConfigFile writes a config file to a temporary folder
+func writeConfigFile(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "treasurehunt.toml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	absolute := filepath.Join(t.TempDir(), "uploads")
+	path := writeConfigFile(t, dir, "# Settings of the test\n"+
+		"\n"+
+		"  port = 9000   # inline comment\n"+
+		"database = \"games/hunt.db\"\n"+
+		"uploads_dir = '"+absolute+"'\n"+
+		"action_log = \"\"\n"+
+		"game_duration = \"90m\"\n")
+
+	values, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"port":          "9000",
+		"database":      filepath.Join(dir, "games", "hunt.db"), // Relative to the file
+		"uploads_dir":   absolute,
+		"action_log":    "", // Empty paths stay empty
+		"game_duration": "90m",
+	}
+	if len(values) != len(want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("%s = %q, want %q", key, values[key], value)
+		}
+	}
+
+	invalid := []struct{ content, message string }{
+		{"port = 1\ncolour = \"red\"\n", `:2: unknown setting "colour"`},
+		{"# comment\nport 9000\n", ":2: expected key = value"},
+		{"database = hunt.db\n", ":1: database: invalid value"},
+		{"database = \"hunt.db\n", ":1: database: unterminated string"},
+	}
+	for _, test := range invalid {
+		_, err := readConfigFile(writeConfigFile(t, t.TempDir(), test.content))
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%q: error %v, want %q", test.content, err, test.message)
+		}
+	}
+
+	if _, err := readConfigFile(filepath.Join(dir, "missing.toml")); err == nil {
+		t.Error("a missing config file is accepted")
+	}
+}
+
+// clearConfigEnv unsets the environment variables of the settings for the
+// test
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"CONFIG_FILE", "ENV_FILE"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	for _, setting := range configSettings {
+		t.Setenv(setting.env, "") // Restored when the test ends
+		os.Unsetenv(setting.env)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	clearConfigEnv(t)
+	dir := t.TempDir()
+	configPath := writeConfigFile(t, dir, "port = 9001\n"+
+		"database = 'hunt.db'\n"+
+		"game_duration = \"90m\"\n"+
+		"action_log_backups = 3\n"+
+		"idle_timeout = \"5m\"\n")
+	envPath := filepath.Join(dir, "test.env")
+	os.WriteFile(envPath, []byte("ACTION_LOG_BACKUPS=4\nGAME_DURATION=30m\n"), 0o644)
+
+	t.Setenv("PORT", "9002")
+	t.Setenv("GAME_DURATION", "45m") // The environment wins over the env file
+	t.Setenv("IDLE_TIMEOUT", "3m")
+	c, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{
+		"-config", configPath,
+		"-env-file", envPath,
+		"-port", "9003",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port != 9003 {
+		t.Errorf("port = %d, want the flag", c.Port)
+	}
+	if c.GameDuration != 45*time.Minute || c.IdleTimeout != 3*time.Minute {
+		t.Errorf("durations = %v, %v, want the environment", c.GameDuration, c.IdleTimeout)
+	}
+	if c.ActionLogBackups != 4 {
+		t.Errorf("backups = %d, want the env file", c.ActionLogBackups)
+	}
+	if c.Database != filepath.Join(dir, "hunt.db") {
+		t.Errorf("database = %q, want the config file", c.Database)
+	}
+	if c.WriteTimeout != 5*time.Minute || c.ActionLogMaxSize != 10<<20 {
+		t.Errorf("defaults = %v, %d", c.WriteTimeout, c.ActionLogMaxSize)
+	}
+	if c.EnvFile != envPath {
+		t.Errorf("env file = %q", c.EnvFile)
+	}
+
+	// A file given with CONFIG_FILE must exist
+	clearConfigEnv(t)
+	t.Setenv("CONFIG_FILE", filepath.Join(dir, "missing.toml"))
+	if _, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-env-file", envPath}); err == nil {
+		t.Error("a missing CONFIG_FILE is accepted")
+	}
+
+	// So must an env file that was asked for
+	clearConfigEnv(t)
+	if _, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{
+		"-config", configPath,
+		"-env-file", filepath.Join(dir, "missing.env"),
+	}); err == nil {
+		t.Error("a missing -env-file is accepted")
+	}
+}
+
+func TestParseConfigProblems(t *testing.T) {
+	values := map[string]string{}
+	for _, setting := range configSettings {
+		values[setting.key] = setting.value
+	}
+	values["port"] = "70000"
+	values["game_duration"] = "soon"
+	values["action_log_backups"] = "-1"
+	values["data_dir"] = filepath.Join(t.TempDir(), "missing")
+	values["database"] = ""
+
+	_, err := parseConfig(values)
+	if err == nil {
+		t.Fatal("invalid settings are accepted")
+	}
+	// All problems are reported at once
+	for _, key := range []string{"port:", "game_duration:", "action_log_backups:", "data_dir:", "database: must not be empty"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error %q doesn't mention %s", err, key)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// questForm is a quest definition as shown in the editor form
+type questForm struct {
+	ID                 uint
+	Key                string
+	Position           int
+	Text               string
+	Answers            string // One answer per line
+	Hint               string
+	ImagePath          string
+	AudioPath          string
+	FileRequired       bool
+	QuestTimerRequired bool
+	QuestTimerDuration string
+	QuestTimerMode     string
+	HintTimerRequired  bool
+	HintTimerDuration  string
+	Prerequisites      string
+	Fixed              string
+	Location           string
+	QuestType          string
+	Latitude           string
+	Longitude          string
+	Radius             string
+	Options            string // One puzzle item per line
+}
+
+// newQuestForm fills the editor form from a quest definition
+func newQuestForm(definition QuestDefinition) questForm {
+	formatDuration := func(value time.Duration) string {
+		if value == 0 {
+			return ""
+		}
+		return value.String()
+	}
+	formatFloat := func(value float64) string {
+		if value == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	return questForm{
+		ID:                 definition.ID,
+		Key:                definition.Key,
+		Position:           definition.Position,
+		Text:               definition.Text,
+		Answers:            strings.ReplaceAll(definition.CorrectAnswers, "|", "\n"),
+		Hint:               definition.Hint,
+		ImagePath:          definition.ImagePath,
+		AudioPath:          definition.AudioPath,
+		FileRequired:       definition.FileRequired,
+		QuestTimerRequired: definition.QuestTimerRequired,
+		QuestTimerDuration: formatDuration(definition.QuestTimerDuration),
+		QuestTimerMode:     parseTimerMode(definition.QuestTimerMode),
+		HintTimerRequired:  definition.HintTimerRequired,
+		HintTimerDuration:  formatDuration(definition.HintTimerDuration),
+		Prerequisites:      definition.Prerequisites,
+		Fixed:              definition.Fixed,
+		Location:           definition.Location,
+		QuestType:          definition.QuestType,
+		Latitude:           formatFloat(definition.Latitude),
+		Longitude:          formatFloat(definition.Longitude),
+		Radius:             formatFloat(definition.Radius),
+		Options:            strings.ReplaceAll(definition.Options, "|", "\n"),
+	}
+}
+
+// applyQuestForm copies the submitted editor form into a quest definition. It
+// refuses puzzles that no team could solve.
+func applyQuestForm(r *http.Request, definition *QuestDefinition) error {
+	lines := func(field string) string {
+		var values []string
+		for _, value := range strings.Split(r.FormValue(field), "\n") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		return strings.Join(values, "|")
+	}
+
+	definition.Text = strings.ReplaceAll(r.FormValue("text"), "\r\n", "\n")
+	definition.CorrectAnswers = lines("answers")
+	definition.Hint = strings.ReplaceAll(r.FormValue("hint"), "\r\n", "\n")
+	definition.ImagePath = strings.TrimSpace(r.FormValue("image_path"))
+	definition.AudioPath = strings.TrimSpace(r.FormValue("audio_path"))
+	definition.FileRequired = r.FormValue("file_required") != ""
+	definition.QuestTimerRequired = r.FormValue("quest_timer_required") != ""
+	definition.QuestTimerDuration = parseDuration(strings.TrimSpace(r.FormValue("quest_timer_duration")))
+	definition.QuestTimerMode = parseTimerMode(r.FormValue("quest_timer_mode"))
+	definition.HintTimerRequired = r.FormValue("hint_timer_required") != ""
+	definition.HintTimerDuration = parseDuration(strings.TrimSpace(r.FormValue("hint_timer_duration")))
+	definition.Prerequisites = strings.TrimSpace(r.FormValue("prerequisites"))
+	definition.Fixed = strings.ToLower(strings.TrimSpace(r.FormValue("fixed")))
+	definition.Location = strings.TrimSpace(r.FormValue("location"))
+	definition.QuestType = strings.ToLower(strings.TrimSpace(r.FormValue("quest_type")))
+	definition.Latitude = parseFloat(r.FormValue("latitude"))
+	definition.Longitude = parseFloat(r.FormValue("longitude"))
+	definition.Radius = parseFloat(r.FormValue("radius"))
+	definition.Options = lines("options")
+	return validatePuzzle(*definition)
+}
+
+// saveCatalog writes the edited catalog of a game back to its CSV file, so
+// the next start of the server seeds the same quests
+func saveCatalog(game *Game) error {
+	var definitions []QuestDefinition
+	db.Where("game = ?", game.Key).Order("position").Find(&definitions)
+
+	// Keep the positions continuous after moves and deletions
+	for i := range definitions {
+		if definitions[i].Position != i+1 {
+			definitions[i].Position = i + 1
+			db.Model(&definitions[i]).Update("position", i+1)
+		}
+	}
+
+	if err := writeCatalog(game.catalogPath(), definitions); err != nil {
+		return err
+	}
+	if err := writeQuestMedia(game.mediaPath(), definitions); err != nil {
+		return err
+	}
+	return writeQuestTranslations(game.translationsPath(), definitions)
+}
+
+// nextQuestKey returns a numeric key not used by any quest of a game yet
+func nextQuestKey(game *Game) string {
+	var definitions []QuestDefinition
+	db.Unscoped().Where("game = ?", game.Key).Find(&definitions)
+
+	next := len(definitions) + 1
+	for _, definition := range definitions {
+		if key, err := strconv.Atoi(definition.Key); err == nil && key >= next {
+			next = key + 1
+		}
+	}
+	return strconv.Itoa(next)
+}
+
+// saveQuestMedia stores a file uploaded in the editor in the asset library
+// and returns its path for the catalog
+func saveQuestMedia(r *http.Request, field string) (string, error) {
+	file, header, err := r.FormFile(field)
+	if err == http.ErrMissingFile {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return saveAsset(file, header)
+}
+
+// handleAdminQuests lists the quest catalog of the organizer's game
+func handleAdminQuests(w http.ResponseWriter, r *http.Request) {
+	var definitions []QuestDefinition
+	db.Where("game = ?", adminGame(r).Key).Order("position").Find(&definitions)
+
+	type row struct {
+		QuestDefinition
+		Preview string
+	}
+	data := struct {
+		GameSwitcher gameSwitcher
+		Quests       []row
+		Message      string
+		Explicit     bool // Routes come from the routes file, not the catalog order
+	}{
+		GameSwitcher: newGameSwitcher(r),
+		Explicit:     adminGame(r).RouteMode == routeModeExplicit,
+	}
+
+	switch r.URL.Query().Get("saved") {
+	case "true":
+		// data.Message = "The catalog was saved. New, moved and deleted quests change the routes after a restart."
+		data.Message = "Каталогът е записан. Новите, преместените и изтритите задачи променят маршрутите след рестарт."
+	case "false":
+		// data.Message = "The catalog file could not be saved, see the server log."
+		data.Message = "Файлът на каталога не можа да бъде записан, вижте лога на сървъра."
+	}
+
+	for _, definition := range definitions {
+		preview := []rune(strings.SplitN(definition.Text, "\n", 2)[0])
+		if len(preview) > 80 {
+			preview = append(preview[:80], '…')
+		}
+		data.Quests = append(data.Quests, row{QuestDefinition: definition, Preview: string(preview)})
+	}
+
+	if err := templates.ExecuteTemplate(w, "admin_quests.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleAdminQuestEdit shows the editor form of a quest and saves it. Without
+// an ID a new quest is added to the end of the catalog of the organizer's
+// game.
+func handleAdminQuestEdit(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.ParseMultipartForm(50 << 20) // Quest audio can be larger than team photos
+	}
+
+	game := adminGame(r)
+	var definition QuestDefinition
+	if id := r.FormValue("id"); id != "" && id != "0" {
+		if err := db.Where("id = ? AND game = ?", id, game.Key).First(&definition).Error; err != nil {
+			http.Error(w, "Задачата не е намерена", http.StatusNotFound)
+			return
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		form := newQuestForm(definition)
+		if definition.ID == 0 {
+			form.Key = nextQuestKey(game)
+		}
+
+		data := struct {
+			Game       *Game
+			Quest      questForm
+			TimerModes []string
+			QuestTypes []string
+			Assets     []asset
+			Media      []QuestMedia
+			MediaKinds []string
+
+			Translations []QuestTranslation
+		}{
+			Game:       game,
+			Quest:      form,
+			TimerModes: []string{timerModeWait, timerModeSkip, timerModeFail, timerModePenalty},
+			QuestTypes: []string{questTypeText, questTypeGeo, questTypeCheckpoint, questTypeChoice, questTypeOrder, questTypeMatch, questTypeGrid},
+			Assets:     listAssets(nil),
+			// Blank rows let the organizers add items to the list
+			Media:      append(definitionMedia(definition.ID), make([]QuestMedia, 3)...),
+			MediaKinds: []string{mediaKindImage, mediaKindAudio, mediaKindVideo, mediaKindFile},
+
+			Translations: translationForms(definition.ID),
+		}
+		if err := templates.ExecuteTemplate(w, "admin_quest_edit.html", data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	isNew := definition.ID == 0
+	if isNew {
+		definition.Game = game.Key
+		definition.Key = strings.TrimSpace(r.FormValue("key"))
+		if definition.Key == "" {
+			definition.Key = nextQuestKey(game)
+		}
+
+		var count int
+		db.Model(&QuestDefinition{}).Unscoped().Where("game = ? AND key = ?", game.Key, definition.Key).Count(&count)
+		if count > 0 {
+			http.Error(w, "Вече има задача с този ключ", http.StatusBadRequest)
+			return
+		}
+
+		var last QuestDefinition
+		db.Where("game = ?", game.Key).Order("position desc").First(&last)
+		definition.Position = last.Position + 1
+	}
+
+	if err := applyQuestForm(r, &definition); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, media := range []struct {
+		field  string
+		target *string
+	}{
+		{"image_file", &definition.ImagePath},
+		{"audio_file", &definition.AudioPath},
+	} {
+		mediaPath, err := saveQuestMedia(r, media.field)
+		if err != nil {
+			log.Printf("Quest media upload failed: %v", err)
+			http.Error(w, "Файлът не можа да бъде качен", http.StatusBadRequest)
+			return
+		}
+		if mediaPath != "" {
+			*media.target = mediaPath
+		}
+	}
+
+	if err := db.Save(&definition).Error; err != nil {
+		log.Printf("Failed to save quest %s: %v", definition.Key, err)
+		http.Error(w, "Задачата не можа да бъде записана", http.StatusInternalServerError)
+		return
+	}
+	// Files uploaded for the media list are added to its end
+	paths, kinds, captions, altTexts, positions := r.Form["media_path"], r.Form["media_kind"], r.Form["media_caption"], r.Form["media_alt"], r.Form["media_position"]
+	if r.MultipartForm != nil {
+		for _, header := range r.MultipartForm.File["media_files"] {
+			file, err := header.Open()
+			if err != nil {
+				continue
+			}
+			mediaPath, err := saveAsset(file, header)
+			file.Close()
+			if err != nil {
+				log.Printf("Quest media upload failed: %v", err)
+				continue
+			}
+			paths = append(paths, mediaPath)
+		}
+	}
+	applyMediaForm(definition.ID, paths, kinds, captions, altTexts, positions)
+	applyTranslationsForm(definition.ID, r.Form["translation_language"], r.Form["translation_text"], r.Form["translation_hint"])
+
+	if isNew && game.RouteMode == routeModeExplicit {
+		if err := addToRoutes(game.routesPath(), definition.Key, ""); err != nil {
+			log.Printf("Failed to add quest %s to the routes: %v", definition.Key, err)
+		}
+	}
+	ensureCheckpoints(db, []QuestDefinition{definition}, gameTeamNames(game.Key))
+	log.Printf("Quest %s of game %s saved in the editor", definition.Key, game.Key)
+
+	redirectAfterCatalogChange(w, r, game)
+}
+
+// redirectAfterCatalogChange saves the catalog file of the game and returns
+// to the list
+func redirectAfterCatalogChange(w http.ResponseWriter, r *http.Request, game *Game) {
+	saved := "true"
+	if err := saveCatalog(game); err != nil {
+		log.Printf("Failed to write the quest catalog: %v", err)
+		saved = "false"
+	}
+	http.Redirect(w, r, "/admin/quests?saved="+saved, http.StatusSeeOther)
+}
+
+// handleAdminQuestAction duplicates, moves or deletes a quest
+func handleAdminQuestAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/quests", http.StatusSeeOther)
+		return
+	}
+
+	game := adminGame(r)
+	var definition QuestDefinition
+	if err := db.Where("id = ? AND game = ?", r.FormValue("id"), game.Key).First(&definition).Error; err != nil {
+		http.Error(w, "Задачата не е намерена", http.StatusNotFound)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "duplicate":
+		// Make room for the copy right after the original
+		db.Model(&QuestDefinition{}).Where("game = ? AND position > ?", game.Key, definition.Position).
+			UpdateColumn("position", gorm.Expr("position + 1"))
+
+		duplicate := definition
+		duplicate.Model = gorm.Model{}
+		duplicate.Key = nextQuestKey(game)
+		duplicate.Position = definition.Position + 1
+		db.Create(&duplicate)
+		for _, item := range definitionMedia(definition.ID) {
+			item.Model = gorm.Model{}
+			item.DefinitionID = duplicate.ID
+			db.Create(&item)
+		}
+		for _, translation := range definitionTranslations(definition.ID) {
+			translation.Model = gorm.Model{}
+			translation.DefinitionID = duplicate.ID
+			db.Create(&translation)
+		}
+		if game.RouteMode == routeModeExplicit {
+			if err := addToRoutes(game.routesPath(), duplicate.Key, definition.Key); err != nil {
+				log.Printf("Failed to add quest %s to the routes: %v", duplicate.Key, err)
+			}
+		}
+		ensureCheckpoints(db, []QuestDefinition{duplicate}, gameTeamNames(game.Key))
+		log.Printf("Quest %s duplicated as %s in the editor", definition.Key, duplicate.Key)
+
+	case "up", "down":
+		var neighbour QuestDefinition
+		query := db.Where("game = ? AND position < ?", game.Key, definition.Position).Order("position desc")
+		if r.FormValue("action") == "down" {
+			query = db.Where("game = ? AND position > ?", game.Key, definition.Position).Order("position")
+		}
+		if query.First(&neighbour).Error == nil {
+			from, to := definition.Position, neighbour.Position
+			db.Model(&definition).Update("position", to)
+			db.Model(&neighbour).Update("position", from)
+		}
+
+	case "delete":
+		// Teams playing the quest lose it immediately
+		db.Where("definition_id = ?", definition.ID).Delete(&Quest{})
+		db.Delete(&definition)
+		db.Unscoped().Where("definition_id = ?", definition.ID).Delete(&QuestMedia{})
+		db.Unscoped().Where("definition_id = ?", definition.ID).Delete(&QuestTranslation{})
+		if err := removeFromRoutes(game.routesPath(), definition.Key); err != nil {
+			log.Printf("Failed to remove quest %s from the routes: %v", definition.Key, err)
+		}
+		log.Printf("Quest %s deleted in the editor", definition.Key)
+	}
+
+	redirectAfterCatalogChange(w, r, game)
+}
+
+// handleAdminQuestPreview renders a quest with treasurehunt.html as a team
+// sees it when it first opens the quest
+func handleAdminQuestPreview(w http.ResponseWriter, r *http.Request) {
+	game := adminGame(r)
+	var definition QuestDefinition
+	if err := db.Where("id = ? AND game = ?", r.FormValue("id"), game.Key).First(&definition).Error; err != nil {
+		http.Error(w, "Задачата не е намерена", http.StatusNotFound)
+		return
+	}
+
+	var totalQuests int64
+	db.Model(&QuestDefinition{}).Where("game = ?", game.Key).Count(&totalQuests)
+
+	quest := Quest{
+		QuestNumber:  definition.Position,
+		DefinitionID: definition.ID,
+		Definition:   definition,
+		StartedAt:    time.Now(),
+	}
+
+	data := questPage{
+		Username:     "Преглед",
+		StartTime:    time.Now().Format(time.RFC3339),
+		ElapsedTime:  time.Duration(0).String(),
+		CurrentQuest: quest.QuestNumber,
+		TotalQuests:  totalQuests,
+	}
+	if definition.QuestTimerRequired {
+		data.QuestTimerRemaining = definition.QuestTimerDuration.String()
+		data.QuestTimerEndTime = time.Now().Add(definition.QuestTimerDuration).Format(time.RFC3339)
+	}
+	if definition.HintTimerRequired {
+		data.HintTimerRemaining = definition.HintTimerDuration.String()
+		data.HintTimerEndTime = time.Now().Add(definition.HintTimerDuration).Format(time.RFC3339)
+	}
+
+	data.prepare(r, "", quest)
+	data.Game = game
+
+	// Team media URLs only work for teams, so the preview loads the files directly
+	if definition.ImagePath != "" {
+		data.Quest.ImageURL = fmt.Sprintf("/admin/quests/media?id=%d&kind=image", definition.ID)
+	}
+	if definition.AudioPath != "" {
+		data.Quest.AudioURL = fmt.Sprintf("/admin/quests/media?id=%d&kind=audio", definition.ID)
+	}
+	assetURL := func(mediaPath string) string {
+		if strings.HasPrefix(mediaPath, "/static/") {
+			return "/admin/assets/file?path=" + url.QueryEscape(mediaPath)
+		}
+		return contentURL("")(mediaPath)
+	}
+	data.Quest.Media = mediaViews(definition.ID, assetURL)
+	text, _ := questTexts(definition, data.Lang)
+	data.Quest.TextHTML = renderMarkdown(text, assetURL)
+
+	if err := templates.ExecuteTemplate(w, "treasurehunt.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleAdminQuestMedia serves the image or audio of a quest to the organizers
+func handleAdminQuestMedia(w http.ResponseWriter, r *http.Request) {
+	var definition QuestDefinition
+	if err := db.Where("id = ?", r.FormValue("id")).First(&definition).Error; err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	mediaPath := definition.ImagePath
+	if r.FormValue("kind") == "audio" {
+		mediaPath = definition.AudioPath
+	}
+
+	filePath, ok := mediaFile(mediaPath)
+	if mediaPath == "" || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filePath)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEditorRefusesOtherSites(t *testing.T) {
+	handler := newTestServer(t)
+
+	paths := []string{
+		"/admin/quests/edit",
+		"/admin/quests/action",
+		"/admin/assets",
+		"/admin/assets/delete",
+	}
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			w := adminPost(handler, path, "https://evil.example.com", url.Values{"action": {"delete"}})
+			if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "друг сайт") {
+				t.Errorf("from another site: got %d %q, want 403", w.Code, w.Body.String())
+			}
+
+			w = adminPost(handler, path, "http://example.com", url.Values{"action": {"delete"}})
+			if strings.Contains(w.Body.String(), "друг сайт") {
+				t.Errorf("from the site itself: refused as another site")
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of the events in the event log
+const (
+	eventLogin         = "login"
+	eventLoginFailed   = "login_failed"
+	eventQuestStart    = "quest_start"
+	eventQuestChosen   = "quest_chosen"
+	eventHint          = "hint"
+	eventSkip          = "skip"
+	eventComplete      = "complete"
+	eventWrongAnswer   = "wrong_answer"
+	eventUpload        = "upload"
+	eventLateAnswer    = "late_answer"
+	eventTimerSkip     = "timer_skip"
+	eventTimerFail     = "timer_fail"
+	eventCheckIn       = "checkin"
+	eventBadCheckpoint = "checkpoint_invalid"
+	eventGameFinished  = "game_finished"
+	eventAdmin         = "admin"
+
+	// A judge rejected the answer of a solved quest. Answers a judge accepts
+	// are complete events with the judge in the details.
+	eventAnswerRejected = "answer_rejected"
+)
+
+// event is one line of the event log. Details holds what is particular to
+// the type, such as the answer of a wrong_answer event.
+type event struct {
+	Time      time.Time         `json:"time"`
+	Type      string            `json:"type"`
+	Game      string            `json:"game,omitempty"`
+	Team      string            `json:"team,omitempty"`
+	Quest     int               `json:"quest,omitempty"`
+	QuestKey  string            `json:"questKey,omitempty"`
+	RequestID string            `json:"requestId,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// questEvent starts an event about a quest of a team. The request may be nil
+// for events of the background workers.
+func questEvent(r *http.Request, eventType string, quest Quest) event {
+	return event{
+		Type:      eventType,
+		Team:      quest.TeamName,
+		Quest:     quest.QuestNumber,
+		QuestKey:  quest.Definition.Key,
+		RequestID: requestID(r),
+	}
+}
+
+// eventLog appends events as JSON lines and rotates the file once it grows
+// past its maximum size
+type eventLog struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	maxSize int64
+	backups int // Rotated files kept as path.1, path.2, ...
+}
+
+// events is the event log, open while the server runs
+var events = &eventLog{}
+
+// open opens the event log for appending
+func (l *eventLog) open(path string, maxSize int64, backups int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.path, l.maxSize, l.backups = path, maxSize, backups
+	return l.reopen()
+}
+
+// reopen opens the file at the log's path. The caller holds the lock.
+func (l *eventLog) reopen() error {
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// rotate moves the full log to path.1, shifting the older files up and
+// dropping the oldest. Backups missing so far are fine, other failures are
+// logged and the log goes on in the current file. The caller holds the lock.
+func (l *eventLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	var err error
+	if l.backups == 0 {
+		err = os.Remove(l.path)
+	} else {
+		if err := os.Remove(fmt.Sprintf("%s.%d", l.path, l.backups)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing the oldest event log: %v", err)
+		}
+		for i := l.backups - 1; i >= 1; i-- {
+			if err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1)); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error shifting event log backup %d: %v", i, err)
+			}
+		}
+		err = os.Rename(l.path, l.path+".1")
+	}
+
+	if reopenErr := l.reopen(); reopenErr != nil {
+		return reopenErr
+	}
+	return err
+}
+
+// write appends an event. Failures go to the server log, an event is never
+// worth failing a request for.
+func (l *eventLog) write(e event) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Error encoding event: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		log.Printf("Event log closed, dropped event: %s", line)
+		return
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			log.Printf("Error rotating event log: %v", err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("Error writing event log: %v", err)
+	}
+}
+
+// close flushes the event log to disk and closes it
+func (l *eventLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Sync()
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
+
+// logEvent records an event in the event log and the database
+func logEvent(e event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Game == "" && e.Team != "" {
+		e.Game = teamGame(e.Team).Key
+	}
+	events.write(e)
+	saveGameEvent(e)
+	countEvent(e)
+}
+
+// Header carrying the ID of a request, taken from a proxy when it sets one
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// withRequestID gives every request an ID, sent back in the response and
+// recorded with the events of the request
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID of a request, or "" without a request
+func requestID(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random ID of 16 hex digits
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID accepts short IDs of letters, digits and -_.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	return strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") == ""
+}
+
+// statusRecorder remembers the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEvent is an event with a line of a fixed length in the log
+func testEvent(i int) event {
+	return event{Time: time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC), Type: eventLogin, Team: fmt.Sprintf("T%03d", i)}
+}
+
+// testEventSize is the length of the line of a test event
+func testEventSize(t *testing.T) int64 {
+	t.Helper()
+	line, err := json.Marshal(testEvent(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int64(len(line) + 1)
+}
+
+// logTeams returns the teams of the events in a log file, in order
+func logTeams(t *testing.T, path string) []string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var teams []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		teams = append(teams, e.Team)
+	}
+	return teams
+}
+
+func TestEventLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := &eventLog{}
+	if err := l.open(path, 2*testEventSize(t), 2); err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+
+	// Two events fit in a file, so seven events rotate three times and the
+	// oldest file is dropped
+	for i := 1; i <= 7; i++ {
+		l.write(testEvent(i))
+	}
+	l.close()
+
+	want := map[string]string{
+		path:        "T007",
+		path + ".1": "T005 T006",
+		path + ".2": "T003 T004",
+	}
+	for file, teams := range want {
+		if got := strings.Join(logTeams(t, file), " "); got != teams {
+			t.Errorf("%s = %s, want %s", filepath.Base(file), got, teams)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 is kept beyond the backups", path)
+	}
+}
+
+func TestEventLogReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := &eventLog{}
+	if err := l.open(path, 2*testEventSize(t), 1); err != nil {
+		t.Fatal(err)
+	}
+	l.write(testEvent(1))
+	l.close()
+
+	// A closed log drops events instead of failing
+	l.write(testEvent(2))
+
+	// The size of the existing file counts towards the next rotation
+	if err := l.open(path, 2*testEventSize(t), 1); err != nil {
+		t.Fatal(err)
+	}
+	l.write(testEvent(3))
+	l.write(testEvent(4))
+	l.close()
+
+	if got := strings.Join(logTeams(t, path+".1"), " "); got != "T001 T003" {
+		t.Errorf("backup = %s, want T001 T003", got)
+	}
+	if got := strings.Join(logTeams(t, path), " "); got != "T004" {
+		t.Errorf("log = %s, want T004", got)
+	}
+}
+
+func TestEventLogWithoutBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.log")
+	l := &eventLog{}
+	if err := l.open(path, testEventSize(t), 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		l.write(testEvent(i))
+	}
+	l.close()
+
+	if got := strings.Join(logTeams(t, path), " "); got != "T003" {
+		t.Errorf("log = %s, want T003", got)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("files = %v, want only the log", files)
+	}
+}
+
+func TestEventLogRotationErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := &eventLog{}
+	if err := l.open(path, testEventSize(t), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// A folder in the way of the backup can be neither removed nor replaced
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocked"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	l.write(testEvent(1))
+	l.write(testEvent(2))
+	l.close()
+
+	for _, message := range []string{"Error removing the oldest event log", "Error rotating event log"} {
+		if !strings.Contains(output.String(), message) {
+			t.Errorf("server log %q doesn't mention %q", output.String(), message)
+		}
+	}
+	// The events are still written, to the file that couldn't be rotated
+	if got := strings.Join(logTeams(t, path), " "); got != "T001 T002" {
+		t.Errorf("log = %s, want T001 T002", got)
+	}
+}
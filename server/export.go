@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// Export formats and reports. JSON and XLSX hold all reports at once, a CSV
+// file holds one report.
+const (
+	exportCSV  = "csv"
+	exportJSON = "json"
+	exportXLSX = "xlsx"
+
+	reportTeams  = "teams"  // One row per team
+	reportQuests = "quests" // One row per quest of every team
+	reportStats  = "stats"  // One row per quest of the catalog
+)
+
+// questResult is how a team did on a quest
+type questResult struct {
+	Number       int          `json:"number"`
+	Key          string       `json:"key"`
+	Result       string       `json:"result"` // completed, skipped, timed_out, failed or open
+	Late         bool         `json:"late"`
+	Started      optionalTime `json:"started"`
+	Finished     optionalTime `json:"finished"`
+	Seconds      int          `json:"seconds"` // From start to finish
+	Hints        int          `json:"hints"`
+	WrongAnswers int          `json:"wrongAnswers"`
+	Attempts     int          `json:"attempts"` // Wrong answers and the correct one
+}
+
+// teamResult is the final result of a team
+type teamResult struct {
+	Team         string        `json:"team"`
+	Score        int           `json:"score"`
+	Started      optionalTime  `json:"started"` // First login
+	Finished     optionalTime  `json:"finished"`
+	Seconds      int           `json:"seconds"`
+	Completed    int           `json:"completed"`
+	Skipped      int           `json:"skipped"`
+	TimedOut     int           `json:"timedOut"`
+	Failed       int           `json:"failed"`
+	Hints        int           `json:"hints"`
+	WrongAnswers int           `json:"wrongAnswers"`
+	Quests       []questResult `json:"quests"`
+}
+
+// questStats sums up a quest of the catalog over all teams
+type questStats struct {
+	Key            string `json:"key"`
+	Position       int    `json:"position"`
+	Teams          int    `json:"teams"`
+	Completed      int    `json:"completed"`
+	Skipped        int    `json:"skipped"`
+	TimedOut       int    `json:"timedOut"`
+	Failed         int    `json:"failed"`
+	Hints          int    `json:"hints"`
+	WrongAnswers   int    `json:"wrongAnswers"`
+	AverageSeconds int    `json:"averageSeconds"` // Of the teams that completed it
+	FastestSeconds int    `json:"fastestSeconds"`
+	FastestTeam    string `json:"fastestTeam"`
+}
+
+// gameResults is everything the export knows about a game
+type gameResults struct {
+	Game     string       `json:"game"`
+	Exported time.Time    `json:"exported"`
+	Teams    []teamResult `json:"teams"`
+	Quests   []questStats `json:"quests"`
+}
+
+// collectResults reads the results of the teams of a game from the
+// database. Start and finish times come from the game events.
+func collectResults(game *Game) gameResults {
+	results := gameResults{Game: game.Key, Exported: time.Now()}
+	teamNames := gameTeamNames(game.Key)
+
+	var quests []Quest
+	db.Preload("Definition").Where("team_name IN (?)", teamNames).Order("team_name, quest_number").Find(&quests)
+
+	var records []GameEvent
+	db.Where("team_name IN (?) AND run = ?", teamNames, currentRun).Order("time, id").Find(&records)
+
+	// First login, end of the game and quest results by team
+	started := map[string]time.Time{}
+	finished := map[string]time.Time{}
+	type questID struct {
+		team   string
+		number int
+	}
+	ended := map[questID]time.Time{}
+	wrong := map[questID]int{}
+	for _, record := range records {
+		id := questID{record.TeamName, record.QuestNumber}
+		switch {
+		case record.Type == eventLogin:
+			if _, ok := started[record.TeamName]; !ok {
+				started[record.TeamName] = record.Time
+			}
+		case record.Type == eventGameFinished:
+			finished[record.TeamName] = record.Time
+		case record.Type == eventWrongAnswer:
+			wrong[id]++
+		case record.Type == eventAnswerRejected:
+			delete(ended, id) // Open again
+		case questEndEvents[record.Type]:
+			ended[id] = record.Time
+		}
+	}
+
+	byTeam := map[string]*teamResult{}
+	teamNames = nil
+	for _, quest := range quests {
+		team, ok := byTeam[quest.TeamName]
+		if !ok {
+			team = &teamResult{Team: quest.TeamName, Started: optionalTime{started[quest.TeamName]}}
+			byTeam[quest.TeamName] = team
+			teamNames = append(teamNames, quest.TeamName)
+		}
+
+		id := questID{quest.TeamName, quest.QuestNumber}
+		result := questResult{
+			Number:       quest.QuestNumber,
+			Key:          quest.Definition.Key,
+			Result:       questResultName(quest),
+			Late:         quest.Late,
+			Started:      optionalTime{quest.StartedAt},
+			Hints:        quest.HintsUsed,
+			WrongAnswers: wrong[id],
+			Attempts:     wrong[id],
+		}
+		if result.Result != "open" {
+			result.Finished = optionalTime{ended[id]}
+		}
+		if !result.Started.IsZero() && !result.Finished.IsZero() {
+			result.Seconds = int(result.Finished.Sub(result.Started.Time).Round(time.Second).Seconds())
+		}
+		if result.Result == "completed" {
+			result.Attempts++
+		}
+		team.Quests = append(team.Quests, result)
+
+		switch result.Result {
+		case "completed":
+			team.Completed++
+		case "skipped":
+			team.Skipped++
+		case "timed_out":
+			team.TimedOut++
+		case "failed":
+			team.Failed++
+		}
+		team.Hints += result.Hints
+		team.WrongAnswers += result.WrongAnswers
+		if result.Finished.After(team.Finished.Time) {
+			team.Finished = result.Finished
+		}
+	}
+
+	for _, teamName := range teamNames {
+		team := byTeam[teamName]
+		team.Score = teamScore(teamName)
+		if end, ok := finished[teamName]; ok {
+			team.Finished = optionalTime{end}
+		}
+		if !team.Started.IsZero() && team.Finished.After(team.Started.Time) {
+			team.Seconds = int(team.Finished.Sub(team.Started.Time).Round(time.Second).Seconds())
+		}
+		results.Teams = append(results.Teams, *team)
+	}
+
+	// Best score first, the faster team first on a tie
+	sort.SliceStable(results.Teams, func(i, j int) bool {
+		a, b := results.Teams[i], results.Teams[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Seconds < b.Seconds
+	})
+
+	results.Quests = collectQuestStats(game, results.Teams)
+	return results
+}
+
+// collectQuestStats sums up the quests of the catalog of a game over the team
+// results
+func collectQuestStats(game *Game, teams []teamResult) []questStats {
+	var definitions []QuestDefinition
+	db.Where("game = ?", game.Key).Order("position").Find(&definitions)
+
+	stats := make([]questStats, len(definitions))
+	index := map[string]int{}
+	for i, definition := range definitions {
+		stats[i] = questStats{Key: definition.Key, Position: definition.Position}
+		index[definition.Key] = i
+	}
+
+	// Total and count of the completion times, for the average
+	totals := make([]int, len(stats))
+	timed := make([]int, len(stats))
+	for _, team := range teams {
+		for _, quest := range team.Quests {
+			i, ok := index[quest.Key]
+			if !ok {
+				continue
+			}
+			s := &stats[i]
+			s.Teams++
+			s.Hints += quest.Hints
+			s.WrongAnswers += quest.WrongAnswers
+			switch quest.Result {
+			case "completed":
+				s.Completed++
+				if quest.Seconds > 0 {
+					totals[i] += quest.Seconds
+					timed[i]++
+					if s.FastestTeam == "" || quest.Seconds < s.FastestSeconds {
+						s.FastestSeconds, s.FastestTeam = quest.Seconds, team.Team
+					}
+				}
+			case "skipped":
+				s.Skipped++
+			case "timed_out":
+				s.TimedOut++
+			case "failed":
+				s.Failed++
+			}
+		}
+	}
+
+	for i := range stats {
+		if timed[i] > 0 {
+			stats[i].AverageSeconds = totals[i] / timed[i]
+		}
+	}
+	return stats
+}
+
+// questResultName describes the outcome of a quest
+func questResultName(quest Quest) string {
+	switch {
+	case quest.Skipped:
+		return "skipped"
+	case quest.TimedOut:
+		return "timed_out"
+	case quest.Failed:
+		return "failed"
+	case quest.Completed:
+		return "completed"
+	}
+	return "open"
+}
+
+// optionalTime is a time that may be unknown, written as null in JSON and
+// as an empty cell in the tables
+type optionalTime struct {
+	time.Time
+}
+
+func (t optionalTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.Time)
+}
+
+// String writes the time for the tables
+func (t optionalTime) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// exportTable is a report as rows of cells. Cells are strings, ints or bools.
+type exportTable struct {
+	Name   string
+	Header []string
+	Rows   [][]interface{}
+}
+
+// tables lays the results out as the three reports
+func (results gameResults) tables() []exportTable {
+	teams := exportTable{
+		Name:   reportTeams,
+		Header: []string{"Rank", "Team", "Score", "Started", "Finished", "Seconds", "Completed", "Skipped", "TimedOut", "Failed", "Hints", "WrongAnswers"},
+	}
+	quests := exportTable{
+		Name:   reportQuests,
+		Header: []string{"Team", "Quest", "Key", "Result", "Late", "Started", "Finished", "Seconds", "Hints", "WrongAnswers", "Attempts"},
+	}
+	for i, team := range results.Teams {
+		teams.Rows = append(teams.Rows, []interface{}{
+			i + 1, team.Team, team.Score, team.Started.String(), team.Finished.String(), team.Seconds,
+			team.Completed, team.Skipped, team.TimedOut, team.Failed, team.Hints, team.WrongAnswers,
+		})
+		for _, quest := range team.Quests {
+			quests.Rows = append(quests.Rows, []interface{}{
+				team.Team, quest.Number, quest.Key, quest.Result, quest.Late, quest.Started.String(), quest.Finished.String(),
+				quest.Seconds, quest.Hints, quest.WrongAnswers, quest.Attempts,
+			})
+		}
+	}
+
+	stats := exportTable{
+		Name:   reportStats,
+		Header: []string{"Position", "Key", "Teams", "Completed", "Skipped", "TimedOut", "Failed", "Hints", "WrongAnswers", "AverageSeconds", "FastestSeconds", "FastestTeam"},
+	}
+	for _, s := range results.Quests {
+		stats.Rows = append(stats.Rows, []interface{}{
+			s.Position, s.Key, s.Teams, s.Completed, s.Skipped, s.TimedOut, s.Failed, s.Hints, s.WrongAnswers,
+			s.AverageSeconds, s.FastestSeconds, s.FastestTeam,
+		})
+	}
+	return []exportTable{teams, quests, stats}
+}
+
+// writeExport writes the results in a format. CSV writes only the report.
+func writeExport(w io.Writer, results gameResults, format, report string) error {
+	switch format {
+	case exportJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	case exportXLSX:
+		return writeXLSX(w, results.tables())
+	case exportCSV:
+		for _, table := range results.tables() {
+			if table.Name == report {
+				return writeCSVTable(w, table)
+			}
+		}
+		return fmt.Errorf("unknown report %q, use teams, quests or stats", report)
+	}
+	return fmt.Errorf("unknown format %q, use csv, json or xlsx", format)
+}
+
+// writeCSVTable writes a report as CSV
+func writeCSVTable(w io.Writer, table exportTable) error {
+	writer := csv.NewWriter(w)
+	writer.UseCRLF = true
+	writer.Write(table.Header)
+	for _, row := range table.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = fmt.Sprint(cell)
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Content types and file extensions of the export formats
+var exportTypes = map[string]string{
+	exportCSV:  "text/csv; charset=utf-8",
+	exportJSON: "application/json",
+	exportXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// handleAdminExport downloads the results of the organizer's game, e.g.
+// ?format=csv&report=quests
+func handleAdminExport(w http.ResponseWriter, r *http.Request) {
+	game := adminGame(r)
+	format := r.URL.Query().Get("format")
+	report := r.URL.Query().Get("report")
+	if format == "" {
+		format = exportXLSX
+	}
+	if report == "" {
+		report = reportTeams
+	}
+
+	contentType, ok := exportTypes[format]
+	if !ok {
+		http.Error(w, "Непознат формат", http.StatusBadRequest)
+		return
+	}
+
+	fileName := "results-" + game.Key + "." + format
+	if format == exportCSV {
+		fileName = "results-" + game.Key + "-" + report + ".csv"
+	}
+
+	// Built in memory first, so an unknown report is still a proper error
+	var buffer bytes.Buffer
+	if err := writeExport(&buffer, collectResults(game), format, report); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	w.Write(buffer.Bytes())
+}
+
+// runExport is the export command: it writes the results of a game of the
+// database of a configuration to a file or to standard output
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", exportCSV, "csv, json or xlsx")
+	report := flags.String("report", reportTeams, "report of a CSV file: teams, quests or stats")
+	out := flags.String("out", "", "file to write, standard output if empty")
+	gameKey := flags.String("game", "", "key of the game, the first game if empty")
+
+	done, err := setupCommand(flags, args)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if _, ok := exportTypes[*format]; !ok {
+		return fmt.Errorf("unknown format %q, use csv, json or xlsx", *format)
+	}
+	game, err := commandGame(*gameKey)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return writeExport(os.Stdout, collectResults(game), *format, *report)
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := writeExport(file, collectResults(game), *format, *report); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Every closed quest has a finish time, however it was closed
+func TestResultsFinishEveryClosedQuest(t *testing.T) {
+	newTestServer(t)
+	const teamName = "TEAM3"
+	start := time.Now().Add(-time.Hour).Round(time.Second)
+
+	steps := []struct {
+		key      string
+		quest    Quest
+		events   []string
+		finished bool
+	}{
+		{"export-done", Quest{Completed: true}, []string{eventComplete}, true},
+		{"export-skip", Quest{Completed: true, Skipped: true}, []string{eventSkip}, true},
+		{"export-timer", Quest{Completed: true, Skipped: true}, []string{eventTimerSkip}, true},
+		{"export-fail", Quest{Completed: true, Failed: true}, []string{eventTimerFail}, true},
+		{"export-rejected", Quest{}, []string{eventComplete, eventAnswerRejected}, false},
+	}
+	for i, step := range steps {
+		definition := QuestDefinition{Game: defaultGameKey, Key: step.key}
+		if err := db.Create(&definition).Error; err != nil {
+			t.Fatal(err)
+		}
+		quest := step.quest
+		quest.TeamName, quest.QuestNumber, quest.DefinitionID, quest.StartedAt = teamName, 100+i, definition.ID, start
+		if err := db.Create(&quest).Error; err != nil {
+			t.Fatal(err)
+		}
+		for j, eventType := range step.events {
+			saveGameEvent(event{Time: start.Add(time.Duration(j+1) * time.Minute), Type: eventType, Game: defaultGameKey, Team: teamName, Quest: quest.QuestNumber, QuestKey: step.key})
+		}
+	}
+
+	finished := map[string]bool{}
+	for _, team := range collectResults(teamGame(teamName)).Teams {
+		for _, result := range team.Quests {
+			if team.Team == teamName {
+				finished[result.Key] = !result.Finished.IsZero()
+			}
+		}
+	}
+	for _, step := range steps {
+		if got, ok := finished[step.key]; !ok || got != step.finished {
+			t.Errorf("%s: finished %v (found %v), want %v", step.key, got, ok, step.finished)
+		}
+	}
+}
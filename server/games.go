@@ -0,0 +1,379 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Game is a hunt run on the server. Every game has its own teams, quest
+// catalog, schedule, scoring rules and branding. Team names are unique across
+// all games, so the progress and the events of a team belong to one game.
+type Game struct {
+	Key       string
+	Name      string // Read with Title, see gamesMu
+	DataDir   string // Folder of the catalog, routes, media and translations
+	RouteMode string
+
+	// Read with schedule, see gamesMu
+	Start    time.Time     // Teams can't log in before the start, if set
+	End      time.Time     // Every team finishes at the end, if set
+	Duration time.Duration // Game time of a team from its first login
+
+	Skips          SkipPolicy
+	PointsPerQuest int
+	PointsPerHint  int
+	LatePenalty    time.Duration
+
+	Color string // Accent color of the player pages, e.g. #8b0000
+	Logo  string // URL of the logo on the player pages
+}
+
+// Key of the only game when there is no games file
+const defaultGameKey = "main"
+
+// Paths of the games and teams files, see useDataDir
+var (
+	gamesPath = "data/games.csv"
+	teamsPath = "data/teams.csv"
+)
+
+var (
+	// games lists the games in the order of the games file
+	games []*Game
+
+	// teamGames maps a team name to the key of its game. Like games it is
+	// only written while loading, so it is read without locking mu.
+	teamGames = map[string]string{}
+
+	// gamesMu guards the names and the schedules of the games, which the
+	// organizers can change while the server runs
+	gamesMu sync.RWMutex
+)
+
+// Columns of the games and teams files, in order
+var (
+	gamesHeader = []string{"Key", "Name", "DataDir", "RouteMode", "Start", "End", "Duration", "SkipMax", "SkipUnlockAfter", "SkipPenalty", "PointsPerQuest", "PointsPerHint", "LatePenalty", "Color", "Logo"}
+	teamsHeader = []string{"Game", "Team", "Username", "Password", "Language"}
+)
+
+// Paths of the quest data files of a game
+func (g *Game) catalogPath() string      { return filepath.Join(g.DataDir, "catalog.csv") }
+func (g *Game) routesPath() string       { return filepath.Join(g.DataDir, "routes.csv") }
+func (g *Game) mediaPath() string        { return filepath.Join(g.DataDir, "media.csv") }
+func (g *Game) translationsPath() string { return filepath.Join(g.DataDir, "translations.csv") }
+
+// schedule returns the start and the end of the game and the game time of
+// a team
+func (g *Game) schedule() (start, end time.Time, duration time.Duration) {
+	gamesMu.RLock()
+	defer gamesMu.RUnlock()
+	return g.Start, g.End, g.Duration
+}
+
+// endFor returns when the game of a team that started at start ends: after
+// the game time, or at the end of the game if that comes first
+func (g *Game) endFor(start time.Time) time.Time {
+	_, gameEnd, duration := g.schedule()
+	end := start.Add(duration)
+	if !gameEnd.IsZero() && gameEnd.Before(end) {
+		return gameEnd
+	}
+	return end
+}
+
+// defaultGame is the game of the environment settings, used when there is
+// no games file
+func defaultGame() *Game {
+	return &Game{
+		Key:            defaultGameKey,
+		DataDir:        cfg.DataDir,
+		RouteMode:      os.Getenv("ROUTE_MODE"),
+		Duration:       cfg.GameDuration,
+		Skips:          loadSkipPolicy(),
+		PointsPerQuest: pointsPerQuest,
+		PointsPerHint:  pointsPerHint,
+		LatePenalty:    loadLateAnswerPenalty(),
+	}
+}
+
+// parseGameTime reads a time of the schedule, either RFC 3339 or local time
+// as 2006-01-02 15:04
+func parseGameTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+}
+
+// loadGames reads the games file. Its columns are Key, Name, DataDir,
+// RouteMode, Start, End, Duration, SkipMax, SkipUnlockAfter, SkipPenalty,
+// PointsPerQuest, PointsPerHint, LatePenalty, Color and Logo. Empty columns
+// keep the settings of the environment.
+func loadGames(filePath string) ([]*Game, error) {
+	records, err := readCSV(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded []*Game
+	seen := map[string]bool{}
+	for i, record := range records {
+		game := defaultGame()
+		game.Key = strings.TrimSpace(record[0])
+		if game.Key == "" || seen[game.Key] {
+			return nil, fmt.Errorf("row %d: missing or duplicate game key %q", i+2, game.Key)
+		}
+		seen[game.Key] = true
+
+		game.Name = strings.TrimSpace(optionalField(record, 1))
+		if dir := strings.TrimSpace(optionalField(record, 2)); dir != "" {
+			// Relative folders start at the folder of the games file
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(filepath.Dir(filePath), dir)
+			}
+			game.DataDir = dir
+		}
+		if mode := strings.TrimSpace(optionalField(record, 3)); mode != "" {
+			game.RouteMode = mode
+		}
+		if game.Start, err = parseGameTime(optionalField(record, 4)); err != nil {
+			return nil, fmt.Errorf("game %s: start: %v", game.Key, err)
+		}
+		if game.End, err = parseGameTime(optionalField(record, 5)); err != nil {
+			return nil, fmt.Errorf("game %s: end: %v", game.Key, err)
+		}
+
+		// Numbers and durations, kept from the environment when empty
+		for _, setting := range []struct {
+			index    int
+			duration *time.Duration
+			number   *int
+		}{
+			{6, &game.Duration, nil},
+			{7, nil, &game.Skips.MaxSkips},
+			{8, &game.Skips.UnlockAfter, nil},
+			{9, nil, &game.Skips.Penalty},
+			{10, nil, &game.PointsPerQuest},
+			{11, nil, &game.PointsPerHint},
+			{12, &game.LatePenalty, nil},
+		} {
+			value := strings.TrimSpace(optionalField(record, setting.index))
+			if value == "" {
+				continue
+			}
+			if setting.duration != nil {
+				*setting.duration = parseDuration(value)
+			} else {
+				*setting.number = parseInt(value)
+			}
+		}
+
+		game.Color = strings.TrimSpace(optionalField(record, 13))
+		game.Logo = strings.TrimSpace(optionalField(record, 14))
+		loaded = append(loaded, game)
+	}
+
+	if len(loaded) == 0 {
+		return nil, fmt.Errorf("no games in %s", filePath)
+	}
+	return loaded, nil
+}
+
+// loadTeams reads the teams file, with the columns Game, Team, Username,
+// Password and Language
+func loadTeams(filePath string, known []*Game) (map[string]*Team, error) {
+	records, err := readCSV(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	gameKeys := map[string]bool{}
+	for _, game := range known {
+		gameKeys[game.Key] = true
+	}
+
+	loaded := map[string]*Team{}
+	logins := map[string]bool{}
+	for i, record := range records {
+		team := &Team{
+			Game:     strings.TrimSpace(record[0]),
+			Username: strings.TrimSpace(optionalField(record, 2)),
+			Password: optionalField(record, 3),
+			Language: strings.TrimSpace(optionalField(record, 4)),
+		}
+		teamName := strings.TrimSpace(optionalField(record, 1))
+
+		switch {
+		case !gameKeys[team.Game]:
+			return nil, fmt.Errorf("row %d: unknown game %q", i+2, team.Game)
+		case teamName == "" || loaded[teamName] != nil:
+			return nil, fmt.Errorf("row %d: missing or duplicate team name %q", i+2, teamName)
+		case team.Username == "" || logins[team.Game+"\x00"+team.Username]:
+			return nil, fmt.Errorf("row %d: missing username or the username is taken in game %s", i+2, team.Game)
+		}
+		logins[team.Game+"\x00"+team.Username] = true
+		loaded[teamName] = team
+	}
+	return loaded, nil
+}
+
+// envTeams returns the teams of the environment variables TEAM1USER,
+// TEAM1PASS, TEAM1LANG … TEAM4LANG, all in the default game
+func envTeams() map[string]*Team {
+	loaded := map[string]*Team{}
+	for _, teamName := range []string{"TEAM1", "TEAM2", "TEAM3", "TEAM4"} {
+		loaded[teamName] = &Team{
+			Username: os.Getenv(teamName + "USER"),
+			Password: os.Getenv(teamName + "PASS"),
+			Language: os.Getenv(teamName + "LANG"),
+			Game:     defaultGameKey,
+		}
+	}
+	return loaded
+}
+
+// setupGames loads the games and their teams. Without a games file the
+// server runs a single game with the teams of the environment.
+func setupGames() error {
+	loadedTeams := envTeams()
+	loadedGames := []*Game{defaultGame()}
+
+	if _, err := os.Stat(gamesPath); err == nil {
+		if loadedGames, err = loadGames(gamesPath); err != nil {
+			return fmt.Errorf("games: %v", err)
+		}
+		if loadedTeams, err = loadTeams(teamsPath, loadedGames); err != nil {
+			return fmt.Errorf("teams: %v", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	games = loadedGames
+	teams = loadedTeams
+	teamGames = map[string]string{}
+	for teamName, team := range teams {
+		teamGames[teamName] = team.Game
+	}
+	return nil
+}
+
+// findGame returns the game of a key
+func findGame(key string) (*Game, bool) {
+	for _, game := range games {
+		if game.Key == key {
+			return game, true
+		}
+	}
+	return nil, false
+}
+
+// teamGame returns the game of a team. Unknown teams, such as the preview of
+// the editor, get the first game.
+func teamGame(teamName string) *Game {
+	if game, ok := findGame(teamGames[teamName]); ok {
+		return game
+	}
+	return games[0]
+}
+
+// gameTeamNames returns the names of the teams of a game in alphabetical order
+func gameTeamNames(gameKey string) []string {
+	var teamNames []string
+	for teamName, key := range teamGames {
+		if key == gameKey {
+			teamNames = append(teamNames, teamName)
+		}
+	}
+	sort.Strings(teamNames)
+	return teamNames
+}
+
+// requestGame returns the game a login page is for: the game query or form
+// parameter, or the only game of the server
+func requestGame(r *http.Request) (*Game, bool) {
+	if game, ok := findGame(r.FormValue("game")); ok {
+		return game, true
+	}
+	if len(games) == 1 {
+		return games[0], true
+	}
+	return nil, false
+}
+
+// commandGame returns the game of the -game flag of a command, the first
+// game if it is empty
+func commandGame(key string) (*Game, error) {
+	if key == "" {
+		return games[0], nil
+	}
+	if game, ok := findGame(key); ok {
+		return game, nil
+	}
+	return nil, fmt.Errorf("unknown game %q", key)
+}
+
+// Cookie with the game the organizer works on
+const adminGameCookie = "admin_game"
+
+// adminGame returns the game chosen on the organizer pages, the first game
+// until one is chosen
+func adminGame(r *http.Request) *Game {
+	if cookie, err := r.Cookie(adminGameCookie); err == nil {
+		if game, ok := findGame(cookie.Value); ok {
+			return game
+		}
+	}
+	return games[0]
+}
+
+// gameSwitcher is the game menu of the organizer pages
+type gameSwitcher struct {
+	Games   []*Game
+	Current string
+}
+
+func newGameSwitcher(r *http.Request) gameSwitcher {
+	return gameSwitcher{Games: games, Current: adminGame(r).Key}
+}
+
+// Title returns the name of the game, or its key without one
+func (g *Game) Title() string {
+	gamesMu.RLock()
+	defer gamesMu.RUnlock()
+	if g.Name != "" {
+		return g.Name
+	}
+	return g.Key
+}
+
+// handleAdminGame switches the organizer pages to another game and reloads
+// the page the organizer came from
+func handleAdminGame(w http.ResponseWriter, r *http.Request) {
+	if game, ok := findGame(r.URL.Query().Get("key")); ok {
+		http.SetCookie(w, &http.Cookie{
+			Name:     adminGameCookie,
+			Value:    game.Key,
+			Path:     "/admin",
+			HttpOnly: true,
+		})
+	}
+
+	// Only go back to organizer pages, the team of a timeline belongs to the old game
+	back := "/admin/quests"
+	if referer, err := http.NewRequest(http.MethodGet, r.Referer(), nil); err == nil && strings.HasPrefix(referer.URL.Path, "/admin/") {
+		back = referer.URL.Path
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
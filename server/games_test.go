@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// The same username in two games belongs to two teams
+func TestLoginStaysInItsGame(t *testing.T) {
+	newTestServer(t)
+	other := addTestGame(t, "login-other")
+	addTestTeam(t, "LOGINMAIN")
+	addTestTeam(t, "LOGINOTHER")
+	moveTestTeam("LOGINOTHER", other.Key)
+	mu.Lock()
+	teams["LOGINMAIN"].Username, teams["LOGINOTHER"].Username = "same", "same"
+	mu.Unlock()
+
+	tests := []struct {
+		game, password, team string
+	}{
+		{defaultGameKey, "pass-LOGINMAIN", "LOGINMAIN"},
+		{other.Key, "pass-LOGINOTHER", "LOGINOTHER"},
+		{defaultGameKey, "pass-LOGINOTHER", ""},
+		{other.Key, "pass-LOGINMAIN", ""},
+		{"", "pass-LOGINMAIN", ""}, // Two games, so the game must be given
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		teamName, err := loginTeam(r, test.game, "same", test.password)
+		if teamName != test.team || (err == nil) != (test.team != "") {
+			t.Errorf("login to %q with %s = %q, %v, want %q", test.game, test.password, teamName, err, test.team)
+		}
+	}
+}
+
+// The organizer pages show and change only the game the organizer works on
+func TestAdminPagesStayInTheirGame(t *testing.T) {
+	handler := newTestServer(t)
+	other := addTestGame(t, "pages-other")
+	addTestTeam(t, "PAGESMAIN")
+	addTestTeam(t, "PAGESOTHER")
+	moveTestTeam("PAGESOTHER", other.Key)
+
+	mainQuest := addTestQuest(t, "PAGESMAIN", 1, QuestDefinition{Key: "quest"})
+	otherQuest := addTestQuest(t, "PAGESOTHER", 1, QuestDefinition{Key: "quest"})
+	for _, quest := range []Quest{mainQuest, otherQuest} {
+		e := questEvent(nil, eventWrongAnswer, quest)
+		e.Details = map[string]string{"answer": "answer-of-" + strings.ToLower(quest.TeamName)}
+		logEvent(e)
+	}
+	if mainQuest.Definition.Game != defaultGameKey || otherQuest.Definition.Game != other.Key {
+		t.Fatalf("definitions in games %s and %s", mainQuest.Definition.Game, otherQuest.Definition.Game)
+	}
+
+	pages := []struct {
+		path, shown, hidden string
+	}{
+		{"/admin/quests", "pagesother-quest-1", "pagesmain-quest-1"},
+		{"/admin/review", "answer-of-pagesother", "answer-of-pagesmain"},
+		{"/admin/timeline?team=PAGESOTHER", "answer-of-pagesother", "answer-of-pagesmain"},
+		{"/admin/timeline?team=PAGESMAIN", "", "answer-of-pagesmain"}, // A team of another game
+		{"/admin/export?format=json", "PAGESOTHER", "PAGESMAIN"},
+		{"/admin/export?format=csv&report=quests", "pagesother-quest-1", "pagesmain-quest-1"},
+	}
+	for _, page := range pages {
+		w := adminGet(handler, page.path, other.Key)
+		body := w.Body.String()
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d", page.path, w.Code)
+			continue
+		}
+		if !strings.Contains(body, page.shown) {
+			t.Errorf("%s doesn't show %s", page.path, page.shown)
+		}
+		if strings.Contains(body, page.hidden) {
+			t.Errorf("%s shows %s of the other game", page.path, page.hidden)
+		}
+	}
+
+	// A judge of one game can't accept the answers of another
+	review := func(quest Quest) int {
+		form := url.Values{"quest_id": {fmt.Sprint(quest.ID)}, "action": {"accept"}}
+		r := httptest.NewRequest(http.MethodPost, "/admin/review/answer", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth(testAdminUser, testAdminPass)
+		r.AddCookie(&http.Cookie{Name: adminGameCookie, Value: other.Key})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := review(mainQuest); code != http.StatusNotFound {
+		t.Errorf("accepting an answer of another game = %d, want 404", code)
+	}
+	var stored Quest
+	db.First(&stored, mainQuest.ID)
+	if stored.Completed {
+		t.Error("the quest of another game was accepted")
+	}
+	if code := review(otherQuest); code != http.StatusSeeOther {
+		t.Errorf("accepting an answer of the game = %d, want 303", code)
+	}
+
+	// Teams play by the rules of their own game
+	other.PointsPerQuest = 7
+	if game := teamGame("PAGESOTHER"); game != other {
+		t.Errorf("team plays in %s, want %s", game.Key, other.Key)
+	}
+	if game := teamGame("PAGESMAIN"); game.Key != defaultGameKey || game.PointsPerQuest == 7 {
+		t.Errorf("team of the main game plays by %+v", game)
+	}
+}
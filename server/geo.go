@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Quest types, set per quest in the QuestType column of the catalog
+const (
+	questTypeText       = "text"       // The team answers with text and/or a photo
+	questTypeGeo        = "geo"        // The team checks in with its GPS location first
+	questTypeCheckpoint = "checkpoint" // The team scans or types the code printed on site first
+)
+
+// Default radius of a geofence and the worst accepted GPS accuracy, in meters
+const (
+	defaultGeoRadius   = 50
+	defaultGeoAccuracy = 100
+)
+
+// LocationFix is a GPS position sent by a team to check in at a quest
+type LocationFix struct {
+	gorm.Model
+	TeamName  string
+	QuestID   uint
+	Latitude  float64
+	Longitude float64
+	Accuracy  float64
+	Distance  float64
+	Inside    bool
+}
+
+// geoMaxAccuracy is the worst GPS accuracy accepted for a check-in
+var geoMaxAccuracy float64
+
+// loadGeoMaxAccuracy reads the GEO_MAX_ACCURACY environment variable
+func loadGeoMaxAccuracy() float64 {
+	if accuracy, err := strconv.ParseFloat(os.Getenv("GEO_MAX_ACCURACY"), 64); err == nil && accuracy > 0 {
+		return accuracy
+	}
+	return defaultGeoAccuracy
+}
+
+// parseFloat is a helper function to parse a float, returning 0 on errors
+func parseFloat(value string) float64 {
+	v, _ := strconv.ParseFloat(value, 64)
+	return v
+}
+
+// distanceMeters returns the great-circle distance between two points
+func distanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000
+
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// questType returns the type of the quest, defaulting to text
+func (quest Quest) questType() string {
+	if quest.Definition.QuestType == "" {
+		return questTypeText
+	}
+	return quest.Definition.QuestType
+}
+
+// geoRadius returns the radius of the quest's geofence
+func (quest Quest) geoRadius() float64 {
+	if quest.Definition.Radius > 0 {
+		return quest.Definition.Radius
+	}
+	return defaultGeoRadius
+}
+
+// needsCheckIn reports whether the team has to check in before answering
+func (quest Quest) needsCheckIn() bool {
+	switch quest.questType() {
+	case questTypeGeo, questTypeCheckpoint:
+		return !quest.CheckedIn
+	}
+	return false
+}
+
+// checkIn marks the team as present at the quest. A geo quest without
+// answers is completed by the check-in alone. Only the check-in columns are
+// written, so a stale copy doesn't reopen a quest its timer closed.
+func checkIn(r *http.Request, quest *Quest, reason string) {
+	quest.CheckedIn = true
+	columns := map[string]interface{}{"checked_in": true}
+	if quest.Definition.CorrectAnswers == "" && !quest.Definition.FileRequired {
+		quest.Completed = true
+		columns["completed"] = true
+	}
+	db.Model(&Quest{}).Where("id = ? AND completed = ?", quest.ID, false).Updates(columns)
+	checkedIn := questEvent(r, eventCheckIn, *quest)
+	checkedIn.Details = map[string]string{"reason": reason}
+	logEvent(checkedIn)
+}
+
+// handleCheckIn validates a GPS position sent by the team's browser
+func handleCheckIn(w http.ResponseWriter, r *http.Request) {
+	lang := requestLanguage(r, "")
+	if r.Method != http.MethodPost {
+		http.Error(w, translate(lang, "invalid_method"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	cookie, err := r.Cookie("logged_in_team")
+	if err != nil {
+		http.Error(w, translate(lang, "unauthorized"), http.StatusUnauthorized)
+		return
+	}
+	teamName := cookie.Value
+	lang = requestLanguage(r, teamName)
+
+	if err := stillPlaying(teamName); err != nil {
+		http.Error(w, translate(lang, "game_finished"), http.StatusConflict)
+		return
+	}
+
+	var quest Quest
+	if err := db.Preload("Definition").Where("id = ? AND team_name = ?", r.FormValue("quest_id"), teamName).First(&quest).Error; err != nil {
+		log.Printf("Quest not found: %v", err)
+		http.Error(w, translate(lang, "quest_not_found"), http.StatusNotFound)
+		return
+	}
+
+	if quest.questType() != questTypeGeo || quest.Completed || !isQuestOpen(teamName, quest) {
+		http.Error(w, translate(lang, "quest_locked"), http.StatusForbidden)
+		return
+	}
+
+	fix := LocationFix{
+		TeamName:  teamName,
+		QuestID:   quest.ID,
+		Latitude:  parseFloat(r.FormValue("latitude")),
+		Longitude: parseFloat(r.FormValue("longitude")),
+		Accuracy:  parseFloat(r.FormValue("accuracy")),
+	}
+	fix.Distance = distanceMeters(fix.Latitude, fix.Longitude, quest.Definition.Latitude, quest.Definition.Longitude)
+
+	var message string
+	switch {
+	case fix.Accuracy <= 0 || fix.Accuracy > geoMaxAccuracy:
+		message = translate(lang, "checkin_inaccurate")
+	case fix.Distance > quest.geoRadius():
+		message = translate(lang, "checkin_too_far", fix.Distance)
+	default:
+		fix.Inside = true
+		message = translate(lang, "checkin_ok")
+	}
+	db.Create(&fix)
+
+	if fix.Inside {
+		checkIn(r, &quest, "GPS")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  fix.Inside,
+		"distance": math.Round(fix.Distance),
+		"message":  message,
+	})
+}
+
+// handleAdminCheckIns lists the latest check-in attempts for the organizers
+func handleAdminCheckIns(w http.ResponseWriter, r *http.Request) {
+	var fixes []LocationFix
+	db.Where("team_name IN (?)", gameTeamNames(adminGame(r).Key)).Order("created_at desc").Limit(100).Find(&fixes)
+
+	// Quest numbers make the list readable for the organizers
+	questNumbers := map[uint]int{}
+	var quests []Quest
+	db.Where("id IN (?)", fixQuestIDs(fixes)).Find(&quests)
+	for _, quest := range quests {
+		questNumbers[quest.ID] = quest.QuestNumber
+	}
+
+	type row struct {
+		LocationFix
+		QuestNumber int
+		Time        string
+	}
+	data := struct {
+		GameSwitcher gameSwitcher
+		Fixes        []row
+	}{
+		GameSwitcher: newGameSwitcher(r),
+	}
+	for _, fix := range fixes {
+		data.Fixes = append(data.Fixes, row{
+			LocationFix: fix,
+			QuestNumber: questNumbers[fix.QuestID],
+			Time:        fix.CreatedAt.Format(time.Kitchen),
+		})
+	}
+
+	if err := templates.ExecuteTemplate(w, "admin_checkins.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// fixQuestIDs returns the quest IDs of the location fixes
+func fixQuestIDs(fixes []LocationFix) []uint {
+	ids := []uint{0}
+	for _, fix := range fixes {
+		ids = append(ids, fix.QuestID)
+	}
+	return ids
+}
+
+// handleAdminCheckInOverride checks a team in at a quest without GPS
+func handleAdminCheckInOverride(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/checkins", http.StatusSeeOther)
+		return
+	}
+
+	var quest Quest
+	if err := db.Preload("Definition").Where("id = ? AND team_name IN (?)", r.FormValue("quest_id"), gameTeamNames(adminGame(r).Key)).First(&quest).Error; err != nil {
+		http.Error(w, "Задачата не е намерена", http.StatusNotFound)
+		return
+	}
+
+	if !quest.Completed {
+		checkIn(r, &quest, "admin override")
+	}
+	http.Redirect(w, r, "/admin/checkins", http.StatusSeeOther)
+}
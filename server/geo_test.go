@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Center of the geofence of the tests
+const (
+	testLatitude  = 42.6977
+	testLongitude = 23.3219
+)
+
+func TestDistanceMeters(t *testing.T) {
+	// A thousandth of a degree of latitude is about 111 meters
+	if d := distanceMeters(testLatitude, testLongitude, testLatitude+0.001, testLongitude); math.Abs(d-111.2) > 0.5 {
+		t.Errorf("distance %.1f, want about 111.2", d)
+	}
+	if d := distanceMeters(testLatitude, testLongitude, testLatitude, testLongitude); d != 0 {
+		t.Errorf("distance to the same point %v", d)
+	}
+}
+
+// postCheckIn sends a GPS position of a team for a quest
+func postCheckIn(handler http.Handler, teamName string, quest Quest, latitude, longitude, accuracy float64) *httptest.ResponseRecorder {
+	form := url.Values{
+		"quest_id":  {fmt.Sprint(quest.ID)},
+		"latitude":  {fmt.Sprint(latitude)},
+		"longitude": {fmt.Sprint(longitude)},
+		"accuracy":  {fmt.Sprint(accuracy)},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/checkin", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: "logged_in_team", Value: teamName})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestGeoCheckIn(t *testing.T) {
+	handler := newTestServer(t)
+	const teamName = "GEO"
+	addTestTeam(t, teamName)
+	oldAccuracy := geoMaxAccuracy
+	geoMaxAccuracy = 100
+	t.Cleanup(func() { geoMaxAccuracy = oldAccuracy })
+
+	quest := addTestQuest(t, teamName, 1, QuestDefinition{
+		Key: "geo", QuestType: questTypeGeo, CorrectAnswers: "x",
+		Latitude: testLatitude, Longitude: testLongitude, Radius: 50,
+	})
+
+	for _, tc := range []struct {
+		name               string
+		latitude, accuracy float64
+		success            bool
+	}{
+		{"no accuracy", testLatitude, 0, false},
+		{"inaccurate", testLatitude, 150, false},
+		{"outside the radius", testLatitude + 0.001, 10, false},
+		{"inside the radius", testLatitude + 0.0004, 10, true},
+	} {
+		w := postCheckIn(handler, teamName, quest, tc.latitude, testLongitude, tc.accuracy)
+		var result struct{ Success bool }
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", tc.name, w.Code, w.Body.String())
+		}
+		if result.Success != tc.success {
+			t.Errorf("%s: success %v, want %v", tc.name, result.Success, tc.success)
+		}
+	}
+
+	var fixes []LocationFix
+	db.Where("quest_id = ?", quest.ID).Order("id").Find(&fixes)
+	if len(fixes) != 4 || fixes[2].Inside || !fixes[3].Inside || math.Abs(fixes[2].Distance-111.2) > 0.5 {
+		t.Errorf("location fixes %+v", fixes)
+	}
+	var stored Quest
+	db.First(&stored, quest.ID)
+	if !stored.CheckedIn || stored.Completed {
+		t.Errorf("quest with answers after the check-in: %+v, want checked in and open", stored)
+	}
+
+	// Only geo quests take check-ins
+	text := addTestQuest(t, teamName, 2, QuestDefinition{Key: "text", CorrectAnswers: "x"})
+	if w := postCheckIn(handler, teamName, text, testLatitude, testLongitude, 10); w.Code != http.StatusForbidden {
+		t.Errorf("check-in at a text quest: %d, want 403", w.Code)
+	}
+}
+
+func TestGeoCheckInAfterTheGame(t *testing.T) {
+	handler := newTestServer(t)
+	const teamName = "GEOFINISHED"
+	addTestTeam(t, teamName)
+	quest := addTestQuest(t, teamName, 1, QuestDefinition{Key: "geo", QuestType: questTypeGeo, Latitude: testLatitude, Longitude: testLongitude})
+
+	mu.Lock()
+	teams[teamName].GameFinished = true
+	mu.Unlock()
+	if w := postCheckIn(handler, teamName, quest, testLatitude, testLongitude, 10); w.Code != http.StatusConflict {
+		t.Errorf("check-in after the game: %d, want 409", w.Code)
+	}
+	var stored Quest
+	db.First(&stored, quest.ID)
+	if stored.CheckedIn || stored.Completed {
+		t.Error("the quest was checked in after the game")
+	}
+}
+
+func TestCheckInOverride(t *testing.T) {
+	handler := newTestServer(t)
+	const teamName = "GEOOVERRIDE"
+	addTestTeam(t, teamName)
+	withAnswer := addTestQuest(t, teamName, 1, QuestDefinition{Key: "answer", QuestType: questTypeGeo, CorrectAnswers: "x"})
+	byPresence := addTestQuest(t, teamName, 2, QuestDefinition{Key: "presence", QuestType: questTypeGeo})
+
+	for _, quest := range []Quest{withAnswer, byPresence} {
+		w := adminPost(handler, "/admin/checkin-override", "", url.Values{"quest_id": {fmt.Sprint(quest.ID)}})
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("override: %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	var stored [2]Quest
+	db.First(&stored[0], withAnswer.ID)
+	db.First(&stored[1], byPresence.ID)
+	if !stored[0].CheckedIn || stored[0].Completed {
+		t.Errorf("quest with an answer: %+v, want checked in and open", stored[0])
+	}
+	if !stored[1].CheckedIn || !stored[1].Completed {
+		t.Errorf("quest without an answer: %+v, want completed by the check-in", stored[1])
+	}
+
+	var logged int
+	db.Model(&GameEvent{}).Where("type = ? AND team_name = ? AND details LIKE ?", eventCheckIn, teamName, "%admin override%").Count(&logged)
+	if logged != 2 {
+		t.Errorf("%d check-ins logged as overrides, want 2", logged)
+	}
+
+	if w := adminPost(handler, "/admin/checkin-override", "", url.Values{"quest_id": {"999999"}}); w.Code != http.StatusNotFound {
+		t.Errorf("override of an unknown quest: %d, want 404", w.Code)
+	}
+}
@@ -3,8 +3,11 @@ module github.com/yasenOfficial/TreasureHuntWebsite
 go 1.22.6
 
 require (
-	github.com/jinzhu/gorm v1.9.16 // indirect
+	github.com/jinzhu/gorm v1.9.16
+	github.com/joho/godotenv v1.5.1
+)
+
+require (
 	github.com/jinzhu/inflection v1.0.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
 	github.com/mattn/go-sqlite3 v1.14.22 // indirect
 )
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Quest prerequisites are set in the Prerequisites column of the catalog
+// and refer to other quests by their key:
+//
+//	""          the quest follows the previous quest of the team's route,
+//	            passing over locked quests such as untaken branches
+//	"-"         the quest is open from the start
+//	"3"         quest 3 must be finished (answered, skipped, timed out or failed)
+//	"3=answer"  quest 3 must be answered with the given answer
+//	"!3"        quest 3 must not be finished, used for alternate routes
+//	"2|3"       either quest 2 or quest 3 must be finished
+//	"2;3"       both quest 2 and quest 3 must be finished
+const noPrerequisites = "-"
+
+// normalizeAnswer prepares an answer for comparison
+func normalizeAnswer(answer string) string {
+	return strings.TrimSpace(strings.ToLower(answer))
+}
+
+// conditionMet reports whether a single prerequisite condition holds
+func conditionMet(condition string, byKey map[string]Quest) bool {
+	condition = strings.TrimSpace(condition)
+
+	negate := strings.HasPrefix(condition, "!")
+	condition = strings.TrimPrefix(condition, "!")
+
+	key, answer, byAnswer := strings.Cut(condition, "=")
+
+	quest, ok := byKey[strings.TrimSpace(key)]
+	met := ok && quest.Completed
+	if met && byAnswer {
+		met = quest.solved() && normalizeAnswer(quest.Answer) == normalizeAnswer(answer)
+	}
+
+	if negate {
+		return !met
+	}
+	return met
+}
+
+// prerequisitesMet reports whether a quest is unlocked by the team's other
+// quests. previous is the nearest quest before it in the team's route that
+// the team reached, if any.
+func prerequisitesMet(quest Quest, previous *Quest, byKey map[string]Quest) bool {
+	prerequisites := strings.TrimSpace(quest.Definition.Prerequisites)
+
+	if prerequisites == noPrerequisites {
+		return true
+	}
+
+	// Without prerequisites the quest follows the previous quest of the route
+	if prerequisites == "" {
+		return previous == nil || previous.Completed
+	}
+
+	for _, group := range strings.Split(prerequisites, ";") {
+		groupMet := false
+		for _, condition := range strings.Split(group, "|") {
+			if conditionMet(condition, byKey) {
+				groupMet = true
+				break
+			}
+		}
+		if !groupMet {
+			return false
+		}
+	}
+	return true
+}
+
+// openQuests returns the team's unfinished quests whose prerequisites are met
+func openQuests(teamName string) []Quest {
+	var quests []Quest
+	db.Preload("Definition").Where("team_name = ?", teamName).Order("quest_number asc").Find(&quests)
+
+	byKey := make(map[string]Quest, len(quests))
+	for _, quest := range quests {
+		byKey[quest.Definition.Key] = quest
+	}
+
+	// Locked quests, such as a branch the team didn't take or a bonus quest,
+	// aren't the previous quest of the ones after them, or these would never
+	// open
+	var open []Quest
+	var previous *Quest
+	for i, quest := range quests {
+		reached := quest.Completed || prerequisitesMet(quest, previous, byKey)
+		if !quest.Completed && reached {
+			open = append(open, quest)
+		}
+		if reached {
+			previous = &quests[i]
+		}
+	}
+	return open
+}
+
+// currentQuest returns the quest the team is working on and all quests open
+// to it. The team's chosen quest wins, otherwise the lowest open quest number.
+func currentQuest(teamName string) (Quest, []Quest, bool) {
+	open := openQuests(teamName)
+	if len(open) == 0 {
+		return Quest{}, nil, false
+	}
+
+	mu.Lock()
+	var chosen uint
+	if team, ok := teams[teamName]; ok {
+		chosen = team.ChosenQuestID
+	}
+	mu.Unlock()
+
+	for _, quest := range open {
+		if quest.ID == chosen {
+			return quest, open, true
+		}
+	}
+	return open[0], open, true
+}
+
+// isQuestOpen reports whether the team may currently work on the quest
+func isQuestOpen(teamName string, quest Quest) bool {
+	for _, open := range openQuests(teamName) {
+		if open.ID == quest.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleChooseQuest switches the team to another of its open quests
+func handleChooseQuest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	cookie, err := r.Cookie("logged_in_team")
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	teamName := cookie.Value
+
+	if quest, err := teamQuest(teamName, r.FormValue("quest_id")); err == nil {
+		chooseQuest(r, teamName, quest)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s", teamName), http.StatusSeeOther)
+}
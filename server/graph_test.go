@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+// A quest that follows a locked bonus quest opens once the quest before the
+// bonus is finished
+func TestOpenQuestsPassOverLockedQuests(t *testing.T) {
+	newTestServer(t)
+	const teamName = "TEAM2"
+
+	route := []struct {
+		key           string
+		prerequisites string
+		completed     bool
+	}{
+		{"graph-start", "-", true},
+		{"graph-bonus", "graph-start=bonus", false}, // Locked, the answer wasn't "bonus"
+		{"graph-next", "", false},
+		{"graph-last", "", false},
+	}
+	for i, step := range route {
+		definition := QuestDefinition{Game: defaultGameKey, Key: step.key, Prerequisites: step.prerequisites}
+		if err := db.Create(&definition).Error; err != nil {
+			t.Fatal(err)
+		}
+		quest := Quest{TeamName: teamName, QuestNumber: i + 1, DefinitionID: definition.ID, Completed: step.completed, Answer: "main"}
+		if err := db.Create(&quest).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	open := openQuests(teamName)
+	if len(open) != 1 || open[0].Definition.Key != "graph-next" {
+		var keys []string
+		for _, quest := range open {
+			keys = append(keys, quest.Definition.Key)
+		}
+		t.Fatalf("open quests %v, want [graph-next]", keys)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Path of the message catalog. Its first column is the message key and
+// every further column is a language, named by its code in the header.
+var messagesPath = "data/messages.csv"
+
+// Cookie with the language chosen in the browser
+const languageCookie = "lang"
+
+var (
+	// messages maps a language to its messages by key
+	messages map[string]map[string]string
+
+	// languages lists the languages of the message catalog in column order
+	languages []string
+
+	// defaultLanguage is used for missing messages and unknown languages
+	defaultLanguage string
+)
+
+// loadMessages reads the message catalog
+func loadMessages(filePath string) (map[string]map[string]string, []string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // Messages missing in a language may be left out
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 || len(records[0]) < 2 {
+		return nil, nil, fmt.Errorf("%s has no languages", filePath)
+	}
+
+	var codes []string
+	catalog := map[string]map[string]string{}
+	for _, code := range records[0][1:] {
+		code = strings.ToLower(strings.TrimSpace(code))
+		codes = append(codes, code)
+		catalog[code] = map[string]string{}
+	}
+
+	for _, record := range records[1:] {
+		key := strings.TrimSpace(record[0])
+		if key == "" {
+			continue
+		}
+		for i, code := range codes {
+			if text := optionalField(record, i+1); text != "" {
+				catalog[code][key] = text
+			}
+		}
+	}
+	return catalog, codes, nil
+}
+
+// loadDefaultLanguage reads the DEFAULT_LANGUAGE environment variable. The
+// first language of the message catalog is the default otherwise.
+func loadDefaultLanguage() string {
+	if language := strings.ToLower(strings.TrimSpace(os.Getenv("DEFAULT_LANGUAGE"))); isLanguage(language) {
+		return language
+	}
+	return languages[0]
+}
+
+// isLanguage reports whether the message catalog has the language
+func isLanguage(language string) bool {
+	_, ok := messages[language]
+	return ok
+}
+
+// translate returns a message in the language, falling back to the default
+// language and then to the key. Arguments are formatted into the message.
+func translate(language, key string, args ...interface{}) string {
+	text, ok := messages[language][key]
+	if !ok {
+		text, ok = messages[defaultLanguage][key]
+	}
+	if !ok {
+		text = key
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// requestLanguage picks the language of a page: the lang query parameter,
+// the language chosen in the browser, the language of the team, the
+// languages of the browser, and finally the default language
+func requestLanguage(r *http.Request, teamName string) string {
+	if language := r.URL.Query().Get("lang"); isLanguage(language) {
+		return language
+	}
+	if cookie, err := r.Cookie(languageCookie); err == nil && isLanguage(cookie.Value) {
+		return cookie.Value
+	}
+
+	mu.Lock()
+	var language string
+	if team, ok := teams[teamName]; ok {
+		language = team.Language
+	}
+	mu.Unlock()
+	if isLanguage(language) {
+		return language
+	}
+
+	// Accept-Language lists the languages in order of preference, e.g. "en-GB,en;q=0.9"
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		base, _, _ := strings.Cut(tag, "-")
+		if language := strings.ToLower(base); isLanguage(language) {
+			return language
+		}
+	}
+	return defaultLanguage
+}
+
+// languageOption is a language offered in the language switcher
+type languageOption struct {
+	Code string
+	Name string
+}
+
+// pageText is the language of a player page with its messages. T falls back
+// to the default language for messages that aren't translated.
+type pageText struct {
+	Lang      string
+	Languages []languageOption
+	T         map[string]string
+	Game      *Game // Branding of the page, if it belongs to a game
+}
+
+// newPageText collects the messages of a language for the templates
+func newPageText(language string) pageText {
+	text := pageText{Lang: language, T: map[string]string{}}
+	for key, message := range messages[defaultLanguage] {
+		text.T[key] = message
+	}
+	for key, message := range messages[language] {
+		text.T[key] = message
+	}
+
+	for _, code := range languages {
+		text.Languages = append(text.Languages, languageOption{Code: code, Name: translate(code, "language_name")})
+	}
+	return text
+}
+
+// newGamePageText collects the messages of a language for a page of a game
+func newGamePageText(language string, game *Game) pageText {
+	text := newPageText(language)
+	text.Game = game
+	return text
+}
+
+// Query parameters that show a message once, dropped when the page is
+// reloaded in another language
+var messageParams = []string{"success", "skipped", "skipError", "expired", "checkin", "checkpoint"}
+
+// handleLanguage remembers the language chosen in the browser and reloads
+// the page the team came from
+func handleLanguage(w http.ResponseWriter, r *http.Request) {
+	if language := r.URL.Query().Get("lang"); isLanguage(language) {
+		http.SetCookie(w, &http.Cookie{
+			Name:   languageCookie,
+			Value:  language,
+			Path:   "/",
+			MaxAge: 365 * 24 * 60 * 60,
+		})
+	}
+
+	next := "/"
+	if referer, err := url.Parse(r.Referer()); err == nil && referer.Host == r.Host && referer.Path != "" {
+		query := referer.Query()
+		for _, param := range append(messageParams, "lang") {
+			query.Del(param)
+		}
+		referer.RawQuery = query.Encode()
+		next = referer.RequestURI()
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useTestMessages replaces the message catalog with the given CSV until the
+// test ends
+func useTestMessages(t *testing.T, catalog, defaultCode string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "messages.csv")
+	if err := os.WriteFile(path, []byte(catalog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, codes, err := loadMessages(path)
+	if err != nil {
+		t.Fatalf("loadMessages: %v", err)
+	}
+	oldMessages, oldLanguages, oldDefault := messages, languages, defaultLanguage
+	messages, languages, defaultLanguage = loaded, codes, defaultCode
+	t.Cleanup(func() {
+		messages, languages, defaultLanguage = oldMessages, oldLanguages, oldDefault
+	})
+}
+
+const testMessages = "Key,BG,en,de\r\n" +
+	"greeting,Здравей,Hello,Hallo\r\n" +
+	"only_bg,Само български,,\r\n" +
+	"count,%d задачи,%d quests\r\n" +
+	",ignored,ignored,ignored\r\n" +
+	"language_name,Български,English,Deutsch\r\n"
+
+func TestLoadMessages(t *testing.T) {
+	useTestMessages(t, testMessages, "bg")
+
+	if want := []string{"bg", "en", "de"}; len(languages) != len(want) || languages[0] != want[0] || languages[2] != want[2] {
+		t.Errorf("languages = %v, want %v", languages, want)
+	}
+	if _, ok := messages["en"]["only_bg"]; ok {
+		t.Error("an empty cell is loaded as a message")
+	}
+	if _, ok := messages["de"]["count"]; ok {
+		t.Error("a short row is loaded for the missing columns")
+	}
+	if _, ok := messages["bg"][""]; ok {
+		t.Error("a row without a key is loaded")
+	}
+
+	path := filepath.Join(t.TempDir(), "messages.csv")
+	os.WriteFile(path, []byte("Key\r\ngreeting\r\n"), 0o644)
+	if _, _, err := loadMessages(path); err == nil {
+		t.Error("a catalog without languages is accepted")
+	}
+}
+
+func TestTranslateFallback(t *testing.T) {
+	useTestMessages(t, testMessages, "bg")
+
+	tests := []struct {
+		language, key string
+		args          []interface{}
+		want          string
+	}{
+		{"en", "greeting", nil, "Hello"},
+		{"de", "greeting", nil, "Hallo"},
+		{"en", "only_bg", nil, "Само български"},  // Default language
+		{"fr", "greeting", nil, "Здравей"},        // Unknown language
+		{"en", "missing_key", nil, "missing_key"}, // The key itself
+		{"en", "count", []interface{}{3}, "3 quests"},
+		{"de", "count", []interface{}{3}, "3 задачи"},
+	}
+	for _, test := range tests {
+		if got := translate(test.language, test.key, test.args...); got != test.want {
+			t.Errorf("translate(%q, %q) = %q, want %q", test.language, test.key, got, test.want)
+		}
+	}
+
+	// Pages get every message, in the default language where it is missing
+	text := newPageText("en")
+	if text.T["greeting"] != "Hello" || text.T["only_bg"] != "Само български" {
+		t.Errorf("page text = %v", text.T)
+	}
+	if len(text.Languages) != 3 || text.Languages[2].Name != "Deutsch" {
+		t.Errorf("language options = %v", text.Languages)
+	}
+}
+
+func TestLoadDefaultLanguage(t *testing.T) {
+	useTestMessages(t, testMessages, "bg")
+
+	tests := []struct{ env, want string }{
+		{"", "bg"},
+		{"EN", "en"},
+		{" de ", "de"},
+		{"fr", "bg"}, // Not in the catalog, the first column is the default
+	}
+	for _, test := range tests {
+		t.Setenv("DEFAULT_LANGUAGE", test.env)
+		if got := loadDefaultLanguage(); got != test.want {
+			t.Errorf("DEFAULT_LANGUAGE=%q: got %q, want %q", test.env, got, test.want)
+		}
+	}
+}
+
+func TestRequestLanguage(t *testing.T) {
+	newTestServer(t)
+	useTestMessages(t, testMessages, "en")
+	addTestTeam(t, "LANGTEAM")
+	mu.Lock()
+	teams["LANGTEAM"].Language = "de"
+	mu.Unlock()
+
+	tests := []struct {
+		name, team, query, cookie, accept string
+		want                              string
+	}{
+		{"query first", "LANGTEAM", "bg", "en", "en", "bg"},
+		{"unknown query", "LANGTEAM", "fr", "bg", "", "bg"},
+		{"cookie", "LANGTEAM", "", "bg", "en", "bg"},
+		{"team language", "LANGTEAM", "", "", "bg", "de"},
+		{"browser", "", "", "", "fr-FR,bg;q=0.8", "bg"},
+		{"browser region", "", "", "", "de-AT", "de"},
+		{"default", "", "", "", "fr", "en"},
+		{"unknown team", "NOSUCHTEAM", "", "", "", "en"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/?lang="+test.query, nil)
+		if test.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: languageCookie, Value: test.cookie})
+		}
+		r.Header.Set("Accept-Language", test.accept)
+		if got := requestLanguage(r, test.team); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestQuestTextsFallback(t *testing.T) {
+	newTestServer(t)
+	useTestMessages(t, testMessages, "bg")
+	addTestTeam(t, "TEXTTEAM")
+
+	quest := addTestQuest(t, "TEXTTEAM", 1, QuestDefinition{Key: "text", Text: "Текст", Hint: "Подсказка"})
+	plain := addTestQuest(t, "TEXTTEAM", 2, QuestDefinition{Key: "plain", Text: "Без подсказка"})
+	applyTranslationsForm(quest.DefinitionID, []string{"EN", "de", "bg"}, []string{"Text", "", ""}, []string{"", "Tipp", "Друга"})
+	applyTranslationsForm(plain.DefinitionID, []string{"en"}, []string{""}, []string{"Hint"})
+	t.Cleanup(func() {
+		db.Unscoped().Where("definition_id IN (?)", []uint{quest.DefinitionID, plain.DefinitionID}).Delete(&QuestTranslation{})
+	})
+
+	tests := []struct {
+		definition           QuestDefinition
+		language, text, hint string
+	}{
+		{quest.Definition, "en", "Text", "Подсказка"}, // Hint not translated
+		{quest.Definition, "de", "Текст", "Tipp"},     // Text not translated
+		{quest.Definition, "bg", "Текст", "Подсказка"},
+		{quest.Definition, "fr", "Текст", "Подсказка"},
+		{plain.Definition, "en", "Без подсказка", ""}, // No hint to translate
+	}
+	for _, test := range tests {
+		text, hint := questTexts(test.definition, test.language)
+		if text != test.text || hint != test.hint {
+			t.Errorf("%s in %s = %q, %q, want %q, %q", test.definition.Key, test.language, text, hint, test.text, test.hint)
+		}
+	}
+
+	// The default language is the catalog itself, a translation of it is kept but not used
+	var stored []QuestTranslation
+	db.Where("definition_id = ?", quest.DefinitionID).Order("language").Find(&stored)
+	if len(stored) != 3 || stored[2].Language != "en" || stored[2].Text != "Text" {
+		t.Errorf("stored translations = %+v", stored)
+	}
+}
+
+func TestLoadQuestTranslations(t *testing.T) {
+	if translations, err := loadQuestTranslations(filepath.Join(t.TempDir(), "missing.csv")); err != nil || translations != nil {
+		t.Errorf("missing file = %v, %v, want nothing", translations, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "translations.csv")
+	os.WriteFile(path, []byte("Key,Language,Text,Hint\r\nq1,EN,Line\\nnext\r\nq1,de,Text,Tipp\r\n,en,no key,\r\nq2,,no language,\r\n"), 0o644)
+	translations, err := loadQuestTranslations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(translations) != 1 || len(translations["q1"]) != 2 {
+		t.Fatalf("translations = %+v", translations)
+	}
+	if first := translations["q1"][0]; first.Language != "en" || first.Text != "Line\nnext" || first.Hint != "" {
+		t.Errorf("first translation = %+v", first)
+	}
+}
@@ -1,22 +1,21 @@
 package main
 
 import (
-	"encoding/csv"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
-	"github.com/joho/godotenv"
 )
 
 type Team struct {
@@ -25,33 +24,39 @@ type Team struct {
 	Stopwatch    time.Time
 	StopwatchOn  bool
 	GameFinished bool
+	Language     string // Language of the team's pages, unless chosen in the browser
+	Game         string // Key of the team's game
+
+	ChosenQuestID uint
 }
 
+// Quest is the progress of a team on a quest of the catalog. QuestNumber is
+// the position of the quest in the team's route.
 type Quest struct {
 	gorm.Model
-	TeamName       string
-	QuestNumber    int
-	ImagePath      string
-	Text           string
-	CorrectAnswers string
-	Hint           string
-	AudioPath      string
-	Completed      bool
-	Skipped        bool
-	HintsUsed      int
-	FileRequired   bool
-
-	QuestTimerRequired bool
-	QuestTimerDuration time.Duration
+	TeamName     string
+	QuestNumber  int
+	DefinitionID uint
+	Definition   QuestDefinition `gorm:"save_associations:false"`
+	Completed    bool
+	Skipped      bool
+	TimedOut     bool // Closed by a quest timer in skip mode, not by the team
+	HintsUsed    int
+
 	QuestTimerEndTime  time.Time
 	QuestTimerRunning  bool
 	QuestTimerFinished bool
 
-	HintTimerRequired bool
-	HintTimerDuration time.Duration
 	HintTimerEndTime  time.Time
 	HintTimerRunning  bool
 	HintTimerFinished bool
+
+	StartedAt time.Time
+	Failed    bool
+	Late      bool
+	Answer    string
+	CheckedIn bool
+	Upload    string // Path of the last photo the team uploaded
 }
 
 var (
@@ -62,45 +67,110 @@ var (
 	templateDir = "../client"
 )
 
-func init() {
-	// Load environment variables
-	err := godotenv.Load()
+// setup prepares the server for a configuration: the game settings of the
+// environment, the database and the templates
+func setup(c config) {
+	cfg = c
+	templateDir = c.ClientDir
+	useDataDir(c.DataDir)
+
+	mediaSecret = loadMediaSecret()
+	metricsToken = os.Getenv("METRICS_TOKEN")
+	geoMaxAccuracy = loadGeoMaxAccuracy()
+	checkpointPerTeam, publicURL = loadCheckpointSettings()
+
+	// Load the organizer accounts
+	if err := setupOrganizers(); err != nil {
+		log.Fatalf("Failed to load the organizers: %v", err)
+	}
+
+	// Load the messages of the player pages
+	var err error
+	messages, languages, err = loadMessages(messagesPath)
 	if err != nil {
-		log.Fatalf("Error loading .env file")
+		log.Fatalf("Failed to load the messages: %v", err)
+	}
+	defaultLanguage = loadDefaultLanguage()
+
+	// Load the games with their teams, scoring rules and schedules
+	if err := setupGames(); err != nil {
+		log.Fatalf("Failed to load the games: %v", err)
 	}
 
 	// Initialize SQLite database
-	db, err = gorm.Open("sqlite3", "treasure_hunt.db")
+	db, err = gorm.Open("sqlite3", c.Database)
 	if err != nil {
 		log.Fatalf("Failed to connect to the database: %v", err)
 	}
 
-	if _, err := os.Stat("uploads"); os.IsNotExist(err) {
-		err := os.Mkdir("uploads", 0755)
+	if _, err := os.Stat(c.UploadsDir); os.IsNotExist(err) {
+		err := os.Mkdir(c.UploadsDir, 0755)
 		if err != nil {
 			log.Fatalf("Failed to create uploads directory: %v", err)
 		}
 	}
 
+	registerDBMetrics(db)
+
 	// Migrate the schema
-	db.AutoMigrate(&Quest{})
+	db.AutoMigrate(&QuestDefinition{}, &Quest{}, &QuestMedia{}, &QuestTranslation{}, &LocationFix{}, &Checkpoint{}, &GameEvent{})
+
+	// Quest keys used to be unique across the server, now they are unique per game
+	db.Model(&QuestDefinition{}).RemoveIndex("uix_quest_definitions_key")
 
-	// Seed the database with quests
-	seedDatabaseFromCSV(db, "data/quests.csv")
+	if err := events.open(c.ActionLog, c.ActionLogMaxSize, c.ActionLogBackups); err != nil {
+		log.Fatalf("Error opening log file: %v", err)
+	}
 
 	// Parse templates once and cache them
 	templates = template.Must(template.ParseGlob(fmt.Sprintf("%s/*.html", templateDir)))
 }
 
+// Commands of the server binary, e.g. treasurehunt export -format xlsx
+var commands = map[string]func(args []string) error{
+	"export":        runExport,
+	"analytics":     runAnalytics,
+	"hash-password": runHashPassword,
+}
+
 func main() {
-	defer db.Close()
+	// Commands other than running the server
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatalf("%s failed: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
+	c, err := loadConfig(flag.NewFlagSet("treasurehunt", flag.ContinueOnError), os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	setup(c)
+
+	// Seed the database with the quest catalogs and the routes of the teams,
+	// which starts a new run
+	currentRun = time.Now().Format("2006-01-02 15:04:05")
+	seedGames(db)
+
+	registerRoutes()
+
+	// Finish the games and enforce quest deadlines in the background
+	workers := []worker{
+		{"game clock", 5 * time.Second, finishExpiredGames},
+		{"quest timers", 5 * time.Second, enforceQuestTimers},
+	}
 
-	// Initialize teams with credentials from environment variables
-	teams["TEAM1"] = &Team{Username: os.Getenv("TEAM1USER"), Password: os.Getenv("TEAM1PASS")}
-	teams["TEAM2"] = &Team{Username: os.Getenv("TEAM2USER"), Password: os.Getenv("TEAM2PASS")}
-	teams["TEAM3"] = &Team{Username: os.Getenv("TEAM3USER"), Password: os.Getenv("TEAM3PASS")}
-	teams["TEAM4"] = &Team{Username: os.Getenv("TEAM4USER"), Password: os.Getenv("TEAM4PASS")}
+	if err := serve(withRequestID(withMetrics(http.DefaultServeMux)), workers); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+}
 
+// registerRoutes registers the handlers of the player pages, the organizer
+// pages, monitoring and the API
+func registerRoutes() {
 	// Serve static files
 	http.Handle(
 		"/static/css/",
@@ -111,21 +181,32 @@ func main() {
 		http.StripPrefix("/static/js/", http.FileServer(http.Dir(fmt.Sprintf("%s/static/js", templateDir)))),
 	)
 	http.Handle(
-		"/static/img/",
-		http.StripPrefix("/static/img/", http.FileServer(http.Dir(fmt.Sprintf("%s/static/img", templateDir)))),
-	)
-	http.Handle(
-		"/static/audio/",
-		http.StripPrefix("/static/audio/", http.FileServer(http.Dir(fmt.Sprintf("%s/static/audio", templateDir)))),
+		"/static/sounds/",
+		http.StripPrefix("/static/sounds/", http.FileServer(http.Dir(fmt.Sprintf("%s/static/sounds", templateDir)))),
 	)
 
+	// Serve quest images and audio only to the teams that reached them
+	http.HandleFunc("/media/", handleMedia)
+
 	// Serve the login page
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		lang := requestLanguage(r, "")
 		data := struct {
+			pageText
 			Message string
+			Games   []*Game // Offered when the page isn't for one game
 		}{
-			// Message: "Please enter your credentials",
-			Message: "Моля, въведете вашите данни за вход",
+			pageText: newPageText(lang),
+			Message:  translate(lang, "login_prompt"),
+		}
+
+		if game, ok := requestGame(r); ok {
+			data.Game = game
+		} else {
+			data.Games = games
+		}
+		if code := r.URL.Query().Get("login"); code != "" {
+			data.Message = translate(lang, code)
 		}
 
 		err := templates.ExecuteTemplate(w, "index.html", data)
@@ -142,33 +223,28 @@ func main() {
 			username := r.FormValue("username")
 			password := r.FormValue("password")
 
-			mu.Lock()
-			defer mu.Unlock()
-
-			// Authenticate the user and manage stopwatches
-			for teamName, team := range teams {
-				if username == team.Username && password == team.Password {
-					if !team.StopwatchOn {
-						team.Stopwatch = time.Now()
-						team.StopwatchOn = true
-					}
-
-					// Set a session cookie to track the logged-in user
-					http.SetCookie(w, &http.Cookie{
-						Name:  "logged_in_team",
-						Value: teamName,
-						Path:  "/",
-					})
-
-					// Redirect to the treasure hunt page
-					http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s", teamName), http.StatusSeeOther)
-					return
-				}
+			// Authenticate the user in its game and start its stopwatch
+			teamName, err := loginTeam(r, r.FormValue("game"), username, password)
+			if err == nil {
+				// Set a session cookie to track the logged-in user
+				http.SetCookie(w, &http.Cookie{
+					Name:  "logged_in_team",
+					Value: teamName,
+					Path:  "/",
+				})
+
+				// Redirect to the treasure hunt page
+				http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s", teamName), http.StatusSeeOther)
+				return
 			}
 
 			// http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			// http.Error(w, "Невалидни данни за вход", http.StatusUnauthorized)
-			http.Redirect(w, r, "/", http.StatusSeeOther)
+			query := url.Values{"login": {err.(*actionError).Code}}
+			if game := r.FormValue("game"); game != "" {
+				query.Set("game", game)
+			}
+			http.Redirect(w, r, "/?"+query.Encode(), http.StatusSeeOther)
 		} else {
 			// http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 			// http.Error(w, "Невалидни данни за вход", http.StatusUnauthorized)
@@ -191,6 +267,10 @@ func main() {
 		requestedTeam := r.URL.Query().Get("team")
 		success := r.URL.Query().Get("success")
 		skipped := r.URL.Query().Get("skipped")
+		skipError := r.URL.Query().Get("skipError")
+		expired := r.URL.Query().Get("expired")
+		checkinRequired := r.URL.Query().Get("checkin") == "required"
+		checkpoint := r.URL.Query().Get("checkpoint")
 
 		if teamName != requestedTeam {
 			// http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -213,87 +293,30 @@ func main() {
 		}
 
 		if team.GameFinished {
-			// Count the number of skipped quests
-			var skipCount int64
-			db.Model(&Quest{}).Where("team_name = ? AND skipped = ?", teamName, true).Count(&skipCount)
-
-			// Count the number of hints used
-			var hintCount int64
-			db.Model(&Quest{}).Where("team_name = ?", teamName).Select("sum(hints_used)").Row().Scan(&hintCount)
-
-			// Count the number of completed quests
-			var completedCount int64
-			db.Model(&Quest{}).Where("team_name = ? AND completed = ?", teamName, true).Count(&completedCount)
-			completedCount = completedCount - skipCount
-
-			// Redirect to the game finished page with hint count, skip count, and completed quests
-			http.Redirect(
-				w,
-				r,
-				fmt.Sprintf(
-					"/gamefinished?team=%s&hintCount=%d&skipCount=%d&questsCompleted=%d",
-					teamName,
-					hintCount,
-					skipCount,
-					completedCount,
-				),
-				http.StatusSeeOther,
-			)
+			redirectFinished(w, r, teamName)
 			return
 		}
 
 		elapsed := time.Since(team.Stopwatch)
+		lang := requestLanguage(r, teamName)
 
 		// Get the total number of quests
 		var totalQuests int64
 		db.Model(&Quest{}).Where("team_name = ?", teamName).Count(&totalQuests)
 
-		// Get the current quest
-		var quest Quest
-		if err := db.Where("team_name = ? AND completed = ?", teamName, false).Order("quest_number asc").First(&quest).Error; err != nil {
-			// If no active quests, assume the game is finished and redirect to gamefinished
-
-			// Count the number of skipped quests
-			var skipCount int64
-			db.Model(&Quest{}).Where("team_name = ? AND skipped = ?", teamName, true).Count(&skipCount)
-
-			// Count the number of hints used
-			var hintCount int64
-			db.Model(&Quest{}).Where("team_name = ?", teamName).Select("sum(hints_used)").Row().Scan(&hintCount)
-
-			// Count the number of completed quests
-			var completedCount int64
-			db.Model(&Quest{}).Where("team_name = ? AND completed = ?", teamName, true).Count(&completedCount)
-			completedCount = completedCount - skipCount
-
-			// Redirect to the game finished page with hint count, skip count, and completed quests
-			http.Redirect(
-				w,
-				r,
-				fmt.Sprintf(
-					"/gamefinished?team=%s&hintCount=%d&skipCount=%d&questsCompleted=%d",
-					teamName,
-					hintCount,
-					skipCount,
-					completedCount,
-				),
-				http.StatusSeeOther,
-			)
+		// Get the current quest and the other quests open to the team
+		quest, open, ok := currentQuest(teamName)
+		if !ok {
+			// If no open quests, assume the game is finished and redirect to gamefinished
+			redirectFinished(w, r, teamName)
 			return
 		}
-		if quest.HintTimerRequired && !quest.HintTimerRunning && !quest.HintTimerFinished {
-			quest.HintTimerEndTime = time.Now().Add(quest.HintTimerDuration)
-			quest.HintTimerRunning = true
-			quest.HintTimerFinished = false
-			db.Save(&quest)
-			// fmt.Println("Timer started for hint", quest.QuestNumber)
-			// fmt.Println("Timer will end at", quest.HintTimerEndTime)
-			// fmt.Println("Timer duration", quest.HintTimerDuration)
-			// fmt.Println("Time remaining", time.Until(quest.HintTimerEndTime))
-		}
+
+		// Remember when the team first saw the quest and start its timers
+		startQuest(r, &quest)
 
 		var hintTimerRemaining string
-		if quest.HintTimerRequired {
+		if quest.Definition.HintTimerRequired {
 			remaining := time.Until(quest.HintTimerEndTime)
 			if remaining > 0 {
 				hintTimerRemaining = remaining.String()
@@ -304,47 +327,23 @@ func main() {
 			}
 		}
 
-		if quest.QuestTimerRequired && !quest.QuestTimerRunning && !quest.QuestTimerFinished {
-			quest.QuestTimerEndTime = time.Now().Add(quest.QuestTimerDuration)
-			quest.QuestTimerRunning = true
-			quest.QuestTimerFinished = false
-			db.Save(&quest)
-			// fmt.Println("Timer started for quest", quest.QuestNumber)
-			// fmt.Println("Timer will end at", quest.QuestTimerEndTime)
-			// fmt.Println("Timer duration", quest.QuestTimerDuration)
-			// fmt.Println("Time remaining", time.Until(quest.QuestTimerEndTime))
-		}
-
 		var questTimerRemaining string
-		if quest.QuestTimerRequired {
+		if quest.Definition.QuestTimerRequired {
 			remaining := time.Until(quest.QuestTimerEndTime)
 			if remaining > 0 {
 				questTimerRemaining = remaining.String()
 			} else {
-				quest.QuestTimerRunning = false
-				quest.QuestTimerFinished = true
-				db.Save(&quest)
+				// Deadline timers close the quest when they run out
+				if expireQuest(r, &quest) {
+					http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s&expired=%s", teamName, quest.timerMode()), http.StatusSeeOther)
+					return
+				}
 
-				data := struct {
-					Username            string
-					StartTime           string
-					ElapsedTime         string
-					Quest               Quest
-					SuccessMsg          string
-					ErrorMsg            string
-					SkipMsg             string
-					CurrentQuest        int
-					TotalQuests         int64
-					QuestTimerRemaining string
-					QuestTimerEndTime   string
-					HintTimerRemaining  string
-					HintTimerEndTime    string
-				}{
+				data := questPage{
 					Username:            team.Username,
 					StartTime:           team.Stopwatch.Format(time.RFC3339),
 					ElapsedTime:         elapsed.String(),
-					Quest:               quest,
-					SuccessMsg:          "Quest timer has ended!",
+					SuccessMsg:          translate(lang, "quest_timer_ended"),
 					ErrorMsg:            "",
 					SkipMsg:             "",
 					CurrentQuest:        quest.QuestNumber,
@@ -355,6 +354,8 @@ func main() {
 					HintTimerEndTime:    quest.HintTimerEndTime.Format(time.RFC3339),
 				}
 
+				data.prepare(r, teamName, quest)
+				data.OtherQuests = questChoices(open, quest, lang)
 				err = templates.ExecuteTemplate(w, "treasurehunt.html", data)
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -368,35 +369,37 @@ func main() {
 		var errorMsg string
 		var skipMsg string
 		if success == "true" {
-			// successMsg = "Congratulations! You have successfully completed the quest."
-			successMsg = "Поздравления! Успешно завършихте задачата."
+			successMsg = translate(lang, "quest_completed")
+		} else if success == "late" {
+			successMsg = translate(lang, "late_answer")
+		} else if expired == timerModeSkip {
+			skipMsg = translate(lang, "expired_skip")
+		} else if expired == timerModeFail {
+			errorMsg = translate(lang, "expired_fail")
 		} else if success == "false" {
-			// errorMsg = "Wrong answer, try again!"
-			errorMsg = "Грешен отговор, опитайте отново!"
+			errorMsg = translate(lang, "wrong_answer")
 		} else if skipped == "true" {
-			// skipMsg = "You have skipped this quest."
-			skipMsg = "Прескочихте тази задача."
-		}
-
-		data := struct {
-			Username            string
-			StartTime           string
-			ElapsedTime         string
-			Quest               Quest
-			SuccessMsg          string
-			ErrorMsg            string
-			SkipMsg             string
-			CurrentQuest        int
-			TotalQuests         int64
-			QuestTimerRemaining string
-			QuestTimerEndTime   string
-			HintTimerRemaining  string
-			HintTimerEndTime    string
-		}{
+			skipMsg = translate(lang, "quest_skipped")
+		} else if checkinRequired {
+			errorMsg = translate(lang, "checkin_required")
+		} else if checkpoint == "ok" {
+			successMsg = translate(lang, "checkpoint_ok")
+		} else if checkpoint == "done" {
+			successMsg = translate(lang, "checkpoint_done")
+		} else if checkpoint == "invalid" {
+			errorMsg = translate(lang, "checkpoint_invalid")
+		} else if checkpoint == "locked" {
+			errorMsg = translate(lang, "checkpoint_locked")
+		} else if skipError == "limit" {
+			errorMsg = translate(lang, "skip_limit")
+		} else if skipError == "locked" {
+			errorMsg = translate(lang, "skip_locked")
+		}
+
+		data := questPage{
 			Username:            team.Username,
 			StartTime:           team.Stopwatch.Format(time.RFC3339),
 			ElapsedTime:         elapsed.String(),
-			Quest:               quest,
 			SuccessMsg:          successMsg,
 			ErrorMsg:            errorMsg,
 			SkipMsg:             skipMsg,
@@ -408,6 +411,8 @@ func main() {
 			HintTimerEndTime:    quest.HintTimerEndTime.Format(time.RFC3339),
 		}
 
+		data.prepare(r, teamName, quest)
+		data.OtherQuests = questChoices(open, quest, lang)
 		err = templates.ExecuteTemplate(w, "treasurehunt.html", data)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -426,22 +431,21 @@ func main() {
 				return
 			}
 			teamName := cookie.Value
+			lang := requestLanguage(r, teamName)
 
 			// Parse form data
 			err = r.ParseMultipartForm(10 << 20) // 10 MB limit for uploaded files
 			if err != nil {
-				// http.Error(w, "Error parsing form data", http.StatusBadRequest)
-				http.Error(w, "Грешка при обработката на формуляра", http.StatusBadRequest)
+				http.Error(w, translate(lang, "form_error"), http.StatusBadRequest)
 
 				return
 			}
 
 			answer := r.FormValue("answer")
-			questID := r.FormValue("quest_id")
 
 			// Retrieve the quest from the database using the quest_id and team_name
-			var quest Quest
-			if err := db.Where("id = ? AND team_name = ?", questID, teamName).First(&quest).Error; err != nil {
+			quest, err := teamQuest(teamName, r.FormValue("quest_id"))
+			if err != nil {
 				log.Printf("Quest not found: %v", err)
 				// http.Error(w, "Quest not found", http.StatusNotFound)
 				// http.Error(w, "Задачата не е намерена", http.StatusNotFound)
@@ -450,282 +454,73 @@ func main() {
 				return
 			}
 
-			if quest.QuestTimerRequired && time.Now().Before(quest.QuestTimerEndTime) {
+			// showError shows the quest page again with an error message
+			showError := func(key string) {
 				var totalQuests int64
 				db.Model(&Quest{}).Where("team_name = ?", teamName).Count(&totalQuests)
 
-				data := struct {
-					Username            string
-					StartTime           string
-					ElapsedTime         string
-					Quest               Quest
-					SuccessMsg          string
-					ErrorMsg            string
-					SkipMsg             string
-					CurrentQuest        int
-					TotalQuests         int64
-					QuestTimerRemaining string
-					QuestTimerEndTime   string
-					HintTimerRemaining  string
-					HintTimerEndTime    string
-				}{
-					Username:    teams[teamName].Username,
-					StartTime:   teams[teamName].Stopwatch.Format(time.RFC3339),
-					ElapsedTime: time.Since(teams[teamName].Stopwatch).String(),
-					Quest:       quest,
-					SuccessMsg:  "",
-					// ErrorMsg:     "Wait for the quest timer to end!",
-					ErrorMsg:     "Изчакайте таймера на задачата да изтече!",
-					SkipMsg:      "",
+				mu.Lock()
+				username, stopwatch := teams[teamName].Username, teams[teamName].Stopwatch
+				mu.Unlock()
+
+				data := questPage{
+					Username:     username,
+					StartTime:    stopwatch.Format(time.RFC3339),
+					ElapsedTime:  time.Since(stopwatch).String(),
+					ErrorMsg:     translate(lang, key),
 					CurrentQuest: quest.QuestNumber,
 					TotalQuests:  totalQuests,
-					QuestTimerRemaining: func() string {
-						if quest.QuestTimerRunning {
-							return time.Until(quest.QuestTimerEndTime).String()
-						}
-						return ""
-					}(),
-					QuestTimerEndTime: func() string {
-						if quest.QuestTimerRunning {
-							return quest.QuestTimerEndTime.Format(time.RFC3339)
-						}
-						return ""
-					}(),
-					HintTimerRemaining: func() string {
-						if quest.HintTimerRunning {
-							return time.Until(quest.HintTimerEndTime).String()
-						}
-						return ""
-					}(),
-					HintTimerEndTime: func() string {
-						if quest.HintTimerRunning {
-							return quest.HintTimerEndTime.Format(time.RFC3339)
-						}
-						return ""
-					}(),
 				}
+				if quest.QuestTimerRunning {
+					data.QuestTimerRemaining = time.Until(quest.QuestTimerEndTime).String()
+					data.QuestTimerEndTime = quest.QuestTimerEndTime.Format(time.RFC3339)
+				}
+				if quest.HintTimerRunning {
+					data.HintTimerRemaining = time.Until(quest.HintTimerEndTime).String()
+					data.HintTimerEndTime = quest.HintTimerEndTime.Format(time.RFC3339)
+				}
+				data.prepare(r, teamName, quest)
 				templates.ExecuteTemplate(w, "treasurehunt.html", data)
-				return
 			}
 
-			correctAnswers := strings.Split(quest.CorrectAnswers, "|")
-			isCorrect := false
-			for _, correctAnswer := range correctAnswers {
-				if strings.TrimSpace(strings.ToLower(answer)) == strings.TrimSpace(strings.ToLower(correctAnswer)) {
-					isCorrect = true
-					break
+			if err := canAnswer(r, teamName, &quest); err != nil {
+				switch err.(*actionError).Code {
+				case "wait_quest_timer":
+					showError("wait_quest_timer")
+				case "checkin_required":
+					http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s&checkin=required", teamName), http.StatusSeeOther)
+				case "expired_" + quest.timerMode():
+					// Deadline timers close the quest when they run out
+					http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s&expired=%s", teamName, quest.timerMode()), http.StatusSeeOther)
+				default:
+					http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s", teamName), http.StatusSeeOther)
 				}
-			}
-
-			if strings.ToLower(answer) == "skip" {
-				// Mark the quest as skipped
-				quest.Skipped = true
-				quest.Completed = true
-				db.Save(&quest)
-				logAction(quest.TeamName, fmt.Sprintf("Skipped Quest %d", quest.QuestNumber))
-				http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s&skipped=true", teamName), http.StatusSeeOther)
 				return
 			}
 
-			if quest.FileRequired {
+			if quest.Definition.FileRequired {
 				file, handler, err := r.FormFile("uploaded_image")
 				if err != nil {
-					// fmt.Println("No file uploaded")
 					log.Printf("File upload error: %v", err)
-					var totalQuests int64
-					db.Model(&Quest{}).Where("team_name = ?", teamName).Count(&totalQuests)
-
-					data := struct {
-						Username            string
-						StartTime           string
-						ElapsedTime         string
-						Quest               Quest
-						SuccessMsg          string
-						ErrorMsg            string
-						SkipMsg             string
-						CurrentQuest        int
-						TotalQuests         int64
-						QuestTimerRemaining string
-						QuestTimerEndTime   string
-						HintTimerRemaining  string
-						HintTimerEndTime    string
-					}{
-						Username:    teams[teamName].Username,
-						StartTime:   teams[teamName].Stopwatch.Format(time.RFC3339),
-						ElapsedTime: time.Since(teams[teamName].Stopwatch).String(),
-						Quest:       quest,
-						SuccessMsg:  "",
-						// ErrorMsg:     "No file uploaded",
-						ErrorMsg:     "Не е качен файл",
-						SkipMsg:      "",
-						CurrentQuest: quest.QuestNumber,
-						TotalQuests:  totalQuests,
-						QuestTimerRemaining: func() string {
-							if quest.QuestTimerRunning {
-								return time.Until(quest.QuestTimerEndTime).String()
-							}
-							return ""
-						}(),
-						QuestTimerEndTime: func() string {
-							if quest.QuestTimerRunning {
-								return quest.QuestTimerEndTime.Format(time.RFC3339)
-							}
-							return ""
-						}(),
-						HintTimerRemaining: func() string {
-							if quest.HintTimerRunning {
-								return time.Until(quest.HintTimerEndTime).String()
-							}
-							return ""
-						}(),
-						HintTimerEndTime: func() string {
-							if quest.HintTimerRunning {
-								return quest.HintTimerEndTime.Format(time.RFC3339)
-							}
-							return ""
-						}(),
-					}
-					templates.ExecuteTemplate(w, "treasurehunt.html", data)
+					showError("no_file")
 					return
 				}
 				defer file.Close()
 
 				// Save the file to the server
-				filePath := fmt.Sprintf("uploads/%s_%d_%s", teamName, quest.QuestNumber, handler.Filename)
-				dst, err := os.Create(filePath)
-				if err != nil {
-					// fmt.Println("Error saving file, try again")
-					log.Printf("Error saving file: %v", err)
-					var totalQuests int64
-					db.Model(&Quest{}).Where("team_name = ?", teamName).Count(&totalQuests)
-
-					data := struct {
-						Username            string
-						StartTime           string
-						ElapsedTime         string
-						Quest               Quest
-						SuccessMsg          string
-						ErrorMsg            string
-						SkipMsg             string
-						CurrentQuest        int
-						TotalQuests         int64
-						QuestTimerRemaining string
-						QuestTimerEndTime   string
-						HintTimerRemaining  string
-						HintTimerEndTime    string
-					}{
-						Username:    teams[teamName].Username,
-						StartTime:   teams[teamName].Stopwatch.Format(time.RFC3339),
-						ElapsedTime: time.Since(teams[teamName].Stopwatch).String(),
-						Quest:       quest,
-						SuccessMsg:  "",
-						// ErrorMsg:     "Error saving file, try again",
-						ErrorMsg:     "Грешка при запазването на файла, опитайте отново",
-						SkipMsg:      "",
-						CurrentQuest: quest.QuestNumber,
-						TotalQuests:  totalQuests,
-						QuestTimerRemaining: func() string {
-							if quest.QuestTimerRunning {
-								return time.Until(quest.QuestTimerEndTime).String()
-							}
-							return ""
-						}(),
-						QuestTimerEndTime: func() string {
-							if quest.QuestTimerRunning {
-								return quest.QuestTimerEndTime.Format(time.RFC3339)
-							}
-							return ""
-						}(),
-						HintTimerRemaining: func() string {
-							if quest.HintTimerRunning {
-								return time.Until(quest.HintTimerEndTime).String()
-							}
-							return ""
-						}(),
-						HintTimerEndTime: func() string {
-							if quest.HintTimerRunning {
-								return quest.HintTimerEndTime.Format(time.RFC3339)
-							}
-							return ""
-						}(),
-					}
-					templates.ExecuteTemplate(w, "treasurehunt.html", data)
-					return
-				}
-				defer dst.Close()
-
-				if _, err := io.Copy(dst, file); err != nil {
-					// fmt.Println("Error copying file")
-					log.Printf("Error copying file: %v", err)
-
-					var totalQuests int64
-					db.Model(&Quest{}).Where("team_name = ?", teamName).Count(&totalQuests)
-
-					data := struct {
-						Username            string
-						StartTime           string
-						ElapsedTime         string
-						Quest               Quest
-						SuccessMsg          string
-						ErrorMsg            string
-						SkipMsg             string
-						CurrentQuest        int
-						TotalQuests         int64
-						QuestTimerRemaining string
-						QuestTimerEndTime   string
-						HintTimerRemaining  string
-						HintTimerEndTime    string
-					}{
-						Username:    teams[teamName].Username,
-						StartTime:   teams[teamName].Stopwatch.Format(time.RFC3339),
-						ElapsedTime: time.Since(teams[teamName].Stopwatch).String(),
-						Quest:       quest,
-						SuccessMsg:  "",
-						// ErrorMsg:     "Error copying file",
-						ErrorMsg:     "Грешка при копирането на файла",
-						SkipMsg:      "",
-						CurrentQuest: quest.QuestNumber,
-						TotalQuests:  totalQuests,
-						QuestTimerRemaining: func() string {
-							if quest.QuestTimerRunning {
-								return time.Until(quest.QuestTimerEndTime).String()
-							}
-							return ""
-						}(),
-						QuestTimerEndTime: func() string {
-							if quest.QuestTimerRunning {
-								return quest.QuestTimerEndTime.Format(time.RFC3339)
-							}
-							return ""
-						}(),
-						HintTimerRemaining: func() string {
-							if quest.HintTimerRunning {
-								return time.Until(quest.HintTimerEndTime).String()
-							}
-							return ""
-						}(),
-						HintTimerEndTime: func() string {
-							if quest.HintTimerRunning {
-								return quest.HintTimerEndTime.Format(time.RFC3339)
-							}
-							return ""
-						}(),
-					}
-					templates.ExecuteTemplate(w, "treasurehunt.html", data)
+				if err := saveUpload(r, teamName, &quest, file, handler); err != nil {
+					showError(err.(*actionError).Code)
 					return
 				}
-
-				// Log the uploaded file path
-				log.Printf("File uploaded successfully: %s", filePath)
 			}
 
 			// Check the answer
-			if isCorrect {
-				// Mark the current quest as completed
-				quest.Completed = true
-				db.Save(&quest)
-				logAction(quest.TeamName, fmt.Sprintf("Completed Quest %d", quest.QuestNumber))
+			if answerQuest(r, teamName, &quest, answer, r.Form) {
 				// Redirect to the next quest or show success message
+				if quest.Late {
+					http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s&success=late", teamName), http.StatusSeeOther)
+					return
+				}
 				http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s&success=true", teamName), http.StatusSeeOther)
 			} else {
 				http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s&success=false", teamName), http.StatusSeeOther)
@@ -738,6 +533,45 @@ func main() {
 		}
 	})
 
+	// Handle choosing the language of the player pages
+	http.HandleFunc("/language", handleLanguage)
+
+	// Handle choosing one of the open quests
+	http.HandleFunc("/choose-quest", handleChooseQuest)
+
+	// Handle GPS check-ins at geofenced quests
+	http.HandleFunc("/checkin", handleCheckIn)
+
+	// Handle checkpoint codes, scanned from a QR code or typed in
+	http.HandleFunc("/checkpoint", handleCheckpoint)
+	http.HandleFunc("/checkpoint/", handleCheckpoint)
+
+	// Organizer pages
+	http.HandleFunc("/admin/checkins", requireOrganizer(permView, handleAdminCheckIns))
+	http.HandleFunc("/admin/timeline", requireOrganizer(permView, handleAdminTimeline))
+	http.HandleFunc("/admin/export", requireOrganizer(permView, handleAdminExport))
+	http.HandleFunc("/admin/analytics", requireOrganizer(permView, handleAdminAnalytics))
+	http.HandleFunc("/admin/checkin-override", requireOrganizer(permMarshal, handleAdminCheckInOverride))
+	http.HandleFunc("/admin/checkpoints", requireOrganizer(permMarshal, handleAdminCheckpoints))
+	http.HandleFunc("/admin/checkpoints/qr", requireOrganizer(permMarshal, handleAdminCheckpointQR))
+	http.HandleFunc("/admin/checkpoints.pdf", requireOrganizer(permMarshal, handleAdminCheckpointsPDF))
+	http.HandleFunc("/admin/quests", requireOrganizer(permEdit, handleAdminQuests))
+	http.HandleFunc("/admin/quests/edit", requireOrganizer(permEdit, handleAdminQuestEdit))
+	http.HandleFunc("/admin/quests/action", requireOrganizer(permEdit, handleAdminQuestAction))
+	http.HandleFunc("/admin/quests/preview", requireOrganizer(permEdit, handleAdminQuestPreview))
+	http.HandleFunc("/admin/quests/media", requireOrganizer(permEdit, handleAdminQuestMedia))
+	http.HandleFunc("/admin/assets", requireOrganizer(permEdit, handleAdminAssets))
+	http.HandleFunc("/admin/assets/file", requireOrganizer(permEdit, handleAdminAssetFile))
+	http.HandleFunc("/admin/assets/delete", requireOrganizer(permEdit, handleAdminAssetDelete))
+	http.HandleFunc("/admin/game", requireOrganizer(permView, handleAdminGame))
+	http.HandleFunc("/admin/games", requireOrganizer(permEdit, handleAdminGames))
+	http.HandleFunc("/admin/review", requireOrganizer(permJudge, handleAdminReview))
+	http.HandleFunc("/admin/review/photo", requireOrganizer(permJudge, handleAdminReviewPhoto))
+	http.HandleFunc("/admin/review/answer", requireOrganizer(permJudge, handleAdminReviewAnswer))
+
+	// Handle skip requests
+	http.HandleFunc("/skip", handleSkip)
+
 	// Handler for hint requests
 	http.HandleFunc("/hint/", func(w http.ResponseWriter, r *http.Request) {
 		// Extract quest ID from the URL
@@ -753,26 +587,31 @@ func main() {
 			return
 		}
 		teamName := cookie.Value
+		lang := requestLanguage(r, teamName)
 
 		// Retrieve the quest from the database using the quest_id and team_name
-		var quest Quest
-		if err := db.Where("id = ? AND team_name = ?", questID, teamName).First(&quest).Error; err != nil {
+		quest, err := teamQuest(teamName, questID)
+		if err != nil {
 			log.Printf("Quest not found: %v", err)
-			// http.Error(w, "Quest not found", http.StatusNotFound)
-			http.Error(w, "Задачата не е намерена", http.StatusNotFound)
+			http.Error(w, translate(lang, "quest_not_found"), http.StatusNotFound)
 			return
 		}
 
-		// Increment the hint count and update the quest
-		quest.HintsUsed++
-		db.Save(&quest)
-
-		// Log the hint usage
-		logAction(teamName, fmt.Sprintf("Used Hint for Quest %d", quest.QuestNumber))
+		// Count the hint the first time it is revealed
+		if err := revealHint(r, teamName, &quest); err != nil {
+			refused := err.(*actionError)
+			http.Error(w, translate(lang, refused.Code), refused.Status)
+			return
+		}
 
-		// Respond with success
+		// Respond with the hint
+		view := newQuestView(teamName, lang, quest)
 		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"success": true}`))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"hint":     view.Hint,
+			"hintHtml": view.HintHTML,
+		})
 	})
 
 	// Handle the game finished page
@@ -809,21 +648,25 @@ func main() {
 
 		// Prepare the data for rendering the template
 		data := struct {
+			pageText
 			HintCount       int64
 			SkipCount       int64
 			QuestsCompleted int64
 			TotalQuests     int64
+			Score           int
 		}{
+			pageText:        newGamePageText(requestLanguage(r, teamName), teamGame(teamName)),
 			HintCount:       hintCount,
 			SkipCount:       skipCount,
 			QuestsCompleted: questsCompleted,
 			TotalQuests:     totalQuests,
+			Score:           teamScore(teamName),
 		}
 
 		// Log final stats to a file
-		logFilePath := "teams_finished.log" // The path to the log file
-		logEntry := fmt.Sprintf("Team: %s | Hints Used: %d | Skips: %d | Quests Completed: %d/%d\n",
-			teamName, hintCount, skipCount, questsCompleted, totalQuests)
+		logFilePath := cfg.FinishedLog
+		logEntry := fmt.Sprintf("Game: %s | Team: %s | Hints Used: %d | Skips: %d | Quests Completed: %d/%d | Score: %d\n",
+			teamGame(teamName).Key, teamName, hintCount, skipCount, questsCompleted, totalQuests, data.Score)
 
 		mu.Lock() // Ensure thread-safe file writing
 		file, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -865,15 +708,18 @@ func main() {
 			return
 		}
 
+		lang := requestLanguage(r, teamName)
+
 		// Get the current quest status
-		var quest Quest
-		if err := db.Where("team_name = ? AND completed = ?", teamName, false).Order("quest_number asc").First(&quest).Error; err != nil {
-			http.Error(w, "Няма текуща задача", http.StatusNotFound)
+		quest, _, ok := currentQuest(teamName)
+		if !ok {
+			http.Error(w, translate(lang, "no_current_quest"), http.StatusNotFound)
 			return
 		}
 
 		// Prepare the response data
 		status := map[string]interface{}{
+			"quest":               newQuestView(teamName, lang, quest),
 			"questNumber":         quest.QuestNumber,
 			"completed":           quest.Completed,
 			"skipped":             quest.Skipped,
@@ -894,88 +740,79 @@ func main() {
 		}
 	})
 
-	go func() {
-		for {
-			time.Sleep(5 * time.Second) // Check every 5 secs
-
-			mu.Lock()
-			for _, team := range teams {
-				if team.StopwatchOn &&
-					time.Since(
-						team.Stopwatch,
-					) >= 2*time.Hour { // FIX --------------------- THE TIME THE GAME WILL LAST --------------------------------------------
-					team.GameFinished = true
-					fmt.Printf("Team %s has finished the game\n", team.Username)
-					// You can also log this or perform other actions
-				}
-			}
-			mu.Unlock()
-		}
-	}()
+	// JSON API of the player flow, for apps and automated tests
+	http.HandleFunc("/api/v1/login", handleAPILogin)
+	http.HandleFunc("/api/v1/quest", teamAPI(http.MethodGet, handleAPIQuest))
+	http.HandleFunc("/api/v1/quest/choose", teamAPI(http.MethodPost, handleAPIChoose))
+	http.HandleFunc("/api/v1/submit", teamAPI(http.MethodPost, handleAPISubmit))
+	http.HandleFunc("/api/v1/upload", teamAPI(http.MethodPost, handleAPIUpload))
+	http.HandleFunc("/api/v1/hint", teamAPI(http.MethodPost, handleAPIHint))
+	http.HandleFunc("/api/v1/skip", teamAPI(http.MethodPost, handleAPISkip))
+	http.HandleFunc("/api/v1/finish", teamAPI(http.MethodGet, handleAPIFinish))
+	http.HandleFunc("/api/v1/", handleAPINotFound)
+
+	// Monitoring
+	http.HandleFunc("/metrics", requireMetricsAccess(handleMetrics))
+	http.HandleFunc("/healthz", healthHandler(healthy))
+	http.HandleFunc("/readyz", healthHandler(ready))
+}
 
-	fmt.Println("Server is running on port 8080")
-	http.ListenAndServe(":8080", nil)
+// redirectFinished sends the team to the game finished page with its hint
+// count, skip count and completed quests
+func redirectFinished(w http.ResponseWriter, r *http.Request, teamName string) {
+	summary := summarize(teamName)
+	http.Redirect(
+		w,
+		r,
+		fmt.Sprintf(
+			"/gamefinished?team=%s&hintCount=%d&skipCount=%d&questsCompleted=%d",
+			teamName,
+			summary.Hints,
+			summary.Skips,
+			summary.Completed,
+		),
+		http.StatusSeeOther,
+	)
 }
 
-// Function to read quests from a CSV file and seed the database
-func seedDatabaseFromCSV(db *gorm.DB, filePath string) {
-	// Open the CSV file
-	file, err := os.Open(filePath)
-	if err != nil {
-		fmt.Println("Error opening CSV file:", err)
-		return
+// finishExpiredGames ends the game of every team whose time is up, and of
+// every team of a game that has ended
+func finishExpiredGames() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for teamName, team := range teams {
+		game := teamGame(teamName)
+		timeUp := team.StopwatchOn && !time.Now().Before(game.endFor(team.Stopwatch))
+		_, end, _ := game.schedule()
+		gameEnded := !end.IsZero() && !time.Now().Before(end)
+		if !team.GameFinished && (timeUp || gameEnded) {
+			team.GameFinished = true
+			fmt.Printf("Team %s of game %s has finished the game\n", team.Username, game.Key)
+			logEvent(event{Type: eventGameFinished, Team: teamName, Game: game.Key})
+		}
 	}
-	defer file.Close()
-
-	// Create a new CSV reader
-	reader := csv.NewReader(file)
+}
 
-	// Read all records from the CSV file
-	records, err := reader.ReadAll()
-	if err != nil {
-		fmt.Println("Error reading CSV file:", err)
-		return
+// Helper function to read a column that older CSV files may not have
+func optionalField(record []string, index int) string {
+	if index < len(record) {
+		return record[index]
 	}
+	return ""
+}
 
-	// Clear the database
-	db.Exec("DELETE FROM quests")
-
-	// Check if the quests are already in the database
-	var count int64
-	db.Model(&Quest{}).Count(&count)
-	if count > 0 {
-		fmt.Println("Database already seeded.")
-		return
-	}
+// sortedTeamNames returns the names of all teams in alphabetical order
+func sortedTeamNames() []string {
+	mu.Lock()
+	defer mu.Unlock()
 
-	// Process the CSV records
-	for _, record := range records {
-		// Replace literal \n with actual newlines in text fields
-		for i, field := range record {
-			record[i] = strings.ReplaceAll(field, `\n`, "\n")
-		}
-
-		// Convert and map CSV fields to Quest struct
-		quest := Quest{
-			TeamName:           record[0],
-			QuestNumber:        parseInt(record[1]),
-			Text:               record[2],
-			CorrectAnswers:     record[3],
-			Hint:               record[4],
-			AudioPath:          record[5],
-			ImagePath:          record[6],
-			FileRequired:       parseBool(record[7]),
-			QuestTimerRequired: parseBool(record[8]),
-			QuestTimerDuration: parseDuration(record[9]),
-			HintTimerRequired:  parseBool(record[10]),
-			HintTimerDuration:  parseDuration(record[11]),
-		}
-
-		// Insert quest into the database
-		db.Create(&quest)
+	teamNames := make([]string, 0, len(teams))
+	for teamName := range teams {
+		teamNames = append(teamNames, teamName)
 	}
-
-	fmt.Println("Database seeded with quests from CSV.")
+	sort.Strings(teamNames)
+	return teamNames
 }
 
 // Helper function to parse int
@@ -1007,16 +844,3 @@ func parseDuration(value string) time.Duration {
 	// If all parsing attempts fail, return a zero duration
 	return 0
 }
-
-// logAction logs team actions to a file
-func logAction(teamName, action string) {
-	file, err := os.OpenFile("team_actions.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Printf("Error opening log file: %v", err)
-		return
-	}
-	defer file.Close()
-
-	log.SetOutput(file)
-	log.Printf("Team %s: %s\n", teamName, action)
-}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDir holds the database and the logs of the tests
+var testDir string
+
+func TestMain(m *testing.M) {
+	var err error
+	if testDir, err = os.MkdirTemp("", "treasurehunt-test"); err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	os.RemoveAll(testDir)
+	os.Exit(code)
+}
+
+var testServerOnce sync.Once
+
+// Credentials of the admin of the test server
+const (
+	testAdminUser = "test-admin"
+	testAdminPass = "test-pass"
+)
+
+// newTestServer sets the server up once with an empty database in the test
+// folder and returns its handler
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+	testServerOnce.Do(func() {
+		os.Setenv("ADMIN_USER", testAdminUser)
+		os.Setenv("ADMIN_PASS", testAdminPass)
+		c, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{
+			"-database", filepath.Join(testDir, "test.db"),
+			"-uploads-dir", testDir,
+			"-action-log", filepath.Join(testDir, "team_actions.log"),
+			"-finished-log", filepath.Join(testDir, "teams_finished.log"),
+		})
+		if err != nil {
+			t.Fatalf("config: %v", err)
+		}
+		setup(c)
+		registerRoutes()
+	})
+	return withRequestID(http.DefaultServeMux)
+}
+
+// adminPost sends a form of the test admin, from the given origin
+func adminPost(handler http.Handler, path, origin string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(testAdminUser, testAdminPass)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// teamGet sends a GET request of a logged in team
+func teamGet(handler http.Handler, teamName, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.AddCookie(&http.Cookie{Name: "logged_in_team", Value: teamName})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// addTestTeam adds a team with a running clock to the default game for the
+// length of a test. Its password is "pass-" plus its name.
+func addTestTeam(t *testing.T, teamName string) {
+	t.Helper()
+	mu.Lock()
+	teams[teamName] = &Team{
+		Username:    strings.ToLower(teamName),
+		Password:    "pass-" + teamName,
+		Stopwatch:   time.Now(),
+		StopwatchOn: true,
+		Game:        defaultGameKey,
+	}
+	teamGames[teamName] = defaultGameKey
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(teams, teamName)
+		delete(teamGames, teamName)
+		mu.Unlock()
+	})
+}
+
+// addTestQuest adds a quest of the catalog of the team's game to its route.
+// The key of the definition is made unique from the team and the quest number.
+func addTestQuest(t *testing.T, teamName string, number int, definition QuestDefinition) Quest {
+	t.Helper()
+	definition.Game = teamGames[teamName]
+	definition.Key = fmt.Sprintf("%s-%s-%d", strings.ToLower(teamName), definition.Key, number)
+	if err := db.Create(&definition).Error; err != nil {
+		t.Fatal(err)
+	}
+	quest := Quest{TeamName: teamName, QuestNumber: number, DefinitionID: definition.ID, Definition: definition}
+	if err := db.Create(&quest).Error; err != nil {
+		t.Fatal(err)
+	}
+	return quest
+}
+
+// changeTestGame changes the rules of the default game for the length of a
+// test
+func changeTestGame(t *testing.T, change func(game *Game)) {
+	t.Helper()
+	game, ok := findGame(defaultGameKey)
+	if !ok {
+		t.Fatal("no default game")
+	}
+	old := *game
+	change(game)
+	t.Cleanup(func() { *game = old })
+}
+
+// addTestGame adds a game with the rules of the default game for the length
+// of a test
+func addTestGame(t *testing.T, key string) *Game {
+	t.Helper()
+	game, ok := findGame(defaultGameKey)
+	if !ok {
+		t.Fatal("no default game")
+	}
+	added := *game
+	added.Key, added.Name = key, ""
+	games = append(games, &added)
+
+	t.Cleanup(func() {
+		for i, game := range games {
+			if game == &added {
+				games = append(games[:i], games[i+1:]...)
+				break
+			}
+		}
+	})
+	return &added
+}
+
+// moveTestTeam moves a team added with addTestTeam to another game
+func moveTestTeam(teamName, gameKey string) {
+	mu.Lock()
+	teams[teamName].Game = gameKey
+	teamGames[teamName] = gameKey
+	mu.Unlock()
+}
+
+// adminGet sends a GET request of the test admin working on a game
+func adminGet(handler http.Handler, path, gameKey string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.SetBasicAuth(testAdminUser, testAdminPass)
+	r.AddCookie(&http.Cookie{Name: adminGameCookie, Value: gameKey})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Columns of the games and teams files that the organizers edit
+const (
+	gameColumnName     = 1
+	gameColumnDataDir  = 2
+	gameColumnStart    = 4
+	gameColumnEnd      = 5
+	gameColumnDuration = 6
+
+	teamColumnGame     = 0
+	teamColumnName     = 1
+	teamColumnUsername = 2
+	teamColumnPassword = 3
+	teamColumnLanguage = 4
+)
+
+// gameFilesMu keeps two organizers from editing the games and teams files
+// at the same time
+var gameFilesMu sync.Mutex
+
+// writeRecords writes a CSV file with a header row. It writes to a temporary
+// file first, so a failed write keeps the old file.
+func writeRecords(filePath string, header []string, records [][]string) error {
+	temp := filePath + ".tmp"
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	writer.UseCRLF = true
+	writer.Write(header)
+	writer.WriteAll(records)
+
+	if err := writer.Error(); err != nil {
+		file.Close()
+		os.Remove(temp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, filePath)
+}
+
+// readGameFiles reads the records of the games and teams files, padded to
+// all their columns. Without a games file the server runs the game of the
+// environment, and the records start from it and its teams.
+func readGameFiles() (gameRecords, teamRecords [][]string, err error) {
+	if _, err := os.Stat(gamesPath); os.IsNotExist(err) {
+		gameRecords = [][]string{make([]string, len(gamesHeader))}
+		gameRecords[0][0] = games[0].Key
+
+		mu.Lock()
+		for _, teamName := range gameTeamNames(games[0].Key) {
+			if team := teams[teamName]; team.Username != "" {
+				teamRecords = append(teamRecords, []string{team.Game, teamName, team.Username, team.Password, team.Language})
+			}
+		}
+		mu.Unlock()
+		return gameRecords, teamRecords, nil
+	}
+
+	if gameRecords, err = readCSV(gamesPath); err != nil {
+		return nil, nil, err
+	}
+	if teamRecords, err = readCSV(teamsPath); err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	for i := range gameRecords {
+		gameRecords[i] = padRecord(gameRecords[i], len(gamesHeader))
+	}
+	for i := range teamRecords {
+		teamRecords[i] = padRecord(teamRecords[i], len(teamsHeader))
+	}
+	return gameRecords, teamRecords, nil
+}
+
+// padRecord adds the missing columns of a record, which older files may not
+// have
+func padRecord(record []string, columns int) []string {
+	for len(record) < columns {
+		record = append(record, "")
+	}
+	return record
+}
+
+// findRecord returns the index of the record with a value in a column, or -1
+func findRecord(records [][]string, column int, value string) int {
+	for i, record := range records {
+		if strings.TrimSpace(record[column]) == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// gameForm is a row of the games table of the page
+type gameForm struct {
+	Key      string
+	Name     string
+	Start    string
+	End      string
+	Duration string
+	Loaded   bool // Running on the server, not only in the file
+}
+
+// teamForm is a row of the teams table of the page
+type teamForm struct {
+	Name     string
+	Username string
+	Language string
+	Loaded   bool
+}
+
+// handleAdminGames lists the games and the teams of the organizer's game and
+// saves the changes to the games and teams files. The names and schedules of
+// the games and the logins of the teams change at once; new and removed
+// games and teams after a restart.
+func handleAdminGames(w http.ResponseWriter, r *http.Request) {
+	var message string
+	if r.Method == http.MethodPost {
+		gameFilesMu.Lock()
+		message = applyGamesForm(r)
+		gameFilesMu.Unlock()
+	}
+
+	gameRecords, teamRecords, err := readGameFiles()
+	if err != nil {
+		log.Printf("Failed to read the games files: %v", err)
+		http.Error(w, "Файловете на игрите не могат да бъдат прочетени", http.StatusInternalServerError)
+		return
+	}
+
+	game := adminGame(r)
+	data := struct {
+		GameSwitcher gameSwitcher
+		Game         *Game
+		Games        []gameForm
+		Teams        []teamForm
+		Message      string
+	}{
+		GameSwitcher: newGameSwitcher(r),
+		Game:         game,
+		Message:      message,
+	}
+	for _, record := range gameRecords {
+		key := strings.TrimSpace(record[0])
+		_, loaded := findGame(key)
+		data.Games = append(data.Games, gameForm{
+			Key:      key,
+			Name:     strings.TrimSpace(record[gameColumnName]),
+			Start:    strings.TrimSpace(record[gameColumnStart]),
+			End:      strings.TrimSpace(record[gameColumnEnd]),
+			Duration: strings.TrimSpace(record[gameColumnDuration]),
+			Loaded:   loaded,
+		})
+	}
+	for _, record := range teamRecords {
+		if strings.TrimSpace(record[teamColumnGame]) != game.Key {
+			continue
+		}
+		name := strings.TrimSpace(record[teamColumnName])
+		data.Teams = append(data.Teams, teamForm{
+			Name:     name,
+			Username: strings.TrimSpace(record[teamColumnUsername]),
+			Language: strings.TrimSpace(record[teamColumnLanguage]),
+			Loaded:   teamGames[name] == game.Key,
+		})
+	}
+
+	if err := templates.ExecuteTemplate(w, "admin_setup.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// applyGamesForm applies an action of the games page and returns the message
+// for the organizer
+func applyGamesForm(r *http.Request) string {
+	gameRecords, teamRecords, err := readGameFiles()
+	if err != nil {
+		log.Printf("Failed to read the games files: %v", err)
+		return "Файловете на игрите не могат да бъдат прочетени, вижте лога на сървъра."
+	}
+
+	var message string
+	var apply func() // Changes the running server once the files are saved
+	switch r.FormValue("action") {
+	case "game":
+		message, apply = editGame(r, gameRecords)
+	case "add_game":
+		message, apply = addGame(r, &gameRecords)
+	case "team":
+		message, apply = editTeam(r, teamRecords)
+	case "add_team":
+		message, apply = addTeam(r, gameRecords, &teamRecords)
+	case "delete_team":
+		message, apply = deleteTeam(r, &teamRecords)
+	default:
+		return "Невалидно действие"
+	}
+	if apply == nil {
+		return message
+	}
+
+	if err := hashTeamPasswords(teamRecords); err != nil {
+		log.Printf("Failed to hash the team passwords: %v", err)
+		return "Паролите на отборите не можаха да бъдат защитени, вижте лога на сървъра."
+	}
+	if err := writeRecords(gamesPath, gamesHeader, gameRecords); err != nil {
+		log.Printf("Failed to write the games file: %v", err)
+		return "Файлът на игрите не можа да бъде записан, вижте лога на сървъра."
+	}
+	if err := writeRecords(teamsPath, teamsHeader, teamRecords); err != nil {
+		log.Printf("Failed to write the teams file: %v", err)
+		return "Файлът на отборите не можа да бъде записан, вижте лога на сървъра."
+	}
+	apply()
+	return message
+}
+
+// hashTeamPasswords hashes the passwords of the teams file that are still in
+// plain text, such as those of .env or of a file written by hand
+func hashTeamPasswords(teamRecords [][]string) error {
+	for _, record := range teamRecords {
+		if record[teamColumnPassword] == "" || strings.HasPrefix(record[teamColumnPassword], passwordHashPrefix) {
+			continue
+		}
+		hash, err := hashPassword(record[teamColumnPassword])
+		if err != nil {
+			return err
+		}
+		record[teamColumnPassword] = hash
+	}
+	return nil
+}
+
+// auditChange records a change of the games page in the event log, with the
+// values before the change under keys starting with old_. Passwords are
+// never logged, only whether they changed.
+func auditChange(r *http.Request, gameKey, teamName, change string, before, after map[string]string) {
+	details := map[string]string{"user": currentOrganizer(r).Username, "change": change}
+	for key, value := range before {
+		details["old_"+key] = value
+	}
+	for key, value := range after {
+		details[key] = value
+	}
+	logEvent(event{Type: eventAdmin, Game: gameKey, Team: teamName, RequestID: requestID(r), Details: details})
+}
+
+// editGame changes the name and the schedule of a game
+func editGame(r *http.Request, gameRecords [][]string) (string, func()) {
+	key := r.FormValue("key")
+	index := findRecord(gameRecords, 0, key)
+	if index < 0 {
+		return "Играта не е намерена", nil
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	startText, endText := strings.TrimSpace(r.FormValue("start")), strings.TrimSpace(r.FormValue("end"))
+	durationText := strings.TrimSpace(r.FormValue("duration"))
+
+	start, err := parseGameTime(startText)
+	if err != nil {
+		return "Невалидно начало, напишете го като 2024-05-18 10:00", nil
+	}
+	end, err := parseGameTime(endText)
+	if err != nil {
+		return "Невалиден край, напишете го като 2024-05-18 13:00", nil
+	}
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		return "Краят трябва да е след началото", nil
+	}
+	duration := cfg.GameDuration // An empty column keeps the setting of the environment
+	if durationText != "" {
+		if duration = parseDuration(durationText); duration <= 0 {
+			return "Невалидно време за игра, напишете го като 2h или 90m", nil
+		}
+	}
+
+	record := gameRecords[index]
+	before := map[string]string{
+		"name":     strings.TrimSpace(record[gameColumnName]),
+		"start":    strings.TrimSpace(record[gameColumnStart]),
+		"end":      strings.TrimSpace(record[gameColumnEnd]),
+		"duration": strings.TrimSpace(record[gameColumnDuration]),
+	}
+	record[gameColumnName], record[gameColumnStart], record[gameColumnEnd], record[gameColumnDuration] = name, startText, endText, durationText
+
+	return "Играта е записана.", func() {
+		if game, ok := findGame(key); ok {
+			gamesMu.Lock()
+			game.Name, game.Start, game.End, game.Duration = name, start, end, duration
+			gamesMu.Unlock()
+		}
+		auditChange(r, key, "", "game", before, map[string]string{"name": name, "start": startText, "end": endText, "duration": durationText})
+	}
+}
+
+// addGame adds a game with an empty data folder named after its key, which
+// starts after a restart
+func addGame(r *http.Request, gameRecords *[][]string) (string, func()) {
+	key := strings.TrimSpace(r.FormValue("key"))
+	switch {
+	case key == "" || strings.IndexFunc(key, func(c rune) bool { return !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '-' && c != '_' }) >= 0:
+		return "Ключът на играта може да съдържа само букви, цифри, - и _", nil
+	case findRecord(*gameRecords, 0, key) >= 0:
+		return "Вече има игра с този ключ", nil
+	}
+
+	dataDir := filepath.Join(filepath.Dir(gamesPath), key)
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		log.Printf("Failed to create the folder of game %s: %v", key, err)
+		return "Папката на играта не можа да бъде създадена, вижте лога на сървъра.", nil
+	}
+
+	record := make([]string, len(gamesHeader))
+	record[0], record[gameColumnName], record[gameColumnDataDir] = key, strings.TrimSpace(r.FormValue("name")), key
+	*gameRecords = append(*gameRecords, record)
+
+	return fmt.Sprintf("Играта %s е добавена. Добавете задачите ѝ в %s и рестартирайте сървъра.", key, dataDir), func() {
+		auditChange(r, key, "", "add_game", nil, map[string]string{"name": record[gameColumnName], "data_dir": dataDir})
+	}
+}
+
+// validTeamLogin checks that a username is set and not taken by another team
+// of the game
+func validTeamLogin(teamRecords [][]string, gameKey, teamName, username string) string {
+	if username == "" {
+		return "Потребителското име е задължително"
+	}
+	for _, record := range teamRecords {
+		if strings.TrimSpace(record[teamColumnGame]) == gameKey && strings.TrimSpace(record[teamColumnName]) != teamName &&
+			strings.TrimSpace(record[teamColumnUsername]) == username {
+			return "Потребителското име е заето от друг отбор в играта"
+		}
+	}
+	return ""
+}
+
+// editTeam changes the login and the language of a team. An empty password
+// keeps the old one, a new one is hashed.
+func editTeam(r *http.Request, teamRecords [][]string) (string, func()) {
+	teamName := r.FormValue("team")
+	index := findRecord(teamRecords, teamColumnName, teamName)
+	if index < 0 {
+		return "Отборът не е намерен", nil
+	}
+	record := teamRecords[index]
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+	language := strings.TrimSpace(r.FormValue("language"))
+	if problem := validTeamLogin(teamRecords, strings.TrimSpace(record[teamColumnGame]), teamName, username); problem != "" {
+		return problem, nil
+	}
+	passwordChanged := password != ""
+	if passwordChanged {
+		hash, err := hashPassword(password)
+		if err != nil {
+			log.Printf("Failed to hash the password of team %s: %v", teamName, err)
+			return "Паролата не можа да бъде защитена, вижте лога на сървъра.", nil
+		}
+		password = hash
+	} else {
+		password = record[teamColumnPassword]
+	}
+	before := map[string]string{
+		"username": strings.TrimSpace(record[teamColumnUsername]),
+		"language": strings.TrimSpace(record[teamColumnLanguage]),
+	}
+	record[teamColumnUsername], record[teamColumnPassword], record[teamColumnLanguage] = username, password, language
+
+	return "Отборът е записан.", func() {
+		mu.Lock()
+		if team, ok := teams[teamName]; ok {
+			team.Username, team.Language = username, language
+			if passwordChanged {
+				team.Password = password
+			}
+		}
+		mu.Unlock()
+		auditChange(r, strings.TrimSpace(record[teamColumnGame]), teamName, "team", before, map[string]string{
+			"username":         username,
+			"language":         language,
+			"password_changed": strconv.FormatBool(passwordChanged),
+		})
+	}
+}
+
+// addTeam adds a team to the organizer's game, which plays after a restart
+func addTeam(r *http.Request, gameRecords [][]string, teamRecords *[][]string) (string, func()) {
+	gameKey := adminGame(r).Key
+	teamName := strings.TrimSpace(r.FormValue("team"))
+	username := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+
+	switch {
+	case findRecord(gameRecords, 0, gameKey) < 0:
+		return "Играта не е намерена", nil
+	case teamName == "":
+		return "Името на отбора е задължително", nil
+	case findRecord(*teamRecords, teamColumnName, teamName) >= 0:
+		return "Вече има отбор с това име", nil
+	case password == "":
+		return "Паролата е задължителна", nil
+	}
+	if problem := validTeamLogin(*teamRecords, gameKey, teamName, username); problem != "" {
+		return problem, nil
+	}
+
+	// The password is hashed with the other plain ones before the file is written
+	language := strings.TrimSpace(r.FormValue("language"))
+	*teamRecords = append(*teamRecords, []string{gameKey, teamName, username, password, language})
+	return fmt.Sprintf("Отборът %s е добавен и ще играе след рестарт на сървъра.", teamName), func() {
+		auditChange(r, gameKey, teamName, "add_team", nil, map[string]string{"username": username, "language": language})
+	}
+}
+
+// deleteTeam removes a team from the teams file. The team keeps playing until
+// the server restarts.
+func deleteTeam(r *http.Request, teamRecords *[][]string) (string, func()) {
+	teamName := r.FormValue("team")
+	index := findRecord(*teamRecords, teamColumnName, teamName)
+	if index < 0 {
+		return "Отборът не е намерен", nil
+	}
+
+	record := (*teamRecords)[index]
+	*teamRecords = append((*teamRecords)[:index], (*teamRecords)[index+1:]...)
+	return fmt.Sprintf("Отборът %s е премахнат и ще отпадне след рестарт на сървъра.", teamName), func() {
+		auditChange(r, strings.TrimSpace(record[teamColumnGame]), teamName, "delete_team",
+			map[string]string{"username": strings.TrimSpace(record[teamColumnUsername])}, nil)
+	}
+}
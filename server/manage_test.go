@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The games page writes the games and teams files and changes the names,
+// schedules and logins of the running server
+func TestAdminGamesApplyChanges(t *testing.T) {
+	handler := newTestServer(t)
+
+	dir := t.TempDir()
+	oldGamesPath, oldTeamsPath := gamesPath, teamsPath
+	gamesPath, teamsPath = filepath.Join(dir, "games.csv"), filepath.Join(dir, "teams.csv")
+	mu.Lock()
+	oldTeam := *teams["TEAM1"]
+	mu.Unlock()
+	game := games[0]
+	oldName, oldStart, oldEnd, oldDuration := game.Name, game.Start, game.End, game.Duration
+	defer func() {
+		gamesPath, teamsPath = oldGamesPath, oldTeamsPath
+		mu.Lock()
+		*teams["TEAM1"] = oldTeam
+		mu.Unlock()
+		gamesMu.Lock()
+		game.Name, game.Start, game.End, game.Duration = oldName, oldStart, oldEnd, oldDuration
+		gamesMu.Unlock()
+	}()
+
+	post := func(form url.Values) string {
+		w := adminPost(handler, "/admin/games", "", form)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: status %d", form, w.Code)
+		}
+		return w.Body.String()
+	}
+
+	post(url.Values{"action": {"game"}, "key": {game.Key}, "name": {"Градски лов"}, "start": {"2024-05-18 10:00"}, "duration": {"90m"}})
+	if start, _, duration := game.schedule(); game.Title() != "Градски лов" || start.IsZero() || duration.Minutes() != 90 {
+		t.Errorf("game not changed: %q %v %v", game.Title(), start, duration)
+	}
+
+	post(url.Values{"action": {"team"}, "team": {"TEAM1"}, "username": {"new-login"}, "language": {"en"}})
+	mu.Lock()
+	team := *teams["TEAM1"]
+	mu.Unlock()
+	if team.Username != "new-login" || team.Password != oldTeam.Password || team.Language != "en" {
+		t.Errorf("team not changed or password lost: %+v", team)
+	}
+
+	body := post(url.Values{"action": {"add_team"}, "team": {"TEAM9"}, "username": {"team9"}, "password": {"secret"}})
+	if !strings.Contains(body, "TEAM9") {
+		t.Errorf("new team not listed")
+	}
+	mu.Lock()
+	_, running := teams["TEAM9"]
+	mu.Unlock()
+	if running {
+		t.Errorf("new team plays before a restart")
+	}
+
+	loadedGames, err := loadGames(gamesPath)
+	if err != nil {
+		t.Fatalf("games file: %v", err)
+	}
+	loadedTeams, err := loadTeams(teamsPath, loadedGames)
+	if err != nil {
+		t.Fatalf("teams file: %v", err)
+	}
+	if loadedGames[0].Name != "Градски лов" || loadedTeams["TEAM1"].Username != "new-login" || loadedTeams["TEAM9"] == nil {
+		t.Errorf("files not written: %+v %+v", loadedGames[0], loadedTeams)
+	}
+
+	taken := post(url.Values{"action": {"team"}, "team": {"TEAM9"}, "username": {"new-login"}})
+	if !strings.Contains(taken, "заето") {
+		t.Errorf("username of another team accepted")
+	}
+
+	// The passwords are written hashed, also those that came from .env
+	for name, loaded := range loadedTeams {
+		if !strings.HasPrefix(loaded.Password, passwordHashPrefix) {
+			t.Errorf("password of %s written in plain text", name)
+		}
+	}
+	if !teamPasswordMatches(loadedTeams["TEAM9"].Password, "secret") {
+		t.Error("the hashed password of the new team doesn't match")
+	}
+
+	post(url.Values{"action": {"team"}, "team": {"TEAM1"}, "username": {"new-login"}, "password": {"new-pass"}})
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+	if _, err := loginTeam(r, game.Key, "new-login", "new-pass"); err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+	if _, err := loginTeam(r, game.Key, "new-login", oldTeam.Password); err == nil {
+		t.Error("login with the old password")
+	}
+
+	// The changes are in the event log with the values they replaced
+	var changes []GameEvent
+	db.Where("type = ? AND team_name = ?", eventAdmin, "TEAM1").Order("id").Find(&changes)
+	if len(changes) != 2 {
+		t.Fatalf("%d logged changes of TEAM1, want 2", len(changes))
+	}
+	details := changes[0].details()
+	if details["old_username"] != oldTeam.Username || details["username"] != "new-login" || details["password_changed"] != "false" {
+		t.Errorf("logged change %v", details)
+	}
+	if details := changes[1].details(); details["password_changed"] != "true" || strings.Contains(changes[1].Details, "new-pass") {
+		t.Errorf("logged password change %v", details)
+	}
+}
+
+func TestAdminGamesNeedEditPermission(t *testing.T) {
+	handler := newTestServer(t)
+	organizers["test-viewer"] = &organizer{Username: "test-viewer", Password: "pass", Role: roleViewer, plain: true}
+	defer delete(organizers, "test-viewer")
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/games", nil)
+	r.SetBasicAuth("test-viewer", "pass")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("viewer got status %d, want 403", w.Code)
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The Markdown of quest texts and hints supports paragraphs, headings,
+// emphasis, strikethrough, inline code, code blocks, block quotes, lists,
+// links, images, tables and horizontal rules. Every piece of text is
+// escaped and only these elements are generated, so raw HTML in the
+// catalog is shown as text instead of being rendered.
+
+var (
+	markdownHeading   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	markdownRule      = regexp.MustCompile(`^\s{0,3}(-(\s*-){2,}|\*(\s*\*){2,}|_(\s*_){2,})\s*$`)
+	markdownListItem  = regexp.MustCompile(`^(\s*)([-*+]|\d{1,9}[.)])\s+(.*)$`)
+	markdownTableRule = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	markdownImage     = regexp.MustCompile(`!\[[^\]]*\]\(\s*([^)\s]+)`)
+)
+
+// markdownRenderer renders Markdown, resolving image sources with resolve
+type markdownRenderer struct {
+	resolve func(src string) string
+	out     strings.Builder
+}
+
+// renderMarkdown converts Markdown to safe HTML. Image sources are passed
+// through resolve, which returns the URL to use or "" to drop the image.
+func renderMarkdown(source string, resolve func(src string) string) template.HTML {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	if strings.TrimSpace(source) == "" {
+		return ""
+	}
+
+	m := &markdownRenderer{resolve: resolve}
+	m.blocks(strings.Split(source, "\n"))
+	return template.HTML(m.out.String())
+}
+
+// markdownImagePaths returns the sources of the images in Markdown
+func markdownImagePaths(source string) []string {
+	var paths []string
+	for _, match := range markdownImage.FindAllStringSubmatch(source, -1) {
+		paths = append(paths, match[1])
+	}
+	return paths
+}
+
+// blocks renders a sequence of lines as block elements
+func (m *markdownRenderer) blocks(lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case strings.HasPrefix(trimmed, "```"):
+			i = m.codeBlock(lines, i)
+
+		case markdownHeading.MatchString(trimmed):
+			match := markdownHeading.FindStringSubmatch(trimmed)
+			level := strconv.Itoa(len(match[1]))
+			m.out.WriteString("<h" + level + ">")
+			m.inline(match[2])
+			m.out.WriteString("</h" + level + ">\n")
+			i++
+
+		case markdownRule.MatchString(line):
+			m.out.WriteString("<hr>\n")
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			i = m.blockQuote(lines, i)
+
+		case markdownListItem.MatchString(line):
+			i = m.list(lines, i)
+
+		case i+1 < len(lines) && strings.Contains(line, "|") && markdownTableRule.MatchString(lines[i+1]) && strings.Contains(lines[i+1], "-"):
+			i = m.table(lines, i)
+
+		default:
+			i = m.paragraph(lines, i)
+		}
+	}
+}
+
+// startsBlock reports whether a line interrupts a paragraph
+func startsBlock(lines []string, i int) bool {
+	line := lines[i]
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" ||
+		strings.HasPrefix(trimmed, "```") ||
+		strings.HasPrefix(trimmed, ">") ||
+		markdownHeading.MatchString(trimmed) ||
+		markdownRule.MatchString(line) ||
+		markdownListItem.MatchString(line)
+}
+
+// paragraph renders lines up to the next block, keeping line breaks
+func (m *markdownRenderer) paragraph(lines []string, i int) int {
+	m.out.WriteString("<p>")
+	for first := true; i < len(lines) && (first || !startsBlock(lines, i)); i++ {
+		if !first {
+			m.out.WriteString("<br>\n")
+		}
+		m.inline(strings.TrimSpace(lines[i]))
+		first = false
+	}
+	m.out.WriteString("</p>\n")
+	return i
+}
+
+// codeBlock renders a fenced code block as preformatted text
+func (m *markdownRenderer) codeBlock(lines []string, i int) int {
+	var code []string
+	for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+		code = append(code, lines[i])
+	}
+
+	m.out.WriteString("<pre><code>")
+	m.out.WriteString(html.EscapeString(strings.Join(code, "\n")))
+	m.out.WriteString("</code></pre>\n")
+	return i + 1 // Skip the closing fence
+}
+
+// blockQuote renders the following quoted lines as nested blocks
+func (m *markdownRenderer) blockQuote(lines []string, i int) int {
+	var quoted []string
+	for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+		line := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+		quoted = append(quoted, strings.TrimPrefix(line, " "))
+	}
+
+	m.out.WriteString("<blockquote>\n")
+	m.blocks(quoted)
+	m.out.WriteString("</blockquote>\n")
+	return i
+}
+
+// list renders a list and the lists nested in its items
+func (m *markdownRenderer) list(lines []string, i int) int {
+	match := markdownListItem.FindStringSubmatch(lines[i])
+	indent := len(match[1])
+	ordered := match[2][0] >= '0' && match[2][0] <= '9'
+
+	if ordered {
+		start, _ := strconv.Atoi(strings.TrimRight(match[2], ".)"))
+		if start != 1 {
+			m.out.WriteString(`<ol start="` + strconv.Itoa(start) + `">` + "\n")
+		} else {
+			m.out.WriteString("<ol>\n")
+		}
+	} else {
+		m.out.WriteString("<ul>\n")
+	}
+
+	for i < len(lines) {
+		match := markdownListItem.FindStringSubmatch(lines[i])
+		if match == nil || len(match[1]) != indent || (match[2][0] >= '0' && match[2][0] <= '9') != ordered {
+			break
+		}
+
+		// The item continues with indented lines and lazy continuation lines
+		item := []string{match[3]}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// A blank line ends the item unless indented content follows
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) > indent && strings.TrimSpace(lines[i+1]) != "" {
+					item = append(item, "")
+					continue
+				}
+				break
+			}
+			if next := markdownListItem.FindStringSubmatch(line); next != nil && len(next[1]) <= indent {
+				break
+			}
+			if leadingSpaces(line) <= indent && startsBlock(lines, i) {
+				break
+			}
+			item = append(item, dedent(line, indent+2))
+		}
+
+		m.out.WriteString("<li>")
+		if len(item) == 1 || !containsBlock(item[1:]) {
+			for j, line := range item {
+				if j > 0 {
+					m.out.WriteString("<br>\n")
+				}
+				m.inline(strings.TrimSpace(line))
+			}
+		} else {
+			m.blocks(item)
+		}
+		m.out.WriteString("</li>\n")
+
+		// Blank lines between items keep the list going
+		for i < len(lines) && strings.TrimSpace(lines[i]) == "" && i+1 < len(lines) {
+			next := markdownListItem.FindStringSubmatch(lines[i+1])
+			if next == nil || len(next[1]) != indent {
+				break
+			}
+			i++
+		}
+	}
+
+	if ordered {
+		m.out.WriteString("</ol>\n")
+	} else {
+		m.out.WriteString("</ul>\n")
+	}
+	return i
+}
+
+// containsBlock reports whether item lines hold more than a paragraph
+func containsBlock(lines []string) bool {
+	for i := range lines {
+		if strings.TrimSpace(lines[i]) == "" || startsBlock(lines, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// leadingSpaces counts the indentation of a line, with tabs as four spaces
+func leadingSpaces(line string) int {
+	count := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			count++
+		case '\t':
+			count += 4
+		default:
+			return count
+		}
+	}
+	return count
+}
+
+// dedent removes up to n spaces of indentation
+func dedent(line string, n int) string {
+	for n > 0 && line != "" && (line[0] == ' ' || line[0] == '\t') {
+		if line[0] == '\t' {
+			n -= 4
+		} else {
+			n--
+		}
+		line = line[1:]
+	}
+	return line
+}
+
+// table renders a pipe table with its header and column alignment
+func (m *markdownRenderer) table(lines []string, i int) int {
+	header := tableCells(lines[i])
+
+	var align []string
+	for _, cell := range tableCells(lines[i+1]) {
+		switch left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":"); {
+		case left && right:
+			align = append(align, "center")
+		case right:
+			align = append(align, "right")
+		case left:
+			align = append(align, "left")
+		default:
+			align = append(align, "")
+		}
+	}
+
+	row := func(cells []string, tag string) {
+		m.out.WriteString("<tr>")
+		for j := range header {
+			m.out.WriteString("<" + tag)
+			if j < len(align) && align[j] != "" {
+				m.out.WriteString(` style="text-align: ` + align[j] + `"`)
+			}
+			m.out.WriteString(">")
+			if j < len(cells) {
+				m.inline(cells[j])
+			}
+			m.out.WriteString("</" + tag + ">")
+		}
+		m.out.WriteString("</tr>\n")
+	}
+
+	m.out.WriteString("<table class=\"table table-sm\">\n<thead>\n")
+	row(header, "th")
+	m.out.WriteString("</thead>\n<tbody>\n")
+	for i += 2; i < len(lines) && strings.Contains(lines[i], "|") && strings.TrimSpace(lines[i]) != ""; i++ {
+		row(tableCells(lines[i]), "td")
+	}
+	m.out.WriteString("</tbody>\n</table>\n")
+	return i
+}
+
+// tableCells splits a table row into trimmed cells, honouring \|
+func tableCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = strings.TrimSuffix(line, "|")
+	}
+
+	var cells []string
+	var cell strings.Builder
+	for j := 0; j < len(line); j++ {
+		switch {
+		case line[j] == '\\' && j+1 < len(line) && line[j+1] == '|':
+			cell.WriteByte('|')
+			j++
+		case line[j] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[j])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// inline renders the emphasis, code, links and images of a line of text
+func (m *markdownRenderer) inline(text string) {
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_{}[]()#+-.!|~<>", text[i+1]) >= 0:
+			m.out.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			if end := strings.IndexByte(text[i+1:], '`'); end >= 0 {
+				m.out.WriteString("<code>" + html.EscapeString(text[i+1:i+1+end]) + "</code>")
+				i += end + 2
+				continue
+			}
+
+		case c == '!' && strings.HasPrefix(text[i+1:], "["):
+			if label, target, n, ok := linkParts(text[i+1:]); ok {
+				if src := m.resolve(target); src != "" {
+					m.out.WriteString(`<img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(label) + `" class="img-fluid">`)
+				} else {
+					m.out.WriteString(html.EscapeString(label))
+				}
+				i += n + 1
+				continue
+			}
+
+		case c == '[':
+			if label, target, n, ok := linkParts(text[i:]); ok {
+				if href, safe := safeLink(target); safe {
+					m.out.WriteString(`<a href="` + html.EscapeString(href) + `" target="_blank" rel="noopener noreferrer">`)
+					m.inline(label)
+					m.out.WriteString("</a>")
+				} else {
+					m.inline(label)
+				}
+				i += n
+				continue
+			}
+
+		case c == '<':
+			if end := strings.IndexByte(text[i:], '>'); end > 0 {
+				if href, safe := safeLink(text[i+1 : i+end]); safe && strings.Contains(href, ":") {
+					m.out.WriteString(`<a href="` + html.EscapeString(href) + `" target="_blank" rel="noopener noreferrer">` + html.EscapeString(href) + "</a>")
+					i += end + 1
+					continue
+				}
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			if n, ok := m.emphasis(text, i); ok {
+				i = n
+				continue
+			}
+		}
+
+		// Copy a whole UTF-8 character
+		size := 1
+		for size < 4 && i+size < len(text) && text[i+size]&0xC0 == 0x80 {
+			size++
+		}
+		m.out.WriteString(html.EscapeString(text[i : i+size]))
+		i += size
+	}
+}
+
+// emphasis renders **strong**, *em*, ~~del~~ and their underscore forms
+// starting at i, returning the position after the closing delimiter
+func (m *markdownRenderer) emphasis(text string, i int) (int, bool) {
+	delimiter, tag := text[i:i+1], "em"
+	if strings.HasPrefix(text[i:], strings.Repeat(delimiter, 2)) {
+		delimiter, tag = strings.Repeat(delimiter, 2), "strong"
+	}
+	if delimiter == "~" {
+		return 0, false
+	}
+	if delimiter == "~~" {
+		tag = "del"
+	}
+
+	// Underscores inside words, like snake_case, are not emphasis
+	if delimiter[0] == '_' && i > 0 && isWordByte(text[i-1]) {
+		return 0, false
+	}
+
+	start := i + len(delimiter)
+	if start >= len(text) || text[start] == ' ' {
+		return 0, false
+	}
+
+	for end := start + 1; end+len(delimiter) <= len(text); end++ {
+		// Delimiters in code spans and escaped ones don't close the emphasis
+		if text[end] == '\\' {
+			end++
+			continue
+		}
+		if text[end] == '`' {
+			if close := strings.IndexByte(text[end+1:], '`'); close >= 0 {
+				end += close + 1
+				continue
+			}
+		}
+		if text[end:end+len(delimiter)] != delimiter || text[end-1] == ' ' {
+			continue
+		}
+		// A single delimiter must not be half of a double one
+		if len(delimiter) == 1 && end+1 < len(text) && text[end+1] == delimiter[0] {
+			end++
+			continue
+		}
+		if delimiter[0] == '_' && end+len(delimiter) < len(text) && isWordByte(text[end+len(delimiter)]) {
+			continue
+		}
+
+		m.out.WriteString("<" + tag + ">")
+		m.inline(text[start:end])
+		m.out.WriteString("</" + tag + ">")
+		return end + len(delimiter), true
+	}
+	return 0, false
+}
+
+// isWordByte reports whether a byte belongs to a word, including non-ASCII
+func isWordByte(b byte) bool {
+	return b >= 0x80 || b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// linkParts parses [label](target) at the start of text and returns the
+// number of bytes it spans
+func linkParts(text string) (label, target string, n int, ok bool) {
+	depth := 0
+	for j := 0; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				if j+1 >= len(text) || text[j+1] != '(' {
+					return "", "", 0, false
+				}
+				end := strings.IndexByte(text[j+2:], ')')
+				if end < 0 {
+					return "", "", 0, false
+				}
+
+				// Drop an optional "title"
+				target := strings.TrimSpace(text[j+2 : j+2+end])
+				if space := strings.IndexAny(target, " \t"); space >= 0 {
+					target = target[:space]
+				}
+				return text[1:j], target, j + 3 + end, true
+			}
+		}
+	}
+	return "", "", 0, false
+}
+
+// safeLink allows web, mail and relative links only
+func safeLink(target string) (string, bool) {
+	target = strings.TrimSpace(target)
+	lower := strings.ToLower(target)
+	for _, prefix := range []string{"http://", "https://", "mailto:"} {
+		if strings.HasPrefix(lower, prefix) {
+			return target, true
+		}
+	}
+
+	// Relative links have no scheme before their first path character
+	colon := strings.IndexByte(target, ':')
+	if target != "" && (colon < 0 || (strings.IndexAny(target, "/?#") >= 0 && strings.IndexAny(target, "/?#") < colon)) {
+		return target, true
+	}
+	return "", false
+}
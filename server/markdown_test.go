@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// keepImages resolves every image source to itself
+func keepImages(src string) string { return src }
+
+func TestRenderMarkdown(t *testing.T) {
+	for _, tc := range []struct {
+		name, source, want string
+	}{
+		{"raw html", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"raw html attribute", `<img src=x onerror="alert(1)">`, "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>\n"},
+		{"html in a code block", "```\n<b>bold</b>\n```", "<pre><code>&lt;b&gt;bold&lt;/b&gt;</code></pre>\n"},
+		{"javascript link", "[click](javascript:alert(1))", "<p>click)</p>\n"},
+		{"javascript link in capitals", "[click](JavaScript:alert`1`)", "<p>click</p>\n"},
+		{"data link", "[click](data:text/html;base64,PHNjcmlwdD4=)", "<p>click</p>\n"},
+		{"javascript autolink", "<javascript:alert(1)>", "<p>&lt;javascript:alert(1)&gt;</p>\n"},
+		{"web link", "[site](https://example.com/a?b=1&c=2)",
+			`<p><a href="https://example.com/a?b=1&amp;c=2" target="_blank" rel="noopener noreferrer">site</a></p>` + "\n"},
+		{"quote in a link", `[x](https://example.com/"onmouseover="alert(1))`,
+			`<p><a href="https://example.com/&#34;onmouseover=&#34;alert(1" target="_blank" rel="noopener noreferrer">x</a>)</p>` + "\n"},
+		{"quote in an image", `![a" onerror="alert(1)](/static/img/key.png)`,
+			`<p><img src="/static/img/key.png" alt="a&#34; onerror=&#34;alert(1)" class="img-fluid"></p>` + "\n"},
+		{"nested emphasis", "**bold *and italic* text**", "<p><strong>bold <em>and italic</em> text</strong></p>\n"},
+		{"emphasis around code", "*see `a*b` here*", "<p><em>see <code>a*b</code> here</em></p>\n"},
+		{"emphasis inside code", "`**not bold**`", "<p><code>**not bold**</code></p>\n"},
+		{"html inside code", "`<b>`", "<p><code>&lt;b&gt;</code></p>\n"},
+		{"unclosed code", "`open", "<p>`open</p>\n"},
+		{"snake case", "snake_case_name", "<p>snake_case_name</p>\n"},
+		{"strikethrough", "~~gone~~ ~kept~", "<p><del>gone</del> ~kept~</p>\n"},
+		{"escaped emphasis", `\*plain\*`, "<p>*plain*</p>\n"},
+		{"escaped delimiter inside emphasis", `*a \* b*`, "<p><em>a * b</em></p>\n"},
+		{"link label with emphasis", "[**go**](/next)",
+			`<p><a href="/next" target="_blank" rel="noopener noreferrer"><strong>go</strong></a></p>` + "\n"},
+	} {
+		if got := string(renderMarkdown(tc.source, keepImages)); got != tc.want {
+			t.Errorf("%s: renderMarkdown(%q)\n got %q\nwant %q", tc.name, tc.source, got, tc.want)
+		}
+	}
+}
+
+func TestRenderMarkdownDropsUnresolvedImages(t *testing.T) {
+	got := string(renderMarkdown("![the map](javascript:alert(1))", func(string) string { return "" }))
+	if strings.Contains(got, "<img") || !strings.Contains(got, "the map") {
+		t.Errorf("got %q, want the label without an image", got)
+	}
+}
+
+func TestSafeLink(t *testing.T) {
+	for _, tc := range []struct {
+		target string
+		safe   bool
+	}{
+		{"https://example.com", true},
+		{"HTTP://EXAMPLE.COM", true},
+		{"mailto:team@example.com", true},
+		{"/static/img/key.png", true},
+		{"next.html", true},
+		{"?page=2", true},
+		{"#top", true},
+		{"./a:b", true},
+		{"javascript:alert(1)", false},
+		{"  JavaScript:alert(1)", false},
+		{"data:text/html,<script>", false},
+		{"vbscript:msgbox", false},
+		{"file:///etc/passwd", false},
+		{"", false},
+	} {
+		if _, safe := safeLink(tc.target); safe != tc.safe {
+			t.Errorf("safeLink(%q) = %v, want %v", tc.target, safe, tc.safe)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// mediaSecret signs the quest media URLs so they can't be guessed
+var mediaSecret []byte
+
+// loadMediaSecret reads the MEDIA_SECRET environment variable. Without it a
+// random secret is generated, which changes the media URLs on every restart.
+func loadMediaSecret() []byte {
+	if secret := os.Getenv("MEDIA_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Failed to generate media secret: %v", err)
+	}
+	return secret
+}
+
+// mediaToken returns the unguessable token of a media file for a team
+func mediaToken(teamName, mediaPath string) string {
+	mac := hmac.New(sha256.New, mediaSecret)
+	mac.Write([]byte(teamName))
+	mac.Write([]byte{0})
+	mac.Write([]byte(mediaPath))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// mediaURL returns the URL a team uses to load a quest media file
+func mediaURL(teamName, mediaPath string) string {
+	if mediaPath == "" {
+		return ""
+	}
+	return "/media/" + mediaToken(teamName, mediaPath) + path.Ext(mediaPath)
+}
+
+// mediaFile resolves a quest media path to a file inside the client directory
+func mediaFile(mediaPath string) (string, bool) {
+	root, err := filepath.Abs(templateDir)
+	if err != nil {
+		return "", false
+	}
+
+	file := filepath.Join(root, filepath.FromSlash(path.Clean("/"+mediaPath)))
+	if !strings.HasPrefix(file, root+string(filepath.Separator)) {
+		return "", false
+	}
+	return file, true
+}
+
+// mediaTeam returns the team of a media request: the team of its API token,
+// or the team logged in on the player pages. The media tokens are signed for
+// one team, so the cookie alone doesn't reach the media of another team.
+func mediaTeam(r *http.Request) (string, bool) {
+	if r.Header.Get("Authorization") != "" {
+		return apiTeam(r)
+	}
+	cookie, err := r.Cookie("logged_in_team")
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// handleMedia serves a quest media file to a team whose active or completed
+// quest references it
+func handleMedia(w http.ResponseWriter, r *http.Request) {
+	teamName, ok := mediaTeam(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, "/media/")
+	token = strings.TrimSuffix(token, path.Ext(token))
+
+	// Only quests the team has already reached may deliver media
+	var quests []Quest
+	db.Preload("Definition").Where("team_name = ?", teamName).Find(&quests)
+
+	var reached []QuestDefinition
+	for _, quest := range quests {
+		if quest.Completed || !quest.StartedAt.IsZero() {
+			reached = append(reached, quest.Definition)
+		}
+	}
+
+	mediaPath := ""
+	for _, candidates := range mediaPaths(reached) {
+		for _, candidate := range candidates {
+			if hmac.Equal([]byte(token), []byte(mediaToken(teamName, candidate))) {
+				mediaPath = candidate
+			}
+		}
+	}
+
+	if mediaPath == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	filePath, ok := mediaFile(mediaPath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.Printf("Media file error: %v", err)
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	// ServeContent answers range requests, so videos can seek on phones.
+	// The URL is personal to the team, so only the browser may cache it.
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMediaAccess(t *testing.T) {
+	handler := newTestServer(t)
+	const (
+		teamName = "MEDIA"
+		reached  = "/static/img/key.png"
+		later    = "/static/img/lion.jpg"
+	)
+	addTestTeam(t, teamName)
+	first := addTestQuest(t, teamName, 1, QuestDefinition{Key: "reached", Text: "![](" + reached + ")", CorrectAnswers: "x"})
+	addTestQuest(t, teamName, 2, QuestDefinition{Key: "later", ImagePath: later, CorrectAnswers: "x"})
+	first.StartedAt = first.CreatedAt
+	db.Save(&first)
+
+	if w := teamGet(handler, teamName, mediaURL(teamName, reached)); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("media of a reached quest: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	oldSecret := mediaSecret
+	mediaSecret = []byte("an earlier secret")
+	signedBefore := mediaURL(teamName, reached)
+	mediaSecret = oldSecret
+
+	for _, tc := range []struct {
+		name, team, path string
+	}{
+		{"unreached quest", teamName, mediaURL(teamName, later)},
+		{"token of another team", teamName, mediaURL("TEAM1", reached)},
+		{"token used by another team", "TEAM1", mediaURL(teamName, reached)},
+		{"token of another secret", teamName, signedBefore},
+		{"bad token", teamName, "/media/bm90LWEtdG9rZW4.png"},
+		{"no token", teamName, "/media/.png"},
+		{"no team", "", mediaURL(teamName, reached)},
+	} {
+		if w := teamGet(handler, tc.team, tc.path); w.Code != http.StatusNotFound {
+			t.Errorf("%s: %d, want 404", tc.name, w.Code)
+		}
+	}
+}
+
+func TestMediaFileStaysInTheClientFolder(t *testing.T) {
+	newTestServer(t)
+	root, err := filepath.Abs(templateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/static/img/key.png", "static/../static/img/key.png", "/../server/main.go", "../../etc/passwd"} {
+		file, ok := mediaFile(path)
+		if !ok {
+			t.Errorf("%s: refused", path)
+			continue
+		}
+		if !strings.HasPrefix(file, root+string(filepath.Separator)) {
+			t.Errorf("%s resolves to %s, outside %s", path, file, root)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// counterVec is a Prometheus counter with labels, kept by the joined label
+// values
+type counterVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+func newCounter(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+// add adds to the value of a label combination
+func (m *counterVec) add(delta float64, labelValues ...string) {
+	m.mu.Lock()
+	m.values[strings.Join(labelValues, "\x00")] += delta
+	m.mu.Unlock()
+}
+
+func (m *counterVec) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+// write writes the metric in the Prometheus text format
+func (m *counterVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name)
+	if len(m.labels) == 0 && len(m.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", m.name)
+	}
+	for _, key := range sortedKeys(m.values) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, labelPairs(m.labels, key, ""), formatMetric(m.values[key]))
+	}
+}
+
+// histogramVec is a Prometheus histogram with labels
+type histogramVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+}
+
+// observe records a value for a label combination
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, "\x00")
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+			break
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+// write writes the histogram in the Prometheus text format
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, key, formatMetric(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, key, "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelPairs(h.labels, key, ""), formatMetric(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, key, ""), series.count)
+	}
+}
+
+// labelPairs writes the labels of a series, with the le label of a bucket
+func labelPairs(names []string, key, le string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, "\x00") {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", names[i], value))
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=%q", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetric(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Metrics of the server
+var (
+	httpRequests = newCounter("treasurehunt_http_requests_total",
+		"HTTP requests by handler, method and status code.", "handler", "method", "code")
+	httpDuration = newHistogram("treasurehunt_http_request_duration_seconds",
+		"Time to answer HTTP requests by handler.",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "handler")
+	gameEvents = newCounter("treasurehunt_events_total",
+		"Game events by type, as in the event log.", "type")
+	submissions = newCounter("treasurehunt_submissions_total",
+		"Answers submitted by the teams by result.", "result")
+	uploadBytes = newCounter("treasurehunt_upload_bytes_total",
+		"Bytes of the photos uploaded by the teams.")
+	dbErrors = newCounter("treasurehunt_db_errors_total",
+		"Failed database operations by operation.", "operation")
+)
+
+// countEvent updates the metrics of a game event
+func countEvent(e event) {
+	gameEvents.inc(e.Type)
+	switch e.Type {
+	case eventComplete:
+		submissions.inc("correct")
+	case eventWrongAnswer:
+		submissions.inc("wrong")
+	}
+}
+
+// withMetrics counts the requests and their durations by the pattern of the
+// handler that serves them, which keeps the number of series small
+func withMetrics(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(recorder, r)
+
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "none"
+		}
+		httpRequests.inc(pattern, r.Method, strconv.Itoa(recorder.status))
+		httpDuration.observe(time.Since(start).Seconds(), pattern)
+	})
+}
+
+// registerDBMetrics counts the failed database operations. A query that
+// finds no record is not a failure.
+func registerDBMetrics(db *gorm.DB) {
+	count := func(operation string) func(*gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+				dbErrors.inc(operation)
+			}
+		}
+	}
+	db.Callback().Create().After("gorm:commit_or_rollback_transaction").Register("metrics:create", count("create"))
+	db.Callback().Update().After("gorm:commit_or_rollback_transaction").Register("metrics:update", count("update"))
+	db.Callback().Delete().After("gorm:commit_or_rollback_transaction").Register("metrics:delete", count("delete"))
+	db.Callback().Query().After("gorm:after_query").Register("metrics:query", count("query"))
+}
+
+// metricsToken lets a scraper read the metrics with an Authorization: Bearer
+// header, set with the METRICS_TOKEN environment variable
+var metricsToken string
+
+// requireMetricsAccess lets in the scrapers with the metrics token and the
+// organizers, as the metrics show how the teams are doing
+func requireMetricsAccess(next http.HandlerFunc) http.HandlerFunc {
+	organizers := requireOrganizer(permView, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && metricsToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(metricsToken)) == 1 {
+			next(w, r)
+			return
+		}
+		organizers(w, r)
+	}
+}
+
+// handleMetrics writes the metrics in the Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	for _, metric := range []interface{ write(io.Writer) }{httpRequests, httpDuration, gameEvents, submissions, uploadBytes, dbErrors} {
+		metric.write(w)
+	}
+
+	// Teams playing right now, per game
+	active := map[string]int{}
+	mu.Lock()
+	for _, team := range teams {
+		if team.StopwatchOn && !team.GameFinished {
+			active[team.Game]++
+		}
+	}
+	mu.Unlock()
+	fmt.Fprint(w, "# HELP treasurehunt_active_teams Teams that started and haven't finished the game.\n# TYPE treasurehunt_active_teams gauge\n")
+	for _, game := range games {
+		fmt.Fprintf(w, "treasurehunt_active_teams{game=%q} %d\n", game.Key, active[game.Key])
+	}
+
+	up := 0
+	if ready() == nil {
+		up = 1
+	}
+	fmt.Fprintf(w, "# HELP treasurehunt_ready Whether the server is ready to serve the game.\n# TYPE treasurehunt_ready gauge\ntreasurehunt_ready %d\n", up)
+}
+
+// shuttingDown is set once the server stops taking requests, so load
+// balancers stop sending new ones
+var shuttingDown atomic.Bool
+
+// healthy checks that the server can still reach its database
+func healthy() error {
+	if db == nil {
+		return fmt.Errorf("database not open")
+	}
+	if err := db.DB().Ping(); err != nil {
+		return fmt.Errorf("database: %v", err)
+	}
+	return nil
+}
+
+// ready checks that the server can serve the game: the database answers,
+// the templates are loaded and it isn't shutting down
+func ready() error {
+	if err := healthy(); err != nil {
+		return err
+	}
+	if templates == nil || templates.Lookup("treasurehunt.html") == nil {
+		return fmt.Errorf("templates not loaded")
+	}
+	if shuttingDown.Load() {
+		return fmt.Errorf("shutting down")
+	}
+	return nil
+}
+
+// healthHandler answers ok or the failed check with 503
+func healthHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsNeedAccess(t *testing.T) {
+	handler := newTestServer(t)
+	metricsToken = "scrape-token"
+	defer func() { metricsToken = "" }()
+
+	tests := []struct {
+		name      string
+		authorize func(r *http.Request)
+		want      int
+	}{
+		{"anonymous", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"wrong token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer other") }, http.StatusUnauthorized},
+		{"token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer scrape-token") }, http.StatusOK},
+		{"organizer", func(r *http.Request) { r.SetBasicAuth(testAdminUser, testAdminPass) }, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			tt.authorize(r)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// pdfDocument builds a minimal PDF of A4 pages drawn with vector operators
+// and the standard Helvetica font, which needs no embedding
+type pdfDocument struct {
+	pages []string
+}
+
+// A4 page size in points
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+)
+
+// pdfPage collects the drawing operators of one page. Coordinates start at
+// the bottom left corner of the page.
+type pdfPage struct {
+	content strings.Builder
+}
+
+// text draws a line of text. Helvetica covers Latin-1 only, so other
+// characters are replaced.
+func (p *pdfPage) text(x, y, size float64, value string) {
+	var escaped strings.Builder
+	for _, r := range value {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			escaped.WriteRune('\\')
+			escaped.WriteRune(r)
+		case r < 0x20 || r > 0x7E:
+			escaped.WriteRune('?')
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+	fmt.Fprintf(&p.content, "BT /F1 %.1f Tf %.2f %.2f Td (%s) Tj ET\n", size, x, y, escaped.String())
+}
+
+// qr draws a QR code with its bottom left corner at x, y
+func (p *pdfPage) qr(qr *qrCode, x, y, side float64) {
+	module := side / float64(qr.size)
+	for row := 0; row < qr.size; row++ {
+		for col := 0; col < qr.size; col++ {
+			if qr.modules[row][col] {
+				fmt.Fprintf(&p.content, "%.2f %.2f %.2f %.2f re\n",
+					x+float64(col)*module, y+side-float64(row+1)*module, module, module)
+			}
+		}
+	}
+	p.content.WriteString("f\n")
+}
+
+// addPage appends a finished page to the document
+func (d *pdfDocument) addPage(p *pdfPage) {
+	d.pages = append(d.pages, p.content.String())
+}
+
+// bytes serializes the document with its cross-reference table
+func (d *pdfDocument) bytes() []byte {
+	var buf bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	// Objects 1-3 are the catalog, the page tree and the font, then every
+	// page takes two objects: the page and its content stream
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 4+i*2))
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>")
+
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 5+i*2))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
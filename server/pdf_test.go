@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestPDFCrossReference(t *testing.T) {
+	qr, err := encodeQR("https://hunt.example/checkpoint/ABCD2345")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var document pdfDocument
+	for _, title := range []string{"Checkpoint (1)", "Контролна точка"} {
+		page := &pdfPage{}
+		page.text(72, 760, 28, title)
+		page.qr(qr, 97.5, 280, 400)
+		document.addPage(page)
+	}
+	data := document.bytes()
+
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if startxref == nil {
+		t.Fatalf("no startxref at the end: %q", data[len(data)-40:])
+	}
+	xref, _ := strconv.Atoi(string(startxref[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n0 8\n0000000000 65535 f \n")) {
+		t.Fatalf("startxref %d doesn't point at a table of 8 entries: %q", xref, data[xref:xref+40])
+	}
+
+	// Every entry points at its object: the catalog, the page tree, the font
+	// and a page with its content stream per page
+	entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(data[xref:], -1)
+	if len(entries) != 7 {
+		t.Fatalf("%d entries in use, want 7", len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(data[offset:], []byte(want)) {
+			t.Errorf("entry %d points at %q, want %q", i+1, data[offset:offset+10], want)
+		}
+	}
+
+	// The stream lengths match the content
+	for _, match := range regexp.MustCompile(`(?s)<< /Length (\d+) >>\nstream\n(.*?)endstream`).FindAllSubmatch(data, -1) {
+		if length, _ := strconv.Atoi(string(match[1])); length != len(match[2]) {
+			t.Errorf("stream length %d, content has %d bytes", length, len(match[2]))
+		}
+	}
+
+	if !bytes.Contains(data, []byte(`(Checkpoint \(1\)) Tj`)) {
+		t.Error("the parentheses of the title aren't escaped")
+	}
+	if !bytes.Contains(data, []byte(`(????????? ?????) Tj`)) {
+		t.Error("the characters outside Latin-1 aren't replaced")
+	}
+}
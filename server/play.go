@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The actions of the teams, shared by the player pages and the API
+
+// actionError is an action the game refuses. Code is the message key that
+// explains why, Status the HTTP status the API answers with.
+type actionError struct {
+	Status int
+	Code   string
+}
+
+func (e *actionError) Error() string {
+	return e.Code
+}
+
+func refuse(status int, code string) *actionError {
+	return &actionError{Status: status, Code: code}
+}
+
+// loginTeam finds the team of a username and password in a game and starts
+// its stopwatch on the first login. Without a game key the only game of the
+// server is used. Teams can't log in before their game starts.
+func loginTeam(r *http.Request, gameKey, username, password string) (string, error) {
+	game, ok := findGame(gameKey)
+	if !ok && gameKey == "" && len(games) == 1 {
+		game, ok = games[0], true
+	}
+	if !ok {
+		return "", refuse(http.StatusBadRequest, "unknown_game")
+	}
+
+	// The usernames are unique within a game
+	var teamName, stored string
+	mu.Lock()
+	for name, team := range teams {
+		if team.Game == game.Key && username == team.Username {
+			teamName, stored = name, team.Password
+		}
+	}
+	mu.Unlock()
+
+	if teamName != "" && teamPasswordMatches(stored, password) {
+		if start, _, _ := game.schedule(); !start.IsZero() && time.Now().Before(start) {
+			return "", refuse(http.StatusForbidden, "game_not_started")
+		}
+		mu.Lock()
+		if team, ok := teams[teamName]; ok && !team.StopwatchOn {
+			team.Stopwatch = time.Now()
+			team.StopwatchOn = true
+		}
+		mu.Unlock()
+
+		logEvent(event{Type: eventLogin, Team: teamName, RequestID: requestID(r)})
+		return teamName, nil
+	}
+
+	logEvent(event{Type: eventLoginFailed, Game: game.Key, RequestID: requestID(r), Details: map[string]string{"username": username}})
+	return "", refuse(http.StatusUnauthorized, "invalid_credentials")
+}
+
+// stillPlaying refuses the actions of a team whose game is over, also when
+// the game clock hasn't marked it finished yet
+func stillPlaying(teamName string) error {
+	game := teamGame(teamName)
+	_, end, _ := game.schedule()
+	now := time.Now()
+
+	mu.Lock()
+	team := teams[teamName]
+	over := team.GameFinished || (team.StopwatchOn && !now.Before(game.endFor(team.Stopwatch)))
+	mu.Unlock()
+
+	if over || (!end.IsZero() && !now.Before(end)) {
+		return refuse(http.StatusConflict, "game_finished")
+	}
+	return nil
+}
+
+// teamPasswordMatches checks the password of a team against the one stored:
+// a hash written by the games page, or plain text from .env or a teams file
+// written by hand
+func teamPasswordMatches(stored, password string) bool {
+	if strings.HasPrefix(stored, passwordHashPrefix) {
+		return checkPasswordHash(stored, password)
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// teamQuest loads a quest of the team by its ID
+func teamQuest(teamName, questID string) (Quest, error) {
+	var quest Quest
+	err := db.Preload("Definition").Where("id = ? AND team_name = ?", questID, teamName).First(&quest).Error
+	return quest, err
+}
+
+// gameOver reports whether the team has finished the game, either because
+// its time is up or because no quest is left open to it
+func gameOver(teamName string) bool {
+	mu.Lock()
+	team, ok := teams[teamName]
+	finished := ok && team.GameFinished
+	mu.Unlock()
+
+	if finished {
+		return true
+	}
+	_, _, ok = currentQuest(teamName)
+	return !ok
+}
+
+// startQuest remembers when the team first saw the quest and starts its
+// timers
+func startQuest(r *http.Request, quest *Quest) {
+	if quest.StartedAt.IsZero() {
+		quest.StartedAt = time.Now()
+		db.Save(quest)
+		logEvent(questEvent(r, eventQuestStart, *quest))
+	}
+
+	if quest.Definition.HintTimerRequired && !quest.HintTimerRunning && !quest.HintTimerFinished {
+		quest.HintTimerEndTime = time.Now().Add(quest.Definition.HintTimerDuration)
+		quest.HintTimerRunning = true
+		quest.HintTimerFinished = false
+		db.Save(quest)
+	}
+
+	if quest.Definition.QuestTimerRequired && !quest.QuestTimerRunning && !quest.QuestTimerFinished {
+		quest.QuestTimerEndTime = time.Now().Add(quest.Definition.QuestTimerDuration)
+		quest.QuestTimerRunning = true
+		quest.QuestTimerFinished = false
+		db.Save(quest)
+	}
+}
+
+// chooseQuest switches the team to another of its open quests
+func chooseQuest(r *http.Request, teamName string, quest Quest) error {
+	if !isQuestOpen(teamName, quest) {
+		return refuse(http.StatusConflict, "quest_locked")
+	}
+
+	mu.Lock()
+	if team, ok := teams[teamName]; ok {
+		team.ChosenQuestID = quest.ID
+	}
+	mu.Unlock()
+
+	logEvent(questEvent(r, eventQuestChosen, quest))
+	return nil
+}
+
+// canAnswer checks that the team may answer the quest now. A quest whose
+// deadline has passed is closed on the way.
+func canAnswer(r *http.Request, teamName string, quest *Quest) error {
+	if quest.deadlinePassed() && expireQuest(r, quest) {
+		return refuse(http.StatusConflict, "expired_"+quest.timerMode())
+	}
+	if quest.Completed {
+		return refuse(http.StatusConflict, "quest_closed")
+	}
+	if !isQuestOpen(teamName, *quest) {
+		return refuse(http.StatusConflict, "quest_locked")
+	}
+	if quest.blocksAnswers() {
+		return refuse(http.StatusForbidden, "wait_quest_timer")
+	}
+
+	// Geofenced quests can be answered only after checking in on site
+	if quest.needsCheckIn() {
+		return refuse(http.StatusForbidden, "checkin_required")
+	}
+	return nil
+}
+
+// saveUpload stores the photo of a quest in the uploads directory
+func saveUpload(r *http.Request, teamName string, quest *Quest, file multipart.File, header *multipart.FileHeader) error {
+	filePath := filepath.Join(cfg.UploadsDir, fmt.Sprintf("%s_%d_%s", teamName, quest.QuestNumber, filepath.Base(header.Filename)))
+	dst, err := os.Create(filePath)
+	if err != nil {
+		log.Printf("Error saving file: %v", err)
+		return refuse(http.StatusInternalServerError, "file_save_error")
+	}
+	defer dst.Close()
+
+	written, err := io.Copy(dst, file)
+	if err != nil {
+		log.Printf("Error copying file: %v", err)
+		return refuse(http.StatusInternalServerError, "file_copy_error")
+	}
+
+	log.Printf("File uploaded successfully: %s", filePath)
+	quest.Upload = filePath
+	db.Model(&Quest{}).Where("id = ?", quest.ID).Update("upload", filePath)
+
+	uploadBytes.add(float64(written))
+	upload := questEvent(r, eventUpload, *quest)
+	upload.Details = map[string]string{"file": filePath}
+	logEvent(upload)
+	return nil
+}
+
+// answerQuest checks the answer of a quest and completes the quest when it
+// is right. Puzzles send structured inputs in the form instead of a text
+// answer.
+func answerQuest(r *http.Request, teamName string, quest *Quest, answer string, form url.Values) bool {
+	correct := false
+	if quest.isPuzzle() {
+		answer, correct = checkPuzzle(*quest, form)
+	} else {
+		for _, correctAnswer := range strings.Split(quest.Definition.CorrectAnswers, "|") {
+			if strings.TrimSpace(strings.ToLower(answer)) == strings.TrimSpace(strings.ToLower(correctAnswer)) {
+				correct = true
+				break
+			}
+		}
+	}
+
+	if !correct {
+		wrong := questEvent(r, eventWrongAnswer, *quest)
+		wrong.Details = map[string]string{"answer": strings.TrimSpace(answer)}
+		logEvent(wrong)
+		return false
+	}
+
+	// The quest may be a stale copy: only an open quest is completed, so an
+	// answer can't reopen a quest its timer closed in the meantime
+	answer = strings.TrimSpace(answer)
+	result := db.Model(&Quest{}).Where("id = ? AND completed = ?", quest.ID, false).Updates(map[string]interface{}{
+		"completed": true,
+		"answer":    answer,
+	})
+	if result.Error != nil || result.RowsAffected != 1 {
+		db.Preload("Definition").First(quest, quest.ID)
+		return false
+	}
+
+	quest.Completed = true
+	quest.Answer = answer
+	applyLatePenalty(r, teamName, quest)
+	if quest.Late {
+		db.Model(&Quest{}).Where("id = ?", quest.ID).Update("late", true)
+	}
+	logEvent(questEvent(r, eventComplete, *quest))
+	return true
+}
+
+// revealHint shows the hint of a quest, counting it the first time
+func revealHint(r *http.Request, teamName string, quest *Quest) error {
+	if !isQuestOpen(teamName, *quest) {
+		return refuse(http.StatusForbidden, "quest_locked")
+	}
+	if quest.Definition.Hint == "" {
+		return refuse(http.StatusNotFound, "no_hint")
+	}
+
+	// The hint stays hidden until its timer ends
+	if quest.Definition.HintTimerRequired && time.Now().Before(quest.HintTimerEndTime) {
+		return refuse(http.StatusForbidden, "wait_hint_timer")
+	}
+
+	if quest.HintsUsed == 0 {
+		quest.HintsUsed++
+		db.Save(quest)
+		logEvent(questEvent(r, eventHint, *quest))
+	}
+	return nil
+}
+
+// skipQuest skips a quest if the skip policy allows it
+func skipQuest(r *http.Request, teamName string, quest *Quest) error {
+	if quest.Completed {
+		return refuse(http.StatusConflict, "quest_closed")
+	}
+	if !isQuestOpen(teamName, *quest) {
+		return refuse(http.StatusConflict, "quest_locked")
+	}
+	policy := skipPolicyOf(teamName)
+	if policy.skipsLeft(teamName) == 0 {
+		return refuse(http.StatusForbidden, "skip_limit")
+	}
+	if time.Now().Before(policy.unlockTime(*quest)) {
+		return refuse(http.StatusForbidden, "skip_locked")
+	}
+
+	quest.Skipped = true
+	quest.Completed = true
+	db.Save(quest)
+	logEvent(questEvent(r, eventSkip, *quest))
+	return nil
+}
+
+// teamSummary is how a team did in the game so far
+type teamSummary struct {
+	Hints     int64 `json:"hints"`
+	Skips     int64 `json:"skips"`
+	Completed int64 `json:"completed"` // Answered, not skipped, timed out or failed
+	Total     int64 `json:"total"`
+	Score     int   `json:"score"`
+}
+
+// summarize counts the hints, skips and completed quests of a team
+func summarize(teamName string) teamSummary {
+	var summary teamSummary
+	db.Model(&Quest{}).Where("team_name = ? AND skipped = ?", teamName, true).Count(&summary.Skips)
+	db.Model(&Quest{}).Where("team_name = ?", teamName).Select("sum(hints_used)").Row().Scan(&summary.Hints)
+	db.Model(&Quest{}).Where("team_name = ?", teamName).Count(&summary.Total)
+
+	// Skipped quests and quests closed by their timer are closed too
+	var closed, timedOut, failed int64
+	db.Model(&Quest{}).Where("team_name = ? AND completed = ?", teamName, true).Count(&closed)
+	db.Model(&Quest{}).Where("team_name = ? AND timed_out = ?", teamName, true).Count(&timedOut)
+	db.Model(&Quest{}).Where("team_name = ? AND failed = ?", teamName, true).Count(&failed)
+	summary.Completed = closed - summary.Skips - timedOut - failed
+
+	summary.Score = teamScore(teamName)
+	return summary
+}
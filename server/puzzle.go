@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Puzzle quest types, answered with structured inputs instead of a text
+// answer. Their items are kept in the Options column of the catalog.
+const (
+	questTypeChoice = "choice" // Pick the correct options, CorrectAnswers lists them
+	questTypeOrder  = "order"  // Put the options in order, listed in the correct order
+	questTypeMatch  = "match"  // Match pairs, every option is written as "left=right"
+	questTypeGrid   = "grid"   // Fill in a letter grid, every option is a row and # a black cell
+)
+
+// isPuzzle reports whether the quest is answered with a puzzle
+func (quest Quest) isPuzzle() bool {
+	switch quest.questType() {
+	case questTypeChoice, questTypeOrder, questTypeMatch, questTypeGrid:
+		return true
+	}
+	return false
+}
+
+// Black cell of a letter grid
+const gridBlocked = "#"
+
+// puzzleView is the input of a puzzle quest as shown to a team. Items are
+// shuffled, so the view never gives the solution away.
+type puzzleView struct {
+	Choices  []string       `json:"choices,omitempty"`
+	Multiple bool           `json:"multiple,omitempty"` // More than one choice is correct
+	Items    []string       `json:"items,omitempty"`
+	Pairs    []puzzlePair   `json:"pairs,omitempty"`
+	Targets  []string       `json:"targets,omitempty"` // Right sides of the pairs
+	Grid     [][]puzzleCell `json:"grid,omitempty"`
+}
+
+// puzzlePair is the left side of a pair and the form field of its match
+type puzzlePair struct {
+	Field string `json:"field"`
+	Left  string `json:"left"`
+}
+
+// puzzleCell is a cell of a letter grid. Black cells have no field.
+type puzzleCell struct {
+	Field   string `json:"field,omitempty"`
+	Blocked bool   `json:"blocked,omitempty"`
+}
+
+// puzzleOptions splits the Options column into its items
+func puzzleOptions(definition QuestDefinition) []string {
+	var options []string
+	for _, option := range strings.Split(definition.Options, "|") {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+// puzzlePairs splits the options of a match puzzle into their sides
+func puzzlePairs(definition QuestDefinition) (lefts, rights []string) {
+	for _, option := range puzzleOptions(definition) {
+		left, right, _ := strings.Cut(option, "=")
+		lefts = append(lefts, strings.TrimSpace(left))
+		rights = append(rights, strings.TrimSpace(right))
+	}
+	return lefts, rights
+}
+
+// puzzleGrid splits the options of a grid puzzle into rows of cells. Short
+// rows are filled up with black cells.
+func puzzleGrid(definition QuestDefinition) [][]string {
+	options := puzzleOptions(definition)
+
+	width := 0
+	for _, row := range options {
+		width = max(width, utf8.RuneCountInString(row))
+	}
+
+	grid := make([][]string, len(options))
+	for i, row := range options {
+		for _, letter := range row {
+			grid[i] = append(grid[i], string(letter))
+		}
+		for len(grid[i]) < width {
+			grid[i] = append(grid[i], gridBlocked)
+		}
+	}
+	return grid
+}
+
+// shuffled returns the items in an order that stays the same for a team and
+// quest, so reloading the page doesn't move them around. A list is never
+// left in its original order, which would give an ordering puzzle away.
+func shuffled(items []string, teamName, key string) []string {
+	hash := fnv.New64a()
+	hash.Write([]byte(teamName + "/" + key))
+	random := rand.New(rand.NewSource(int64(hash.Sum64())))
+
+	result := append([]string(nil), items...)
+	random.Shuffle(len(result), func(i, j int) { result[i], result[j] = result[j], result[i] })
+	if len(result) > 1 && strings.Join(result, "\x00") == strings.Join(items, "\x00") {
+		result = append(result[1:], result[0])
+	}
+	return result
+}
+
+// newPuzzleView builds the input of a puzzle quest for a team
+func newPuzzleView(teamName string, quest Quest) *puzzleView {
+	definition := quest.Definition
+	view := &puzzleView{}
+
+	switch quest.questType() {
+	case questTypeChoice:
+		view.Choices = puzzleOptions(definition)
+		view.Multiple = len(correctChoices(definition)) > 1
+	case questTypeOrder:
+		view.Items = shuffled(puzzleOptions(definition), teamName, definition.Key)
+	case questTypeMatch:
+		lefts, rights := puzzlePairs(definition)
+		for i, left := range lefts {
+			view.Pairs = append(view.Pairs, puzzlePair{Field: fmt.Sprintf("match_%d", i), Left: left})
+		}
+		view.Targets = shuffled(rights, teamName, definition.Key)
+	case questTypeGrid:
+		for i, row := range puzzleGrid(definition) {
+			cells := make([]puzzleCell, len(row))
+			for j, letter := range row {
+				if letter == gridBlocked {
+					cells[j].Blocked = true
+				} else {
+					cells[j].Field = fmt.Sprintf("grid_%d_%d", i, j)
+				}
+			}
+			view.Grid = append(view.Grid, cells)
+		}
+	default:
+		return nil
+	}
+	return view
+}
+
+// correctChoices returns the correct options of a choice puzzle
+func correctChoices(definition QuestDefinition) []string {
+	var choices []string
+	for _, choice := range strings.Split(definition.CorrectAnswers, "|") {
+		if choice = strings.TrimSpace(choice); choice != "" {
+			choices = append(choices, choice)
+		}
+	}
+	return choices
+}
+
+// sameAnswer compares two answers ignoring case and surrounding spaces
+func sameAnswer(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// checkPuzzle validates the structured answer of a puzzle quest. It returns
+// the answer as stored with the quest and whether it is correct.
+func checkPuzzle(quest Quest, form url.Values) (string, bool) {
+	definition := quest.Definition
+	switch quest.questType() {
+	case questTypeChoice:
+		return checkChoice(definition, form["choice"])
+	case questTypeOrder:
+		return checkOrder(definition, form["order"])
+	case questTypeMatch:
+		return checkMatch(definition, form)
+	case questTypeGrid:
+		return checkGrid(definition, form)
+	}
+	return "", false
+}
+
+// checkChoice accepts exactly the correct options, in any order
+func checkChoice(definition QuestDefinition, selected []string) (string, bool) {
+	correct := correctChoices(definition)
+	answer := strings.Join(selected, "|")
+	if len(correct) == 0 || len(selected) != len(correct) {
+		return answer, false
+	}
+
+	for _, choice := range correct {
+		found := false
+		for _, option := range selected {
+			if sameAnswer(option, choice) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return answer, false
+		}
+	}
+	return answer, true
+}
+
+// checkOrder accepts the options in the order of the catalog
+func checkOrder(definition QuestDefinition, items []string) (string, bool) {
+	options := puzzleOptions(definition)
+	answer := strings.Join(items, "|")
+	if len(items) != len(options) {
+		return answer, false
+	}
+
+	for i, option := range options {
+		if !sameAnswer(items[i], option) {
+			return answer, false
+		}
+	}
+	return answer, true
+}
+
+// checkMatch accepts every left side matched to its right side
+func checkMatch(definition QuestDefinition, form url.Values) (string, bool) {
+	lefts, rights := puzzlePairs(definition)
+
+	var pairs []string
+	correct := len(lefts) > 0
+	for i, left := range lefts {
+		right := form.Get(fmt.Sprintf("match_%d", i))
+		pairs = append(pairs, left+"="+strings.TrimSpace(right))
+		if !sameAnswer(right, rights[i]) {
+			correct = false
+		}
+	}
+	return strings.Join(pairs, "|"), correct
+}
+
+// checkGrid accepts a grid with every letter in place
+func checkGrid(definition QuestDefinition, form url.Values) (string, bool) {
+	grid := puzzleGrid(definition)
+
+	var rows []string
+	correct := len(grid) > 0
+	for i, row := range grid {
+		var filled strings.Builder
+		for j, letter := range row {
+			if letter == gridBlocked {
+				filled.WriteString(gridBlocked)
+				continue
+			}
+
+			value := strings.TrimSpace(form.Get(fmt.Sprintf("grid_%d_%d", i, j)))
+			if value == "" {
+				value = " "
+			}
+			filled.WriteString(value)
+			if !sameAnswer(value, letter) {
+				correct = false
+			}
+		}
+		rows = append(rows, filled.String())
+	}
+	return strings.Join(rows, "|"), correct
+}
+
+// validatePuzzle reports why a puzzle quest can't be solved, if it can't. The
+// messages are shown to the organizers in the editor.
+func validatePuzzle(definition QuestDefinition) error {
+	options := puzzleOptions(definition)
+	switch definition.QuestType {
+	case questTypeChoice:
+		correct := correctChoices(definition)
+		if len(correct) == 0 {
+			return errors.New("Задачата с избор няма верен отговор")
+		}
+		for _, choice := range correct {
+			found := false
+			for _, option := range options {
+				found = found || sameAnswer(option, choice)
+			}
+			if !found {
+				return fmt.Errorf("Верният отговор %q не е сред възможностите", choice)
+			}
+		}
+	case questTypeOrder:
+		if len(options) < 2 {
+			return errors.New("Задачата за подреждане трябва да има поне два елемента")
+		}
+	case questTypeMatch:
+		lefts, rights := puzzlePairs(definition)
+		if len(lefts) < 2 {
+			return errors.New("Задачата за свързване трябва да има поне две двойки")
+		}
+		for i := range lefts {
+			if lefts[i] == "" || rights[i] == "" {
+				return errors.New("Всяка двойка се записва като ляво=дясно")
+			}
+		}
+	case questTypeGrid:
+		for _, row := range puzzleGrid(definition) {
+			for _, letter := range row {
+				if letter != gridBlocked {
+					return nil
+				}
+			}
+		}
+		return errors.New("Решетката няма нито една буква")
+	}
+	return nil
+}
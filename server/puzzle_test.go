@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCheckChoice(t *testing.T) {
+	definition := QuestDefinition{QuestType: questTypeChoice, Options: "Ботев|Вазов|Славейков", CorrectAnswers: "Вазов|Славейков"}
+	tests := []struct {
+		name     string
+		selected []string
+		want     bool
+	}{
+		{"correct", []string{"Вазов", "Славейков"}, true},
+		{"reordered", []string{"Славейков", "Вазов"}, true},
+		{"case and spaces", []string{" вазов", "СЛАВЕЙКОВ "}, true},
+		{"partial", []string{"Вазов"}, false},
+		{"wrong option", []string{"Вазов", "Ботев"}, false},
+		{"repeated option", []string{"Вазов", "Вазов"}, false},
+		{"too many", []string{"Ботев", "Вазов", "Славейков"}, false},
+		{"nothing", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, got := checkChoice(definition, tt.selected); got != tt.want {
+				t.Errorf("checkChoice(%q) = %v, want %v", tt.selected, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckOrder(t *testing.T) {
+	definition := QuestDefinition{QuestType: questTypeOrder, Options: "първи|втори|трети"}
+	tests := []struct {
+		name  string
+		items []string
+		want  bool
+	}{
+		{"correct", []string{"първи", "втори", "трети"}, true},
+		{"case and spaces", []string{"Първи ", "ВТОРИ", " трети"}, true},
+		{"reordered", []string{"втори", "първи", "трети"}, false},
+		{"partial", []string{"първи", "втори"}, false},
+		{"extra item", []string{"първи", "втори", "трети", "четвърти"}, false},
+		{"nothing", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, got := checkOrder(definition, tt.items); got != tt.want {
+				t.Errorf("checkOrder(%q) = %v, want %v", tt.items, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckMatch(t *testing.T) {
+	definition := QuestDefinition{QuestType: questTypeMatch, Options: "Ботев=Хаджи Димитър|Вазов=Под игото"}
+	tests := []struct {
+		name string
+		form url.Values
+		want bool
+	}{
+		{"correct", url.Values{"match_0": {"Хаджи Димитър"}, "match_1": {"Под игото"}}, true},
+		{"case and spaces", url.Values{"match_0": {" хаджи димитър"}, "match_1": {"ПОД ИГОТО "}}, true},
+		{"swapped", url.Values{"match_0": {"Под игото"}, "match_1": {"Хаджи Димитър"}}, false},
+		{"partial", url.Values{"match_0": {"Хаджи Димитър"}}, false},
+		{"malformed fields", url.Values{"match_x": {"Хаджи Димитър"}, "match_2": {"Под игото"}}, false},
+		{"nothing", url.Values{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, got := checkMatch(definition, tt.form); got != tt.want {
+				t.Errorf("checkMatch(%v) = %v, want %v", tt.form, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckGrid(t *testing.T) {
+	// The short second row is filled up with a black cell
+	definition := QuestDefinition{QuestType: questTypeGrid, Options: "ДА#|НЕ"}
+	tests := []struct {
+		name string
+		form url.Values
+		want bool
+	}{
+		{"correct", url.Values{"grid_0_0": {"Д"}, "grid_0_1": {"А"}, "grid_1_0": {"Н"}, "grid_1_1": {"Е"}}, true},
+		{"lower case", url.Values{"grid_0_0": {"д"}, "grid_0_1": {"а"}, "grid_1_0": {"н"}, "grid_1_1": {"е"}}, true},
+		{"letters swapped", url.Values{"grid_0_0": {"А"}, "grid_0_1": {"Д"}, "grid_1_0": {"Н"}, "grid_1_1": {"Е"}}, false},
+		{"partial", url.Values{"grid_0_0": {"Д"}, "grid_0_1": {"А"}}, false},
+		{"black cell filled", url.Values{"grid_0_0": {"Д"}, "grid_0_1": {"А"}, "grid_0_2": {"X"}, "grid_1_0": {"Н"}}, false},
+		{"nothing", url.Values{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, got := checkGrid(definition, tt.form); got != tt.want {
+				t.Errorf("checkGrid(%v) = %v, want %v", tt.form, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePuzzle(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition QuestDefinition
+		valid      bool
+	}{
+		{"text quest", QuestDefinition{QuestType: questTypeText}, true},
+		{"choice", QuestDefinition{QuestType: questTypeChoice, Options: "а|б", CorrectAnswers: "б"}, true},
+		{"choice without answer", QuestDefinition{QuestType: questTypeChoice, Options: "а|б"}, false},
+		{"choice with unknown answer", QuestDefinition{QuestType: questTypeChoice, Options: "а|б", CorrectAnswers: "в"}, false},
+		{"order", QuestDefinition{QuestType: questTypeOrder, Options: "а|б"}, true},
+		{"order with one item", QuestDefinition{QuestType: questTypeOrder, Options: "а"}, false},
+		{"match", QuestDefinition{QuestType: questTypeMatch, Options: "а=1|б=2"}, true},
+		{"match with one pair", QuestDefinition{QuestType: questTypeMatch, Options: "а=1"}, false},
+		{"match without sides", QuestDefinition{QuestType: questTypeMatch, Options: "а=1|б"}, false},
+		{"grid", QuestDefinition{QuestType: questTypeGrid, Options: "а#"}, true},
+		{"grid without letters", QuestDefinition{QuestType: questTypeGrid, Options: "##"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePuzzle(tt.definition); (err == nil) != tt.valid {
+				t.Errorf("validatePuzzle() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// qrCode is a QR code symbol. Modules are indexed [y][x], true is dark.
+//
+// Only what the checkpoint sheets need is implemented: byte mode, error
+// correction level M and versions 1 to 10, which fit URLs up to 213 bytes.
+type qrCode struct {
+	size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// qrBlocks describes the error correction blocks of a version at level M
+type qrBlocks struct {
+	ecPerBlock int
+	groups     [][2]int // Number of blocks and data codewords per block
+}
+
+// Versions 1 to 10 at error correction level M
+var qrVersions = []qrBlocks{
+	{10, [][2]int{{1, 16}}},
+	{16, [][2]int{{1, 28}}},
+	{26, [][2]int{{1, 44}}},
+	{18, [][2]int{{2, 32}}},
+	{24, [][2]int{{2, 43}}},
+	{16, [][2]int{{4, 27}}},
+	{18, [][2]int{{4, 31}}},
+	{22, [][2]int{{2, 38}, {2, 39}}},
+	{22, [][2]int{{3, 36}, {2, 37}}},
+	{26, [][2]int{{4, 43}, {1, 44}}},
+}
+
+// Centers of the alignment patterns per version
+var qrAlignment = [][]int{
+	{},
+	{6, 18},
+	{6, 22},
+	{6, 26},
+	{6, 30},
+	{6, 34},
+	{6, 22, 38},
+	{6, 24, 42},
+	{6, 26, 46},
+	{6, 28, 50},
+}
+
+// dataCapacity returns the number of data codewords of a version
+func (b qrBlocks) dataCapacity() int {
+	total := 0
+	for _, group := range b.groups {
+		total += group[0] * group[1]
+	}
+	return total
+}
+
+// encodeQR encodes text as the smallest fitting QR code
+func encodeQR(text string) (*qrCode, error) {
+	data := []byte(text)
+
+	for i, blocks := range qrVersions {
+		version := i + 1
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+
+		capacityBits := blocks.dataCapacity() * 8
+		if 4+countBits+len(data)*8 > capacityBits {
+			continue
+		}
+
+		bits := &qrBits{}
+		bits.append(0x4, 4) // Byte mode
+		bits.append(len(data), countBits)
+		for _, b := range data {
+			bits.append(int(b), 8)
+		}
+
+		// Terminator, byte alignment and alternating pad bytes
+		for i := 0; i < 4 && bits.len() < capacityBits; i++ {
+			bits.append(0, 1)
+		}
+		for bits.len()%8 != 0 {
+			bits.append(0, 1)
+		}
+		for pad := 0xEC; bits.len() < capacityBits; pad ^= 0xEC ^ 0x11 {
+			bits.append(pad, 8)
+		}
+
+		qr := newQRCode(version)
+		qr.drawCodewords(blocks.interleave(bits.bytes()))
+		qr.applyBestMask()
+		return qr, nil
+	}
+	return nil, errors.New("text is too long for a QR code")
+}
+
+// qrBits is a growing sequence of bits
+type qrBits struct {
+	data []bool
+}
+
+// append adds the lowest n bits of value, most significant first
+func (b *qrBits) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		b.data = append(b.data, (value>>i)&1 == 1)
+	}
+}
+
+func (b *qrBits) len() int {
+	return len(b.data)
+}
+
+// bytes packs the bits into bytes
+func (b *qrBits) bytes() []byte {
+	result := make([]byte, len(b.data)/8)
+	for i, bit := range b.data {
+		if bit {
+			result[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return result
+}
+
+// interleave splits the data into blocks, adds the error correction and
+// interleaves the codewords of all blocks
+func (b qrBlocks) interleave(data []byte) []byte {
+	generator := rsGenerator(b.ecPerBlock)
+
+	var dataBlocks, ecBlocks [][]byte
+	offset := 0
+	for _, group := range b.groups {
+		for i := 0; i < group[0]; i++ {
+			block := data[offset : offset+group[1]]
+			offset += group[1]
+			dataBlocks = append(dataBlocks, block)
+			ecBlocks = append(ecBlocks, rsRemainder(block, generator))
+		}
+	}
+
+	var result []byte
+	longest := b.groups[len(b.groups)-1][1]
+	for i := 0; i < longest; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < b.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsGenerator returns the Reed-Solomon generator polynomial of a degree,
+// without its leading coefficient
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the Reed-Solomon error correction codewords of data
+func rsRemainder(data, generator []byte) []byte {
+	result := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coefficient := range generator {
+			result[i] ^= gfMultiply(coefficient, factor)
+		}
+	}
+	return result
+}
+
+// newQRCode returns a QR code of a version with its function patterns drawn
+func newQRCode(version int) *qrCode {
+	size := version*4 + 17
+	qr := &qrCode{size: size}
+	for i := 0; i < size; i++ {
+		qr.modules = append(qr.modules, make([]bool, size))
+		qr.isFunction = append(qr.isFunction, make([]bool, size))
+	}
+
+	// Timing patterns
+	for i := 0; i < size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with their separators
+	for _, center := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x >= 0 && x < size && y >= 0 && y < size {
+					distance := max(abs(dx), abs(dy))
+					qr.setFunction(x, y, distance != 2 && distance != 4)
+				}
+			}
+		}
+	}
+
+	// Alignment patterns, except where they would cover the finders
+	positions := qrAlignment[version-1]
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format bits until the mask is known
+	qr.drawFormat(0)
+
+	// Version information
+	if version >= 7 {
+		remainder := version
+		for i := 0; i < 12; i++ {
+			remainder = (remainder << 1) ^ ((remainder >> 11) * 0x1F25)
+		}
+		bits := version<<12 | remainder
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 == 1
+			a, b := size-11+i%3, i/3
+			qr.setFunction(a, b, dark)
+			qr.setFunction(b, a, dark)
+		}
+	}
+	return qr
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// setFunction sets a module that belongs to a function pattern
+func (qr *qrCode) setFunction(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.isFunction[y][x] = true
+}
+
+// drawFormat draws both copies of the format bits for level M and a mask
+func (qr *qrCode) drawFormat(mask int) {
+	data := mask // Level M is encoded as 00
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 9) * 0x537)
+	}
+	bits := (data<<10 | remainder) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	size := qr.size
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		qr.setFunction(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, size-15+i, bit(i))
+	}
+	qr.setFunction(8, size-8, true) // Always dark
+}
+
+// drawCodewords places the codewords in the zigzag order of the standard
+func (qr *qrCode) drawCodewords(codewords []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // Skip the vertical timing pattern
+		}
+		for vertical := 0; vertical < qr.size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vertical
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vertical
+				}
+				if !qr.isFunction[y][x] && i < len(codewords)*8 {
+					qr.modules[y][x] = (codewords[i/8]>>(7-i%8))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by a mask pattern. Applying
+// the same mask twice undoes it.
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !qr.isFunction[y][x] {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// applyBestMask applies the mask with the lowest penalty score
+func (qr *qrCode) applyBestMask() {
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormat(mask)
+		if penalty := qr.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask)
+	}
+	qr.applyMask(best)
+	qr.drawFormat(best)
+}
+
+// penalty scores how hard the symbol is to scan, lower is better
+func (qr *qrCode) penalty() int {
+	size := qr.size
+	penalty := 0
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return qr.modules[x][y]
+		}
+		return qr.modules[y][x]
+	}
+
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < size; y++ {
+			// Runs of five or more modules of the same color
+			run := 1
+			for x := 1; x <= size; x++ {
+				if x < size && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+
+			// Patterns that look like finders
+			for x := 0; x+11 <= size; x++ {
+				for _, pattern := range finderLike {
+					matches := true
+					for k, dark := range pattern {
+						if at(x+k, y, vertical) != dark {
+							matches = false
+							break
+						}
+					}
+					if matches {
+						penalty += 40
+					}
+				}
+			}
+		}
+	}
+
+	// Blocks of 2x2 modules of the same color
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+			if x+1 < size && y+1 < size {
+				c := qr.modules[y][x]
+				if qr.modules[y][x+1] == c && qr.modules[y+1][x] == c && qr.modules[y+1][x+1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	// Balance of dark and light modules
+	percent := dark * 100 / (size * size)
+	penalty += abs(percent-50) / 5 * 10
+	return penalty
+}
+
+// png renders the QR code with a quiet zone of four modules
+func (qr *qrCode) png(scale int) ([]byte, error) {
+	border := 4
+	side := (qr.size + border*2) * scale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if !qr.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetGray((x+border)*scale+dx, (y+border)*scale+dy, color.Gray{Y: 0})
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
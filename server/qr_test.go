@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// Format bits of error correction level M for masks 0 to 7, from the table
+// of the standard
+var qrFormatM = []int{0x5412, 0x5125, 0x5E7C, 0x5B4B, 0x45F9, 0x40CE, 0x4F97, 0x4AA0}
+
+// readFormat reads both copies of the format bits of a symbol
+func readFormat(qr *qrCode) (int, int) {
+	size := qr.size
+	var first, second int
+	set := func(bits *int, i, x, y int) {
+		if qr.modules[y][x] {
+			*bits |= 1 << i
+		}
+	}
+	for i := 0; i <= 5; i++ {
+		set(&first, i, 8, i)
+	}
+	set(&first, 6, 8, 7)
+	set(&first, 7, 8, 8)
+	set(&first, 8, 7, 8)
+	for i := 9; i < 15; i++ {
+		set(&first, i, 14-i, 8)
+	}
+	for i := 0; i < 8; i++ {
+		set(&second, i, size-1-i, 8)
+	}
+	for i := 8; i < 15; i++ {
+		set(&second, i, 8, size-15+i)
+	}
+	return first, second
+}
+
+func TestQRFormatBits(t *testing.T) {
+	for mask, want := range qrFormatM {
+		qr := newQRCode(1)
+		qr.drawFormat(mask)
+		first, second := readFormat(qr)
+		if first != want || second != want {
+			t.Errorf("mask %d: format bits %015b and %015b, want %015b", mask, first, second, want)
+		}
+		if !qr.modules[qr.size-8][8] {
+			t.Errorf("mask %d: the dark module is light", mask)
+		}
+	}
+}
+
+func TestQRVersionBits(t *testing.T) {
+	// Version information of the standard for versions 7 to 10
+	for version, want := range map[int]int{7: 0x07C94, 8: 0x085BC, 9: 0x09A99, 10: 0x0A4D3} {
+		qr := newQRCode(version)
+		var below, right int
+		for i := 0; i < 18; i++ {
+			a, b := qr.size-11+i%3, i/3
+			if qr.modules[b][a] {
+				below |= 1 << i
+			}
+			if qr.modules[a][b] {
+				right |= 1 << i
+			}
+		}
+		if below != want || right != want {
+			t.Errorf("version %d: version bits %018b and %018b, want %018b", version, below, right, want)
+		}
+	}
+}
+
+func TestReedSolomon(t *testing.T) {
+	// HELLO WORLD as version 1-M, the worked example of the standard
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsGenerator(10)); !bytes.Equal(got, want) {
+		t.Errorf("error correction %v, want %v", got, want)
+	}
+
+	if got := gfMultiply(0x80, 0x02); got != 0x1D {
+		t.Errorf("0x80 * 2 = %#x, want 0x1d", got)
+	}
+}
+
+// readCodewords unmasks a symbol and reads its codewords in placement order
+func readCodewords(qr *qrCode, mask int) []byte {
+	qr.applyMask(mask)
+	defer qr.applyMask(mask)
+
+	var codewords []byte
+	bit := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vertical := 0; vertical < qr.size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vertical
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vertical
+				}
+				if qr.isFunction[y][x] {
+					continue
+				}
+				if bit%8 == 0 {
+					codewords = append(codewords, 0)
+				}
+				if qr.modules[y][x] {
+					codewords[bit/8] |= 1 << (7 - bit%8)
+				}
+				bit++
+			}
+		}
+	}
+	return codewords
+}
+
+func TestEncodeQR(t *testing.T) {
+	for _, tc := range []struct {
+		text    string
+		version int
+	}{
+		{"https://hunt.example/checkpoint/ABCD2345", 3},
+		{strings.Repeat("a", 14), 1},
+		{strings.Repeat("a", 213), 10},
+	} {
+		qr, err := encodeQR(tc.text)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", len(tc.text), err)
+		}
+		if qr.size != tc.version*4+17 {
+			t.Errorf("%d bytes: size %d, want version %d", len(tc.text), qr.size, tc.version)
+		}
+
+		first, second := readFormat(qr)
+		mask := -1
+		for m, format := range qrFormatM {
+			if first == format && second == format {
+				mask = m
+			}
+		}
+		if mask < 0 {
+			t.Fatalf("%d bytes: invalid format bits %015b", len(tc.text), first)
+		}
+
+		// Version 1 has a single block, so its codewords aren't interleaved
+		if tc.version != 1 {
+			continue
+		}
+		codewords := readCodewords(qr, mask)
+		data, ec := codewords[:16], codewords[16:26]
+		if data[0] != 0x40|byte(len(tc.text))>>4 || data[1]>>4 != byte(len(tc.text))&0xF {
+			t.Errorf("mode and length %08b %08b", data[0], data[1])
+		}
+		if !bytes.Equal(ec, rsRemainder(data, rsGenerator(10))) {
+			t.Errorf("the error correction doesn't match the data")
+		}
+	}
+
+	if _, err := encodeQR(strings.Repeat("a", 214)); err == nil {
+		t.Error("214 bytes fit, but version 10-M holds 213")
+	}
+}
+
+func TestQRPNG(t *testing.T) {
+	qr, err := encodeQR("TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := qr.png(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Four modules of quiet zone on each side
+	if want := (qr.size + 8) * 4; img.Bounds().Dx() != want || img.Bounds().Dy() != want {
+		t.Errorf("image %v, want %dx%d", img.Bounds(), want, want)
+	}
+}
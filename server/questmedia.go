@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Kinds of quest media items
+const (
+	mediaKindImage = "image"
+	mediaKindAudio = "audio"
+	mediaKindVideo = "video"
+	mediaKindFile  = "file" // A download, e.g. a PDF
+)
+
+// QuestMedia is one item of the ordered media list of a quest, stored in
+// data/media.csv next to the catalog
+type QuestMedia struct {
+	gorm.Model
+	DefinitionID uint
+	Position     int
+	Kind         string
+	Path         string
+	Caption      string
+	AltText      string
+}
+
+// Columns of the quest media list, in order
+var questMediaHeader = []string{"Key", "Path", "Type", "Caption", "AltText"}
+
+// mediaKindOf returns the kind of a media item, guessing it from the file
+// extension when it's not set
+func mediaKindOf(kind, mediaPath string) string {
+	switch kind = strings.ToLower(strings.TrimSpace(kind)); kind {
+	case mediaKindImage, mediaKindAudio, mediaKindVideo, mediaKindFile:
+		return kind
+	}
+
+	switch folder, _ := assetKind(mediaPath); folder {
+	case assetKindImage:
+		return mediaKindImage
+	case assetKindAudio:
+		return mediaKindAudio
+	case assetKindVideo:
+		return mediaKindVideo
+	}
+	return mediaKindFile
+}
+
+// loadQuestMedia reads the media lists of the quests by quest key. The file
+// is optional.
+func loadQuestMedia(filePath string) (map[string][]QuestMedia, error) {
+	records, err := readCSV(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	media := map[string][]QuestMedia{}
+	for _, record := range records {
+		key := strings.TrimSpace(optionalField(record, 0))
+		mediaPath := strings.TrimSpace(optionalField(record, 1))
+		if key == "" || mediaPath == "" {
+			continue
+		}
+
+		media[key] = append(media[key], QuestMedia{
+			Position: len(media[key]) + 1,
+			Kind:     mediaKindOf(optionalField(record, 2), mediaPath),
+			Path:     mediaPath,
+			Caption:  strings.ReplaceAll(optionalField(record, 3), `\n`, "\n"),
+			AltText:  optionalField(record, 4),
+		})
+	}
+	return media, nil
+}
+
+// writeQuestMedia writes the media lists of the quests in catalog order
+func writeQuestMedia(filePath string, definitions []QuestDefinition) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.UseCRLF = true
+	writer.Write(questMediaHeader)
+	for _, definition := range definitions {
+		for _, item := range definitionMedia(definition.ID) {
+			writer.Write([]string{
+				definition.Key,
+				item.Path,
+				item.Kind,
+				strings.ReplaceAll(item.Caption, "\n", `\n`),
+				item.AltText,
+			})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// definitionMedia returns the ordered media list of a quest definition
+func definitionMedia(definitionID uint) []QuestMedia {
+	var media []QuestMedia
+	db.Where("definition_id = ?", definitionID).Order("position").Find(&media)
+	return media
+}
+
+// mediaPaths returns every media file of the quest definitions by ID,
+// including the single image and audio of the catalog and the images in
+// their texts and translations. The media lists and the translations of all
+// the definitions are loaded at once.
+func mediaPaths(definitions []QuestDefinition) map[uint][]string {
+	paths := map[uint][]string{}
+	if len(definitions) == 0 {
+		return paths
+	}
+
+	ids := make([]uint, len(definitions))
+	texts := map[uint][]string{}
+	for i, definition := range definitions {
+		ids[i] = definition.ID
+		for _, mediaPath := range []string{definition.ImagePath, definition.AudioPath} {
+			if mediaPath != "" {
+				paths[definition.ID] = append(paths[definition.ID], mediaPath)
+			}
+		}
+		texts[definition.ID] = append(texts[definition.ID], definition.Text, definition.Hint)
+	}
+
+	var media []QuestMedia
+	db.Where("definition_id IN (?)", ids).Order("position").Find(&media)
+	for _, item := range media {
+		paths[item.DefinitionID] = append(paths[item.DefinitionID], item.Path)
+	}
+
+	// Images of the quest texts and hints, in every language
+	var translations []QuestTranslation
+	db.Where("definition_id IN (?)", ids).Find(&translations)
+	for _, translation := range translations {
+		texts[translation.DefinitionID] = append(texts[translation.DefinitionID], translation.Text, translation.Hint)
+	}
+	for id, definitionTexts := range texts {
+		for _, text := range definitionTexts {
+			for _, src := range markdownImagePaths(text) {
+				if strings.HasPrefix(src, "/static/") {
+					paths[id] = append(paths[id], src)
+				}
+			}
+		}
+	}
+	return paths
+}
+
+// mediaView is a media item of the quest as shown to a team
+type mediaView struct {
+	Kind    string `json:"kind"`
+	URL     string `json:"url"`
+	Caption string `json:"caption,omitempty"`
+	AltText string `json:"altText,omitempty"`
+}
+
+// mediaViews returns the media list of a quest with URLs built by url
+func mediaViews(definitionID uint, url func(mediaPath string) string) []mediaView {
+	var views []mediaView
+	for _, item := range definitionMedia(definitionID) {
+		views = append(views, mediaView{
+			Kind:    item.Kind,
+			URL:     url(item.Path),
+			Caption: item.Caption,
+			AltText: item.AltText,
+		})
+	}
+	return views
+}
+
+// applyMediaForm replaces the media list of a quest with the rows of the
+// editor form. Rows are ordered by their position field.
+func applyMediaForm(definitionID uint, paths, kinds, captions, altTexts, positions []string) {
+	db.Unscoped().Where("definition_id = ?", definitionID).Delete(&QuestMedia{})
+
+	type row struct {
+		item     QuestMedia
+		position int
+	}
+	var rows []row
+	for i, mediaPath := range paths {
+		mediaPath = strings.TrimSpace(mediaPath)
+		if mediaPath == "" {
+			continue
+		}
+
+		position, err := strconv.Atoi(strings.TrimSpace(optionalField(positions, i)))
+		if err != nil {
+			position = 1000 + i // Rows without a position keep their order at the end
+		}
+
+		rows = append(rows, row{
+			item: QuestMedia{
+				DefinitionID: definitionID,
+				Kind:         mediaKindOf(optionalField(kinds, i), mediaPath),
+				Path:         mediaPath,
+				Caption:      strings.ReplaceAll(optionalField(captions, i), "\r\n", "\n"),
+				AltText:      strings.TrimSpace(optionalField(altTexts, i)),
+			},
+			position: position,
+		})
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].position < rows[j].position })
+
+	for i := range rows {
+		rows[i].item.Position = i + 1
+		db.Create(&rows[i].item)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMediaPaths(t *testing.T) {
+	newTestServer(t)
+
+	first := QuestDefinition{Game: defaultGameKey, Key: "media-first", ImagePath: "/static/image/first.jpg", Text: "![](/static/image/text.png)"}
+	second := QuestDefinition{Game: defaultGameKey, Key: "media-second", Hint: "![](https://example.com/outside.png)"}
+	for _, definition := range []*QuestDefinition{&first, &second} {
+		if err := db.Create(definition).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Create(&QuestMedia{DefinitionID: second.ID, Kind: mediaKindAudio, Path: "/static/audio/second.mp3"})
+	db.Create(&QuestTranslation{DefinitionID: second.ID, Language: "en", Text: "![](/static/image/english.png)"})
+
+	paths := mediaPaths([]QuestDefinition{first, second})
+	want := map[uint][]string{
+		first.ID:  {"/static/image/first.jpg", "/static/image/text.png"},
+		second.ID: {"/static/audio/second.mp3", "/static/image/english.png"},
+	}
+	for _, list := range paths {
+		sort.Strings(list)
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("mediaPaths = %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// reviewQuest loads a quest of a team of the organizer's game
+func reviewQuest(r *http.Request, questID string) (Quest, error) {
+	var quest Quest
+	err := db.Preload("Definition").Where("id = ? AND team_name IN (?)", questID, gameTeamNames(adminGame(r).Key)).First(&quest).Error
+	return quest, err
+}
+
+// solved reports whether the team answered the quest itself, rather than
+// skipping it or running out of time
+func (quest Quest) solved() bool {
+	return quest.Completed && !quest.Skipped && !quest.TimedOut && !quest.Failed
+}
+
+// handleAdminReview lists the photos and the wrong answers of the teams of
+// the organizer's game, for the judges to accept or reject
+func handleAdminReview(w http.ResponseWriter, r *http.Request) {
+	teamNames := gameTeamNames(adminGame(r).Key)
+
+	type photo struct {
+		Quest
+		Time   string
+		Solved bool
+	}
+	type wrongAnswer struct {
+		Time        string
+		TeamName    string
+		QuestNumber int
+		QuestKey    string
+		Answer      string
+		QuestID     uint
+		Open        bool // Not solved yet, so the answer can be accepted
+	}
+	data := struct {
+		GameSwitcher gameSwitcher
+		Photos       []photo
+		Answers      []wrongAnswer
+	}{
+		GameSwitcher: newGameSwitcher(r),
+	}
+
+	var quests []Quest
+	db.Preload("Definition").Where("team_name IN (?) AND upload <> ''", teamNames).Order("updated_at desc").Find(&quests)
+	for _, quest := range quests {
+		data.Photos = append(data.Photos, photo{Quest: quest, Time: quest.UpdatedAt.Format(time.Kitchen), Solved: quest.solved()})
+	}
+
+	var records []GameEvent
+	db.Where("type = ? AND team_name IN (?) AND run = ?", eventWrongAnswer, teamNames, currentRun).Order("time desc").Limit(100).Find(&records)
+	for _, record := range records {
+		var quest Quest
+		db.Where("team_name = ? AND quest_number = ?", record.TeamName, record.QuestNumber).First(&quest)
+		data.Answers = append(data.Answers, wrongAnswer{
+			Time:        record.Time.Format(time.Kitchen),
+			TeamName:    record.TeamName,
+			QuestNumber: record.QuestNumber,
+			QuestKey:    record.QuestKey,
+			Answer:      record.details()["answer"],
+			QuestID:     quest.ID,
+			Open:        quest.ID != 0 && !quest.solved(),
+		})
+	}
+
+	if err := templates.ExecuteTemplate(w, "admin_review.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleAdminReviewPhoto shows a photo uploaded by a team
+func handleAdminReviewPhoto(w http.ResponseWriter, r *http.Request) {
+	quest, err := reviewQuest(r, r.URL.Query().Get("quest_id"))
+	if err != nil || quest.Upload == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, quest.Upload)
+}
+
+// handleAdminReviewAnswer overrides the result of a quest: accept completes
+// it with the given answer, reject opens a solved quest again so the team
+// has to answer it once more
+func handleAdminReviewAnswer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/review", http.StatusSeeOther)
+		return
+	}
+
+	quest, err := reviewQuest(r, r.FormValue("quest_id"))
+	if err != nil {
+		http.Error(w, "Задачата не е намерена", http.StatusNotFound)
+		return
+	}
+	judge := currentOrganizer(r).Username
+
+	switch r.FormValue("action") {
+	case "accept":
+		if quest.solved() {
+			break
+		}
+		quest.Completed = true
+		quest.Skipped = false
+		quest.TimedOut = false
+		quest.Failed = false
+		quest.Late = false
+		if answer := r.FormValue("answer"); answer != "" {
+			quest.Answer = answer
+		}
+		db.Save(&quest)
+
+		accepted := questEvent(r, eventComplete, quest)
+		accepted.Details = map[string]string{"judge": judge, "answer": quest.Answer}
+		logEvent(accepted)
+	case "reject":
+		if !quest.solved() {
+			break
+		}
+		rejected := questEvent(r, eventAnswerRejected, quest)
+		rejected.Details = map[string]string{"judge": judge, "answer": quest.Answer}
+
+		quest.Completed = false
+		quest.Late = false
+		quest.Answer = ""
+		db.Save(&quest)
+		logEvent(rejected)
+	default:
+		http.Error(w, "Невалидно действие", http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/admin/review", http.StatusSeeOther)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Route modes, chosen with the ROUTE_MODE environment variable
+const (
+	routeModeSame     = "same"     // Every team follows the catalog order
+	routeModeExplicit = "explicit" // Routes are read from the routes CSV
+	routeModeRotation = "rotation" // Every team starts at a different point of the catalog order
+	routeModeLatin    = "latin"    // Routes are rows of a balanced Latin square
+)
+
+// splitFixed separates the quests pinned to the start or end of every route
+func splitFixed(definitions []QuestDefinition) (first, middle, last []string) {
+	for _, definition := range definitions {
+		switch definition.Fixed {
+		case fixedFirst:
+			first = append(first, definition.Key)
+		case fixedLast:
+			last = append(last, definition.Key)
+		default:
+			middle = append(middle, definition.Key)
+		}
+	}
+	return first, middle, last
+}
+
+// rotationOrder returns the movable quests started at an even offset for each team
+func rotationOrder(middle []string, team, teamCount int) []string {
+	if len(middle) == 0 {
+		return nil
+	}
+
+	offset := team * len(middle) / teamCount
+	return append(append([]string{}, middle[offset:]...), middle[:offset]...)
+}
+
+// latinOrder returns a row of a balanced (Williams) Latin square, so every
+// quest follows every other quest about equally often across the teams
+func latinOrder(middle []string, team, teamCount int) []string {
+	n := len(middle)
+	if n == 0 {
+		return nil
+	}
+
+	row := team * n / teamCount
+	order := make([]string, 0, n)
+	for j := 0; j < n; j++ {
+		// The first row is 0, 1, n-1, 2, n-2, ... and the other rows shift it
+		step := (j + 1) / 2
+		if j%2 == 0 {
+			step = n - j/2
+		}
+		order = append(order, middle[(row+step)%n])
+	}
+	return order
+}
+
+// readExplicitRoutes reads the routes CSV, where every row is a team name
+// followed by the quest keys of its route
+func readExplicitRoutes(routesPath string, definitions []QuestDefinition) (map[string][]string, error) {
+	records, err := readCSV(routesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(definitions))
+	for _, definition := range definitions {
+		known[definition.Key] = true
+	}
+
+	routes := map[string][]string{}
+	for _, record := range records {
+		teamName := strings.TrimSpace(record[0])
+		for _, key := range record[1:] {
+			key = strings.TrimSpace(key)
+			if key == "" {
+				continue
+			}
+			if !known[key] {
+				return nil, fmt.Errorf("route of %s uses unknown quest %q", teamName, key)
+			}
+			routes[teamName] = append(routes[teamName], key)
+		}
+	}
+	return routes, nil
+}
+
+// assignRoutes gives every team its ordering of the catalog quests
+func assignRoutes(mode string, definitions []QuestDefinition, teamNames []string, routesPath string) (map[string][]string, error) {
+	if mode == routeModeExplicit {
+		routes, err := readExplicitRoutes(routesPath, definitions)
+		if err != nil {
+			return nil, err
+		}
+		for _, teamName := range teamNames {
+			if len(routes[teamName]) == 0 {
+				return nil, fmt.Errorf("no route for %s in %s", teamName, routesPath)
+			}
+		}
+		return routes, nil
+	}
+
+	first, middle, last := splitFixed(definitions)
+
+	routes := map[string][]string{}
+	for i, teamName := range teamNames {
+		var order []string
+		switch mode {
+		case routeModeSame, "":
+			order = middle
+		case routeModeRotation:
+			order = rotationOrder(middle, i, len(teamNames))
+		case routeModeLatin:
+			order = latinOrder(middle, i, len(teamNames))
+		default:
+			return nil, fmt.Errorf("unknown route mode %q", mode)
+		}
+
+		route := append([]string{}, first...)
+		route = append(route, order...)
+		routes[teamName] = append(route, last...)
+	}
+	return routes, nil
+}
+
+// removeFromRoutes drops a quest from the explicit routes file, if there is one
+func removeFromRoutes(routesPath, key string) error {
+	return rewriteRoutes(routesPath, func(route []string) []string {
+		kept := route[:0]
+		for _, routeKey := range route {
+			if strings.TrimSpace(routeKey) != key {
+				kept = append(kept, routeKey)
+			}
+		}
+		return kept
+	})
+}
+
+// addToRoutes adds a quest to every route of the explicit routes file, if
+// there is one: right after the quest after, or at the end of routes
+// without it
+func addToRoutes(routesPath, key, after string) error {
+	return rewriteRoutes(routesPath, func(route []string) []string {
+		for i, routeKey := range route {
+			if after != "" && strings.TrimSpace(routeKey) == after {
+				return append(route[:i+1], append([]string{key}, route[i+1:]...)...)
+			}
+		}
+		return append(route, key)
+	})
+}
+
+// rewriteRoutes changes the quest keys of every route of the explicit routes
+// file, if there is one
+func rewriteRoutes(routesPath string, change func(route []string) []string) error {
+	records, err := readCSV(routesPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(routesPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.UseCRLF = true
+	writer.Write([]string{"TeamName", "Route"})
+	for _, record := range records {
+		route := change(append([]string{}, record[1:]...))
+		writer.Write(append(record[:1:1], route...))
+	}
+	writer.Flush()
+	return writer.Error()
+}
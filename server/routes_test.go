@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEditRoutesFile(t *testing.T) {
+	routesPath := filepath.Join(t.TempDir(), "routes.csv")
+	os.WriteFile(routesPath, []byte("TeamName,Route\r\nTEAM1,q1,q2,q3\r\nTEAM2,q3,q1\r\n"), 0644)
+
+	addToRoutes(routesPath, "q4", "")
+	addToRoutes(routesPath, "q5", "q1")
+	removeFromRoutes(routesPath, "q3")
+
+	definitions := []QuestDefinition{{Key: "q1"}, {Key: "q2"}, {Key: "q3"}, {Key: "q4"}, {Key: "q5"}}
+	routes, err := readExplicitRoutes(routesPath, definitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"TEAM1": {"q1", "q5", "q2", "q4"},
+		"TEAM2": {"q1", "q5", "q4"},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("routes = %v, want %v", routes, want)
+	}
+}
+
+func TestEditRoutesWithoutFile(t *testing.T) {
+	routesPath := filepath.Join(t.TempDir(), "routes.csv")
+	if err := addToRoutes(routesPath, "q1", ""); err != nil {
+		t.Errorf("addToRoutes: %v", err)
+	}
+	if _, err := os.Stat(routesPath); !os.IsNotExist(err) {
+		t.Errorf("routes file created: %v", err)
+	}
+}
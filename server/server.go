@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// worker is a background job run at a fixed interval while the server runs
+type worker struct {
+	name     string
+	interval time.Duration
+	run      func()
+}
+
+// startWorkers runs the workers until the context is done. The wait group
+// is done once every worker returned from its last run.
+func startWorkers(ctx context.Context, workers []worker) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w worker) {
+			defer wg.Done()
+			ticker := time.NewTicker(w.interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					w.run()
+				}
+			}
+		}(w)
+	}
+	return &wg
+}
+
+// serve runs the web server and the background workers until SIGINT or
+// SIGTERM. It then stops accepting requests, lets the ones in flight finish,
+// stops the workers and closes the logs and the database.
+func serve(handler http.Handler, workers []worker) error {
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	running := startWorkers(workerCtx, workers)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("Server is running on port %d\n", cfg.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-serverErr:
+		// The server could not start, e.g. the port is taken
+	case <-ctx.Done():
+		stop() // A second signal kills the server right away
+		shuttingDown.Store(true)
+		fmt.Println("Shutting down, waiting for the requests in flight...")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err = server.Shutdown(shutdownCtx); err != nil {
+			err = fmt.Errorf("shutdown: %v", err)
+			server.Close()
+		}
+	}
+
+	stopWorkers()
+	running.Wait()
+
+	if closeErr := events.close(); closeErr != nil {
+		log.Printf("Error closing log file: %v", closeErr)
+	}
+	if closeErr := db.Close(); closeErr != nil {
+		log.Printf("Error closing database: %v", closeErr)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	if err == nil {
+		fmt.Println("Server stopped")
+	}
+	return err
+}
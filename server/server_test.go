@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// freePort returns a port nothing listens on
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestServeShutdown(t *testing.T) {
+	// serve closes the database and the event log, so it gets its own
+	dir := t.TempDir()
+	testDB, err := gorm.Open("sqlite3", filepath.Join(dir, "shutdown.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEvents := &eventLog{}
+	if err := testEvents.open(filepath.Join(dir, "events.log"), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	oldCfg, oldDB, oldEvents := cfg, db, events
+	cfg, db, events = config{
+		Port:            freePort(t),
+		ReadTimeout:     time.Minute,
+		WriteTimeout:    time.Minute,
+		IdleTimeout:     time.Minute,
+		ShutdownTimeout: 10 * time.Second,
+	}, testDB, testEvents
+	t.Cleanup(func() {
+		cfg, db, events = oldCfg, oldDB, oldEvents
+		shuttingDown.Store(false)
+	})
+
+	// The worker checks that the database is still open on every run
+	var runs atomic.Int32
+	var workerErr atomic.Value
+	workers := []worker{{"check", 10 * time.Millisecond, func() {
+		time.Sleep(20 * time.Millisecond)
+		if err := testDB.DB().Ping(); err != nil {
+			workerErr.Store(err)
+		}
+		runs.Add(1)
+	}}}
+
+	started, release := make(chan bool), make(chan bool)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		fmt.Fprint(w, "done")
+	})
+
+	served := make(chan error, 1)
+	go func() { served <- serve(mux, workers) }()
+
+	base := fmt.Sprintf("http://127.0.0.1:%d", cfg.Port)
+	for i := 0; ; i++ {
+		if response, err := http.Get(base + "/ping"); err == nil {
+			response.Body.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatal("the server didn't start")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// A request is in flight when the signal comes
+	slow := make(chan int, 1)
+	go func() {
+		response, err := http.Get(base + "/slow")
+		if err != nil {
+			slow <- 0
+			return
+		}
+		response.Body.Close()
+		slow <- response.StatusCode
+	}()
+	<-started
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; !shuttingDown.Load(); i++ {
+		if i == 100 {
+			t.Fatal("the signal didn't start the shutdown")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// No new requests are taken, but the server waits for the one in flight
+	time.Sleep(50 * time.Millisecond)
+	if _, err := http.Get(base + "/ping"); err == nil {
+		t.Error("a new request is served during the shutdown")
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned with a request in flight: %v", err)
+	default:
+	}
+
+	close(release)
+	if status := <-slow; status != http.StatusOK {
+		t.Errorf("request in flight = %d, want 200", status)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve didn't return")
+	}
+
+	// The workers stopped before the database was closed
+	if err, _ := workerErr.Load().(error); err != nil {
+		t.Errorf("a worker ran after the database was closed: %v", err)
+	}
+	if runs.Load() == 0 {
+		t.Error("the worker never ran")
+	}
+	stopped := runs.Load()
+	time.Sleep(50 * time.Millisecond)
+	if runs.Load() != stopped {
+		t.Error("the worker still runs after serve returned")
+	}
+
+	if testDB.DB().Ping() == nil {
+		t.Error("the database is still open")
+	}
+	testEvents.mu.Lock()
+	closed := testEvents.file == nil
+	testEvents.mu.Unlock()
+	if !closed {
+		t.Error("the event log is still open")
+	}
+}
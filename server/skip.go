@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// SkipPolicy controls when and how often a team may skip a quest
+type SkipPolicy struct {
+	MaxSkips    int           // Maximum skips per team for the whole game, 0 means unlimited
+	UnlockAfter time.Duration // Time a team must spend on a quest before it can be skipped
+	Penalty     int           // Points deducted for every skipped quest
+}
+
+// Points awarded for the game result, as described in the rules on the login
+// page. Games can set their own.
+const (
+	pointsPerQuest = 5
+	pointsPerHint  = 1
+)
+
+// loadSkipPolicy reads the skip policy from the environment variables
+// SKIP_MAX, SKIP_UNLOCK_AFTER and SKIP_PENALTY
+func loadSkipPolicy() SkipPolicy {
+	return SkipPolicy{
+		MaxSkips:    parseInt(os.Getenv("SKIP_MAX")),
+		UnlockAfter: parseDuration(os.Getenv("SKIP_UNLOCK_AFTER")),
+		Penalty:     parseInt(os.Getenv("SKIP_PENALTY")),
+	}
+}
+
+// skipsUsed returns the number of quests the team has skipped so far
+func skipsUsed(teamName string) int {
+	var count int
+	db.Model(&Quest{}).Where("team_name = ? AND skipped = ?", teamName, true).Count(&count)
+	return count
+}
+
+// skipsLeft returns the number of skips the team has left, or -1 if unlimited
+func (p SkipPolicy) skipsLeft(teamName string) int {
+	if p.MaxSkips <= 0 {
+		return -1
+	}
+	left := p.MaxSkips - skipsUsed(teamName)
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// unlockTime returns the moment the quest becomes skippable
+func (p SkipPolicy) unlockTime(quest Quest) time.Time {
+	if quest.StartedAt.IsZero() {
+		return time.Now().Add(p.UnlockAfter)
+	}
+	return quest.StartedAt.Add(p.UnlockAfter)
+}
+
+// skipPolicyOf returns the skip policy of the team's game
+func skipPolicyOf(teamName string) SkipPolicy {
+	return teamGame(teamName).Skips
+}
+
+// setSkipState fills in the skip button state of the quest page
+func (data *questPage) setSkipState(teamName string, quest Quest) {
+	policy := skipPolicyOf(teamName)
+	data.SkipsLeft = policy.skipsLeft(teamName)
+	data.SkipAllowed = !quest.Completed && data.SkipsLeft != 0
+	if policy.UnlockAfter > 0 {
+		data.SkipUnlockAt = policy.unlockTime(quest).Format(time.RFC3339)
+	}
+}
+
+// teamScore calculates the points of a team from its quests with the
+// scoring rules of its game
+func teamScore(teamName string) int {
+	var quests []Quest
+	db.Where("team_name = ?", teamName).Find(&quests)
+
+	game := teamGame(teamName)
+	score := 0
+	for _, quest := range quests {
+		score -= quest.HintsUsed * game.PointsPerHint
+		if quest.Skipped {
+			score -= game.Skips.Penalty
+		} else if quest.solved() {
+			score += game.PointsPerQuest
+		}
+	}
+	return score
+}
+
+// handleSkip skips the team's current quest if the skip policy allows it
+func handleSkip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	cookie, err := r.Cookie("logged_in_team")
+	if err != nil {
+		// http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	teamName := cookie.Value
+
+	mu.Lock()
+	team, ok := teams[teamName]
+	gameFinished := ok && team.GameFinished
+	mu.Unlock()
+
+	if !ok || gameFinished {
+		http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s", teamName), http.StatusSeeOther)
+		return
+	}
+
+	// Retrieve the quest from the database using the quest_id and team_name
+	quest, err := teamQuest(teamName, r.FormValue("quest_id"))
+	if err != nil {
+		log.Printf("Quest not found: %v", err)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if err := skipQuest(r, teamName, &quest); err != nil {
+		switch err.(*actionError).Code {
+		case "skip_limit":
+			http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s&skipError=limit", teamName), http.StatusSeeOther)
+		case "skip_locked":
+			http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s&skipError=locked", teamName), http.StatusSeeOther)
+		default:
+			http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s", teamName), http.StatusSeeOther)
+		}
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/treasurehunt?team=%s&skipped=true", teamName), http.StatusSeeOther)
+}
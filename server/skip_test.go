@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// teamSkip posts the skip form of a team and returns where it redirects to
+func teamSkip(handler http.Handler, teamName string, quest Quest) string {
+	form := url.Values{"quest_id": {fmt.Sprint(quest.ID)}}
+	r := httptest.NewRequest(http.MethodPost, "/skip", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: "logged_in_team", Value: teamName})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Header().Get("Location")
+}
+
+// startedQuest adds a quest the team started some time ago
+func startedQuest(t *testing.T, teamName string, number int, ago time.Duration) Quest {
+	t.Helper()
+	quest := addTestQuest(t, teamName, number, QuestDefinition{Key: "skip", Text: "Skip me", CorrectAnswers: "x", Hint: "y"})
+	quest.StartedAt = time.Now().Add(-ago)
+	db.Save(&quest)
+	return quest
+}
+
+func TestSkipLimit(t *testing.T) {
+	handler := newTestServer(t)
+	const teamName = "SKIPLIMIT"
+	addTestTeam(t, teamName)
+	changeTestGame(t, func(game *Game) { game.Skips = SkipPolicy{MaxSkips: 1} })
+	first := startedQuest(t, teamName, 1, time.Hour)
+	second := startedQuest(t, teamName, 2, time.Hour)
+
+	if to := teamSkip(handler, teamName, first); !strings.HasSuffix(to, "skipped=true") {
+		t.Fatalf("first skip redirects to %s", to)
+	}
+	if left := skipPolicyOf(teamName).skipsLeft(teamName); left != 0 {
+		t.Errorf("skips left %d, want 0", left)
+	}
+	if to := teamSkip(handler, teamName, second); !strings.HasSuffix(to, "skipError=limit") {
+		t.Errorf("skip over the limit redirects to %s", to)
+	}
+
+	var stored Quest
+	db.First(&stored, second.ID)
+	if stored.Skipped || stored.Completed {
+		t.Error("the quest over the limit was skipped")
+	}
+}
+
+func TestSkipUnlockDelay(t *testing.T) {
+	handler := newTestServer(t)
+	const teamName = "SKIPDELAY"
+	addTestTeam(t, teamName)
+	changeTestGame(t, func(game *Game) { game.Skips = SkipPolicy{UnlockAfter: 10 * time.Minute} })
+
+	quest := startedQuest(t, teamName, 1, 9*time.Minute)
+	if to := teamSkip(handler, teamName, quest); !strings.HasSuffix(to, "skipError=locked") {
+		t.Errorf("skip before the delay redirects to %s", to)
+	}
+
+	quest.StartedAt = time.Now().Add(-11 * time.Minute)
+	db.Save(&quest)
+	if to := teamSkip(handler, teamName, quest); !strings.HasSuffix(to, "skipped=true") {
+		t.Errorf("skip after the delay redirects to %s", to)
+	}
+	if to := teamSkip(handler, teamName, quest); strings.HasSuffix(to, "skipped=true") {
+		t.Error("a skipped quest was skipped again")
+	}
+}
+
+func TestSkipPenaltyScore(t *testing.T) {
+	handler := newTestServer(t)
+	const teamName = "SKIPSCORE"
+	addTestTeam(t, teamName)
+	changeTestGame(t, func(game *Game) {
+		game.Skips = SkipPolicy{Penalty: 5}
+		game.PointsPerQuest = 10
+		game.PointsPerHint = 2
+	})
+	skipped := startedQuest(t, teamName, 1, time.Hour)
+	answered := startedQuest(t, teamName, 2, time.Hour)
+
+	if to := teamSkip(handler, teamName, skipped); !strings.HasSuffix(to, "skipped=true") {
+		t.Fatalf("skip redirects to %s", to)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/submit", nil)
+	if err := revealHint(r, teamName, &answered); err != nil {
+		t.Fatal(err)
+	}
+	if !answerQuest(r, teamName, &answered, "x", nil) {
+		t.Fatal("the answer wasn't accepted")
+	}
+
+	// 10 for the answered quest, 2 off for its hint and 5 off for the skip
+	summary := summarize(teamName)
+	if summary.Score != 3 || summary.Skips != 1 || summary.Completed != 1 || summary.Hints != 1 {
+		t.Errorf("summary %+v, want score 3 with a skip, a hint and a completed quest", summary)
+	}
+}
+
+func TestSkipAfterTheGameIsRefused(t *testing.T) {
+	handler := newTestServer(t)
+	const teamName = "SKIPFINISHED"
+	addTestTeam(t, teamName)
+	quest := startedQuest(t, teamName, 1, time.Hour)
+
+	mu.Lock()
+	teams[teamName].GameFinished = true
+	mu.Unlock()
+	if to := teamSkip(handler, teamName, quest); strings.Contains(to, "skipped=true") {
+		t.Error("a team skipped a quest after its game")
+	}
+	var stored Quest
+	db.First(&stored, quest.ID)
+	if stored.Skipped {
+		t.Error("the quest was skipped")
+	}
+}
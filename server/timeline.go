@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GameEvent is an event of the event log kept in the database, so the
+// history of a game can be queried by team, quest and type
+type GameEvent struct {
+	gorm.Model
+	Time        time.Time `gorm:"index"`
+	Type        string    `gorm:"index"`
+	Game        string    `gorm:"index"`
+	TeamName    string    `gorm:"index"`
+	QuestNumber int
+	QuestKey    string
+	RequestID   string
+	Details     string // JSON object
+	Run         string `gorm:"index"` // Start of the server that recorded it, see currentRun
+}
+
+// currentRun names the run of the server: the quests are seeded again on
+// every start, so the progress in the database only matches the events of
+// the current run. Earlier runs stay in the table for disputes.
+var currentRun string
+
+// latestRun returns the run of the newest event, for the commands that read
+// the database of a server that has stopped
+func latestRun() string {
+	var runs []string
+	db.Model(&GameEvent{}).Order("id desc").Limit(1).Pluck("run", &runs)
+	if len(runs) == 0 {
+		return ""
+	}
+	return runs[0]
+}
+
+// saveGameEvent stores an event in the database
+func saveGameEvent(e event) {
+	record := GameEvent{
+		Time:        e.Time,
+		Type:        e.Type,
+		Game:        e.Game,
+		TeamName:    e.Team,
+		QuestNumber: e.Quest,
+		QuestKey:    e.QuestKey,
+		RequestID:   e.RequestID,
+		Run:         currentRun,
+	}
+	if len(e.Details) > 0 {
+		details, _ := json.Marshal(e.Details)
+		record.Details = string(details)
+	}
+	if err := db.Create(&record).Error; err != nil {
+		log.Printf("Error saving event: %v", err)
+	}
+}
+
+// details decodes the details of an event
+func (e GameEvent) details() map[string]string {
+	details := map[string]string{}
+	if e.Details != "" {
+		json.Unmarshal([]byte(e.Details), &details)
+	}
+	return details
+}
+
+// Names of the event types for the organizers
+var eventLabels = map[string]string{
+	eventLogin:         "Вход",
+	eventLoginFailed:   "Неуспешен вход",
+	eventQuestStart:    "Начало на задача",
+	eventQuestChosen:   "Избрана задача",
+	eventHint:          "Подсказка",
+	eventSkip:          "Пропусната задача",
+	eventComplete:      "Решена задача",
+	eventWrongAnswer:   "Грешен отговор",
+	eventUpload:        "Качена снимка",
+	eventLateAnswer:    "Закъснял отговор",
+	eventTimerSkip:     "Пропусната след таймера",
+	eventTimerFail:     "Провалена след таймера",
+	eventCheckIn:       "Отбелязване на място",
+	eventBadCheckpoint: "Невалиден код",
+	eventGameFinished:  "Край на играта",
+	eventAdmin:         "Действие на организатор",
+
+	eventAnswerRejected: "Отхвърлен отговор",
+}
+
+// Events that close a quest, shown with the time the quest took
+var questEndEvents = map[string]bool{
+	eventComplete:  true,
+	eventSkip:      true,
+	eventTimerSkip: true,
+	eventTimerFail: true,
+}
+
+// timelineEntry is a row of the timeline of a team
+type timelineEntry struct {
+	Time      string
+	Elapsed   string // Since the first event of the team
+	Type      string
+	Label     string
+	Quest     int
+	QuestKey  string
+	Details   string
+	Duration  string // Time the quest took, on the events that close it
+	RequestID string
+}
+
+// timelineQuest sums up a quest of a team
+type timelineQuest struct {
+	Number   int
+	Key      string
+	Started  string
+	Finished string
+	Duration string
+	Result   string
+	Hints    int
+	Wrong    int
+}
+
+// formatDuration writes a duration rounded to the second, e.g. 1h02m05s
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d < 0 {
+		d = 0
+	}
+	return d.String()
+}
+
+// handleAdminTimeline shows the events of a team of the organizer's game in
+// order, with the time every quest took. The type parameter narrows the
+// events to one type.
+func handleAdminTimeline(w http.ResponseWriter, r *http.Request) {
+	teamName := r.URL.Query().Get("team")
+	eventType := r.URL.Query().Get("type")
+	run := currentRun
+	if r.URL.Query().Has("run") {
+		run = r.URL.Query().Get("run")
+	}
+
+	game := adminGame(r)
+	if teamGames[teamName] != game.Key {
+		teamName = ""
+	}
+
+	data := struct {
+		GameSwitcher gameSwitcher
+		Teams        []string
+		Team         string
+		Type         string
+		Types        []timelineType
+		Run          string
+		Runs         []string // Newest first
+		Entries      []timelineEntry
+		Quests       []timelineQuest
+	}{
+		GameSwitcher: newGameSwitcher(r),
+		Teams:        gameTeamNames(game.Key),
+		Team:         teamName,
+		Type:         eventType,
+		Types:        timelineTypes(),
+		Run:          run,
+	}
+	db.Model(&GameEvent{}).Where("team_name IN (?)", data.Teams).Order("run desc").Pluck("DISTINCT run", &data.Runs)
+
+	if teamName != "" {
+		var records []GameEvent
+		db.Where("team_name = ? AND run = ?", teamName, run).Order("time, id").Find(&records)
+		data.Entries, data.Quests = buildTimeline(teamName, records, eventType)
+	}
+
+	if err := templates.ExecuteTemplate(w, "admin_timeline.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// timelineType is an event type offered in the filter of the timeline
+type timelineType struct {
+	Type  string
+	Label string
+}
+
+// timelineTypes lists the event types by name
+func timelineTypes() []timelineType {
+	var types []timelineType
+	for eventType, label := range eventLabels {
+		types = append(types, timelineType{Type: eventType, Label: label})
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Label < types[j].Label })
+	return types
+}
+
+// buildTimeline turns the events of a team into the rows of the timeline and
+// the summary of its quests
+func buildTimeline(teamName string, records []GameEvent, eventType string) ([]timelineEntry, []timelineQuest) {
+	// Quests the team never reached have no events, the summary lists them too
+	var quests []Quest
+	db.Preload("Definition").Where("team_name = ?", teamName).Order("quest_number").Find(&quests)
+
+	summaries := map[int]*timelineQuest{}
+	starts := map[int]time.Time{}
+	for _, quest := range quests {
+		summaries[quest.QuestNumber] = &timelineQuest{Number: quest.QuestNumber, Key: quest.Definition.Key, Hints: quest.HintsUsed}
+		if !quest.StartedAt.IsZero() {
+			starts[quest.QuestNumber] = quest.StartedAt
+		}
+	}
+
+	var entries []timelineEntry
+	var first time.Time
+	for _, record := range records {
+		if first.IsZero() {
+			first = record.Time
+		}
+
+		entry := timelineEntry{
+			Time:      record.Time.Format("15:04:05"),
+			Elapsed:   formatDuration(record.Time.Sub(first)),
+			Type:      record.Type,
+			Label:     eventLabels[record.Type],
+			Quest:     record.QuestNumber,
+			QuestKey:  record.QuestKey,
+			Details:   formatDetails(record.details()),
+			RequestID: record.RequestID,
+		}
+		if entry.Label == "" {
+			entry.Label = record.Type
+		}
+
+		summary := summaries[record.QuestNumber]
+		switch {
+		case record.Type == eventQuestStart:
+			starts[record.QuestNumber] = record.Time
+		case record.Type == eventWrongAnswer && summary != nil:
+			summary.Wrong++
+		case questEndEvents[record.Type]:
+			if start, ok := starts[record.QuestNumber]; ok {
+				entry.Duration = formatDuration(record.Time.Sub(start))
+			}
+			if summary != nil {
+				summary.Finished = entry.Time
+				summary.Duration = entry.Duration
+				summary.Result = entry.Label
+			}
+		}
+
+		if eventType == "" || eventType == record.Type {
+			entries = append(entries, entry)
+		}
+	}
+
+	var summary []timelineQuest
+	for _, quest := range quests {
+		row := summaries[quest.QuestNumber]
+		if start, ok := starts[quest.QuestNumber]; ok {
+			row.Started = start.Format("15:04:05")
+		}
+		summary = append(summary, *row)
+	}
+	return entries, summary
+}
+
+// formatDetails writes the details of an event as key: value pairs
+func formatDetails(details map[string]string) string {
+	keys := make([]string, 0, len(details))
+	for key, value := range details {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key + ": " + details[key]
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The events of earlier runs are kept, but the dashboards only use the
+// current run unless the organizer picks another
+func TestEventsOfEarlierRunsAreKept(t *testing.T) {
+	handler := newTestServer(t)
+	const teamName = "TEAM4"
+	defer func(run string) { currentRun = run }(currentRun)
+
+	definition := QuestDefinition{Game: defaultGameKey, Key: "run-quest"}
+	db.Create(&definition)
+	db.Create(&Quest{TeamName: teamName, QuestNumber: 1, DefinitionID: definition.ID})
+
+	currentRun = "2024-05-18 09:00:00"
+	saveGameEvent(event{Time: time.Now(), Type: eventWrongAnswer, Game: defaultGameKey, Team: teamName, Quest: 1, Details: map[string]string{"answer": "earlier-run-answer"}})
+	currentRun = "2024-05-19 09:00:00"
+	saveGameEvent(event{Time: time.Now(), Type: eventWrongAnswer, Game: defaultGameKey, Team: teamName, Quest: 1, Details: map[string]string{"answer": "current-run-answer"}})
+
+	timeline := func(query string) string {
+		r := httptest.NewRequest(http.MethodGet, "/admin/timeline?team="+teamName+query, nil)
+		r.SetBasicAuth(testAdminUser, testAdminPass)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("timeline%s: status %d", query, w.Code)
+		}
+		return w.Body.String()
+	}
+
+	if body := timeline(""); !strings.Contains(body, "current-run-answer") || strings.Contains(body, "earlier-run-answer") {
+		t.Errorf("timeline of the current run shows the wrong events")
+	}
+	if body := timeline("&run=2024-05-18+09:00:00"); !strings.Contains(body, "earlier-run-answer") || strings.Contains(body, "current-run-answer") {
+		t.Errorf("timeline of the earlier run shows the wrong events")
+	}
+
+	found := false
+	for _, team := range collectResults(teamGame(teamName)).Teams {
+		for _, quest := range team.Quests {
+			if team.Team == teamName && quest.Key == "run-quest" {
+				found = true
+				if quest.WrongAnswers != 1 {
+					t.Errorf("results count %d wrong answers, want only the one of the current run", quest.WrongAnswers)
+				}
+			}
+		}
+	}
+	if !found {
+		t.Errorf("quest missing from the results")
+	}
+}
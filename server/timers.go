@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Quest timer modes, set per quest in the QuestTimerMode column of the CSV
+const (
+	timerModeWait    = "wait"    // The quest can't be answered until the timer ends
+	timerModeSkip    = "skip"    // The quest is closed as timed out when the timer ends, without using a skip
+	timerModeFail    = "fail"    // The quest is failed automatically when the timer ends
+	timerModePenalty = "penalty" // The quest can be answered late, but costs the team time
+)
+
+// loadLateAnswerPenalty reads the QUEST_LATE_PENALTY environment variable
+func loadLateAnswerPenalty() time.Duration {
+	return parseDuration(os.Getenv("QUEST_LATE_PENALTY"))
+}
+
+// parseTimerMode normalizes the timer mode from the CSV, defaulting to wait
+func parseTimerMode(value string) string {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case timerModeSkip, timerModeFail, timerModePenalty:
+		return mode
+	default:
+		return timerModeWait
+	}
+}
+
+// timerMode returns the timer mode of the quest
+func (quest Quest) timerMode() string {
+	return parseTimerMode(quest.Definition.QuestTimerMode)
+}
+
+// isDeadline reports whether the quest timer is a deadline rather than a wait timer
+func (quest Quest) isDeadline() bool {
+	return quest.Definition.QuestTimerRequired && quest.timerMode() != timerModeWait
+}
+
+// blocksAnswers reports whether the quest can't be answered yet because of its wait timer
+func (quest Quest) blocksAnswers() bool {
+	return quest.Definition.QuestTimerRequired && quest.timerMode() == timerModeWait && time.Now().Before(quest.QuestTimerEndTime)
+}
+
+// deadlinePassed reports whether the quest's deadline timer has run out
+func (quest Quest) deadlinePassed() bool {
+	return quest.isDeadline() && quest.QuestTimerRunning && !time.Now().Before(quest.QuestTimerEndTime)
+}
+
+// expireQuest applies the timer mode of a quest whose timer has run out.
+// It reports whether the quest was closed by the expiry. The quest may be a
+// stale copy, so only the timer columns are written, and the quest is closed
+// only if no answer completed it in the meantime.
+func expireQuest(r *http.Request, quest *Quest) bool {
+	quest.QuestTimerRunning = false
+	quest.QuestTimerFinished = true
+	db.Model(&Quest{}).Where("id = ?", quest.ID).Updates(map[string]interface{}{
+		"quest_timer_running":  false,
+		"quest_timer_finished": true,
+	})
+
+	var column, eventType string
+	switch quest.timerMode() {
+	case timerModeSkip:
+		column, eventType = "timed_out", eventTimerSkip
+	case timerModeFail:
+		column, eventType = "failed", eventTimerFail
+	default:
+		return false
+	}
+	if quest.Completed {
+		return false
+	}
+
+	result := db.Model(&Quest{}).Where("id = ? AND completed = ?", quest.ID, false).Updates(map[string]interface{}{
+		column:      true,
+		"completed": true,
+	})
+	closed := result.Error == nil && result.RowsAffected == 1
+
+	// Either way the row is newer than the copy, e.g. answered by the team
+	// just before the timer ran out
+	db.Preload("Definition").First(quest, quest.ID)
+	if closed {
+		logEvent(questEvent(r, eventType, *quest))
+	}
+	return closed
+}
+
+// applyLatePenalty marks a quest answered after its soft deadline and takes the
+// penalty from the team's remaining game time
+func applyLatePenalty(r *http.Request, teamName string, quest *Quest) {
+	if quest.timerMode() != timerModePenalty || !quest.Definition.QuestTimerRequired || time.Now().Before(quest.QuestTimerEndTime) {
+		return
+	}
+
+	quest.Late = true
+
+	// The late penalty is a scoring rule of the team's game
+	penalty := teamGame(teamName).LatePenalty
+	mu.Lock()
+	if team, ok := teams[teamName]; ok {
+		team.Stopwatch = team.Stopwatch.Add(-penalty)
+	}
+	mu.Unlock()
+
+	late := questEvent(r, eventLateAnswer, *quest)
+	late.Details = map[string]string{"penalty": penalty.String()}
+	logEvent(late)
+}
+
+// enforceQuestTimers expires every running quest timer that has run out, so
+// deadlines apply even when the team doesn't have the page open
+func enforceQuestTimers() {
+	var quests []Quest
+	db.Preload("Definition").Where("quest_timer_running = ?", true).Find(&quests)
+
+	for i := range quests {
+		if !time.Now().Before(quests[i].QuestTimerEndTime) {
+			expireQuest(nil, &quests[i])
+		}
+	}
+}
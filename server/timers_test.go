@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// addTimedQuest adds a quest whose timer of the given mode ran out a minute
+// ago, or runs for another hour
+func addTimedQuest(t *testing.T, teamName, mode string, expired bool) Quest {
+	t.Helper()
+	quest := addTestQuest(t, teamName, 1, QuestDefinition{
+		Key:                mode,
+		Text:               "Timed quest",
+		CorrectAnswers:     "bell",
+		QuestTimerRequired: true,
+		QuestTimerDuration: time.Hour,
+		QuestTimerMode:     mode,
+	})
+	quest.StartedAt = time.Now().Add(-time.Hour)
+	quest.QuestTimerRunning = true
+	quest.QuestTimerEndTime = time.Now().Add(time.Hour)
+	if expired {
+		quest.QuestTimerEndTime = time.Now().Add(-time.Minute)
+	}
+	db.Save(&quest)
+	return quest
+}
+
+func TestWaitTimerBlocksAnswers(t *testing.T) {
+	newTestServer(t)
+	const teamName = "TIMERWAIT"
+	addTestTeam(t, teamName)
+	quest := addTimedQuest(t, teamName, timerModeWait, false)
+	r := httptest.NewRequest(http.MethodPost, "/submit", nil)
+
+	if err := canAnswer(r, teamName, &quest); err == nil || err.(*actionError).Code != "wait_quest_timer" {
+		t.Fatalf("answer during the wait timer: %v, want wait_quest_timer", err)
+	}
+
+	quest.QuestTimerEndTime = time.Now().Add(-time.Minute)
+	if err := canAnswer(r, teamName, &quest); err != nil {
+		t.Fatalf("answer after the wait timer: %v", err)
+	}
+	if quest.deadlinePassed() || expireQuest(r, &quest) {
+		t.Error("a wait timer closed the quest")
+	}
+}
+
+func TestSkipTimerTimesOutWithoutUsingASkip(t *testing.T) {
+	newTestServer(t)
+	const teamName = "TIMERSKIP"
+	addTestTeam(t, teamName)
+	changeTestGame(t, func(game *Game) {
+		game.Skips = SkipPolicy{MaxSkips: 1, Penalty: 5}
+		game.PointsPerQuest = 10
+		game.PointsPerHint = 0
+	})
+	quest := addTimedQuest(t, teamName, timerModeSkip, true)
+
+	enforceQuestTimers()
+
+	var stored Quest
+	db.First(&stored, quest.ID)
+	if !stored.Completed || !stored.TimedOut || stored.Skipped || stored.QuestTimerRunning {
+		t.Fatalf("quest after its skip timer: %+v", stored)
+	}
+	if questResultName(stored) != "timed_out" {
+		t.Errorf("result %q, want timed_out", questResultName(stored))
+	}
+	if left := skipPolicyOf(teamName).skipsLeft(teamName); left != 1 {
+		t.Errorf("skips left %d, want 1: the timeout used the skip", left)
+	}
+	summary := summarize(teamName)
+	if summary.Score != 0 || summary.Skips != 0 || summary.Completed != 0 {
+		t.Errorf("summary %+v, want no score, skip or completed quest", summary)
+	}
+}
+
+func TestFailTimerFailsTheQuest(t *testing.T) {
+	newTestServer(t)
+	const teamName = "TIMERFAIL"
+	addTestTeam(t, teamName)
+	quest := addTimedQuest(t, teamName, timerModeFail, true)
+	r := httptest.NewRequest(http.MethodPost, "/submit", nil)
+
+	if err := canAnswer(r, teamName, &quest); err == nil || err.(*actionError).Code != "expired_fail" {
+		t.Fatalf("answer after the deadline: %v, want expired_fail", err)
+	}
+	if !quest.Failed || !quest.Completed || quest.TimedOut || quest.Skipped {
+		t.Errorf("quest after its fail timer: %+v", quest)
+	}
+	if expireQuest(r, &quest) {
+		t.Error("the quest was closed twice")
+	}
+}
+
+func TestPenaltyTimerTakesTime(t *testing.T) {
+	newTestServer(t)
+	const teamName = "TIMERPENALTY"
+	addTestTeam(t, teamName)
+	changeTestGame(t, func(game *Game) { game.LatePenalty = 10 * time.Minute })
+	quest := addTimedQuest(t, teamName, timerModePenalty, true)
+	r := httptest.NewRequest(http.MethodPost, "/submit", nil)
+
+	mu.Lock()
+	before := teams[teamName].Stopwatch
+	mu.Unlock()
+
+	if err := canAnswer(r, teamName, &quest); err != nil {
+		t.Fatalf("late answer refused: %v", err)
+	}
+	if !answerQuest(r, teamName, &quest, "bell", nil) {
+		t.Fatal("the late answer wasn't accepted")
+	}
+
+	var stored Quest
+	db.First(&stored, quest.ID)
+	if !stored.Completed || !stored.Late || stored.Answer != "bell" {
+		t.Errorf("quest after the late answer: %+v", stored)
+	}
+	mu.Lock()
+	taken := before.Sub(teams[teamName].Stopwatch)
+	mu.Unlock()
+	if taken != 10*time.Minute {
+		t.Errorf("penalty %v, want 10m", taken)
+	}
+}
+
+// An answer checked on a copy loaded before the timer closed the quest must
+// not reopen or complete it
+func TestAnswerDoesNotOverwriteExpiry(t *testing.T) {
+	newTestServer(t)
+	const teamName = "TIMERRACE"
+	addTestTeam(t, teamName)
+	quest := addTimedQuest(t, teamName, timerModeSkip, true)
+	r := httptest.NewRequest(http.MethodPost, "/submit", nil)
+
+	stale := quest
+	enforceQuestTimers()
+
+	if answerQuest(r, teamName, &stale, "bell", nil) {
+		t.Error("the answer completed a quest the timer had closed")
+	}
+	var stored Quest
+	db.First(&stored, quest.ID)
+	if !stored.TimedOut || stored.Answer != "" || stored.QuestTimerRunning {
+		t.Errorf("quest after the stale answer: %+v", stored)
+	}
+
+	// The other way round, the timer leaves an answered quest alone
+	answered := addTestQuest(t, teamName, 2, QuestDefinition{Key: "answered", CorrectAnswers: "bell", QuestTimerRequired: true, QuestTimerMode: timerModeFail})
+	staleTimer := answered
+	if !answerQuest(r, teamName, &answered, "bell", nil) {
+		t.Fatal("the answer wasn't accepted")
+	}
+	if expireQuest(r, &staleTimer) || staleTimer.Failed || !staleTimer.Completed {
+		t.Errorf("the timer closed an answered quest: %+v", staleTimer)
+	}
+}
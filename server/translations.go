@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// QuestTranslation is the text and hint of a quest in another language,
+// stored in data/translations.csv next to the catalog. Empty fields fall
+// back to the catalog.
+type QuestTranslation struct {
+	gorm.Model
+	DefinitionID uint
+	Language     string
+	Text         string
+	Hint         string
+}
+
+// Columns of the quest translations, in order
+var questTranslationsHeader = []string{"Key", "Language", "Text", "Hint"}
+
+// loadQuestTranslations reads the translations of the quests by quest key.
+// The file is optional.
+func loadQuestTranslations(filePath string) (map[string][]QuestTranslation, error) {
+	records, err := readCSV(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	translations := map[string][]QuestTranslation{}
+	for _, record := range records {
+		key := strings.TrimSpace(optionalField(record, 0))
+		language := strings.ToLower(strings.TrimSpace(optionalField(record, 1)))
+		if key == "" || language == "" {
+			continue
+		}
+
+		translations[key] = append(translations[key], QuestTranslation{
+			Language: language,
+			Text:     strings.ReplaceAll(optionalField(record, 2), `\n`, "\n"),
+			Hint:     strings.ReplaceAll(optionalField(record, 3), `\n`, "\n"),
+		})
+	}
+	return translations, nil
+}
+
+// writeQuestTranslations writes the translations of the quests in catalog order
+func writeQuestTranslations(filePath string, definitions []QuestDefinition) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	escape := strings.NewReplacer("\r\n", `\n`, "\n", `\n`, "\r", "")
+
+	writer := csv.NewWriter(file)
+	writer.UseCRLF = true
+	writer.Write(questTranslationsHeader)
+	for _, definition := range definitions {
+		for _, translation := range definitionTranslations(definition.ID) {
+			writer.Write([]string{
+				definition.Key,
+				translation.Language,
+				escape.Replace(translation.Text),
+				escape.Replace(translation.Hint),
+			})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// definitionTranslations returns the translations of a quest definition
+func definitionTranslations(definitionID uint) []QuestTranslation {
+	var translations []QuestTranslation
+	db.Where("definition_id = ?", definitionID).Order("language").Find(&translations)
+	return translations
+}
+
+// translationForms lists the translations of a quest for the editor, with
+// a blank one for every language of the message catalog not translated yet
+func translationForms(definitionID uint) []QuestTranslation {
+	translations := definitionTranslations(definitionID)
+	for _, language := range languages {
+		found := language == defaultLanguage
+		for _, translation := range translations {
+			found = found || translation.Language == language
+		}
+		if !found {
+			translations = append(translations, QuestTranslation{Language: language})
+		}
+	}
+	return translations
+}
+
+// questTexts returns the text and hint of a quest in a language, falling
+// back to the catalog for the parts that aren't translated
+func questTexts(definition QuestDefinition, language string) (string, string) {
+	text, hint := definition.Text, definition.Hint
+	if language == defaultLanguage {
+		return text, hint
+	}
+
+	var translation QuestTranslation
+	if db.Where("definition_id = ? AND language = ?", definition.ID, language).First(&translation).Error != nil {
+		return text, hint
+	}
+	if translation.Text != "" {
+		text = translation.Text
+	}
+	if translation.Hint != "" && hint != "" {
+		hint = translation.Hint
+	}
+	return text, hint
+}
+
+// applyTranslationsForm replaces the translations of a quest with the ones
+// of the editor form. Languages without text and hint are dropped.
+func applyTranslationsForm(definitionID uint, languages, texts, hints []string) {
+	db.Unscoped().Where("definition_id = ?", definitionID).Delete(&QuestTranslation{})
+
+	for i, language := range languages {
+		translation := QuestTranslation{
+			DefinitionID: definitionID,
+			Language:     strings.ToLower(strings.TrimSpace(language)),
+			Text:         strings.ReplaceAll(optionalField(texts, i), "\r\n", "\n"),
+			Hint:         strings.ReplaceAll(optionalField(hints, i), "\r\n", "\n"),
+		}
+		if translation.Language == "" || strings.TrimSpace(translation.Text+translation.Hint) == "" {
+			continue
+		}
+		db.Create(&translation)
+	}
+}
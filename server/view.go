@@ -0,0 +1,160 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// questPage is the data rendered by treasurehunt.html
+type questPage struct {
+	pageText
+
+	Username            string
+	StartTime           string
+	GameDuration        int64 // Milliseconds, for the countdown
+	ElapsedTime         string
+	Quest               questView
+	SuccessMsg          string
+	ErrorMsg            string
+	SkipMsg             string
+	CurrentQuest        int
+	TotalQuests         int64
+	QuestTimerRemaining string
+	QuestTimerEndTime   string
+	HintTimerRemaining  string
+	HintTimerEndTime    string
+
+	SkipAllowed  bool
+	SkipsLeft    int
+	SkipUnlockAt string
+
+	OtherQuests []questChoice
+}
+
+// questView is the part of a quest that may be shown to a team. It never
+// carries the answers, and carries the hint only once the team revealed it.
+type questView struct {
+	ID             uint          `json:"id"`
+	Number         int           `json:"number"`
+	Text           string        `json:"text"`
+	TextHTML       template.HTML `json:"textHtml"`
+	ImageURL       string        `json:"imageUrl,omitempty"`
+	AudioURL       string        `json:"audioUrl,omitempty"`
+	Media          []mediaView   `json:"media,omitempty"`
+	HasHint        bool          `json:"hasHint"`
+	HintRevealed   bool          `json:"hintRevealed"`
+	Hint           string        `json:"hint,omitempty"`
+	HintHTML       template.HTML `json:"hintHtml,omitempty"`
+	FileRequired   bool          `json:"fileRequired"`
+	AnswerRequired bool          `json:"answerRequired"`
+	TimerMode      string        `json:"timerMode"`
+	Completed      bool          `json:"completed"`
+	Skipped        bool          `json:"skipped"`
+	Type           string        `json:"type"`
+	NeedsCheckIn   bool          `json:"needsCheckIn"`
+	Puzzle         *puzzleView   `json:"puzzle,omitempty"`
+}
+
+// contentURL resolves the images of a team's quest texts. Images of the
+// asset library get signed media URLs, web images are kept as they are.
+func contentURL(teamName string) func(src string) string {
+	return func(src string) string {
+		lower := strings.ToLower(src)
+		switch {
+		case strings.HasPrefix(src, "/static/"):
+			return mediaURL(teamName, src)
+		case strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "http://"):
+			return src
+		}
+		return ""
+	}
+}
+
+// newQuestView copies the safe fields of a quest for a team
+func newQuestView(teamName, language string, quest Quest) questView {
+	text, hint := questTexts(quest.Definition, language)
+
+	view := questView{
+		ID:       quest.ID,
+		Number:   quest.QuestNumber,
+		Text:     text,
+		TextHTML: renderMarkdown(text, contentURL(teamName)),
+		ImageURL: mediaURL(teamName, quest.Definition.ImagePath),
+		AudioURL: mediaURL(teamName, quest.Definition.AudioPath),
+		Media: mediaViews(quest.DefinitionID, func(mediaPath string) string {
+			return mediaURL(teamName, mediaPath)
+		}),
+		HasHint:        quest.Definition.Hint != "",
+		HintRevealed:   quest.Definition.Hint != "" && quest.HintsUsed > 0,
+		FileRequired:   quest.Definition.FileRequired,
+		AnswerRequired: quest.Definition.CorrectAnswers != "" && !quest.isPuzzle(),
+		TimerMode:      quest.timerMode(),
+		Completed:      quest.Completed,
+		Skipped:        quest.Skipped,
+		Type:           quest.questType(),
+		NeedsCheckIn:   quest.needsCheckIn(),
+	}
+
+	if quest.isPuzzle() {
+		view.Puzzle = newPuzzleView(teamName, quest)
+	}
+
+	if view.HintRevealed {
+		view.Hint = hint
+		view.HintHTML = renderMarkdown(hint, contentURL(teamName))
+	}
+
+	return view
+}
+
+// questChoice is another open quest the team can switch to
+type questChoice struct {
+	ID      uint   `json:"id"`
+	Number  int    `json:"number"`
+	Preview string `json:"preview"`
+}
+
+// questChoices lists the open quests other than the current one
+func questChoices(open []Quest, current Quest, language string) []questChoice {
+	var choices []questChoice
+	for _, quest := range open {
+		if quest.ID == current.ID {
+			continue
+		}
+
+		// Show the beginning of the quest text so the team knows what it chooses
+		text, _ := questTexts(quest.Definition, language)
+		preview := []rune(strings.SplitN(text, "\n", 2)[0])
+		if len(preview) > 80 {
+			preview = append(preview[:80], '…')
+		}
+
+		choices = append(choices, questChoice{
+			ID:      quest.ID,
+			Number:  quest.QuestNumber,
+			Preview: string(preview),
+		})
+	}
+	return choices
+}
+
+// prepare fills in the parts of the quest page that depend on the team and
+// its language
+func (data *questPage) prepare(r *http.Request, teamName string, quest Quest) {
+	language := requestLanguage(r, teamName)
+	game := teamGame(teamName)
+	data.pageText = newGamePageText(language, game)
+
+	// The page counts down from the team's start, so a game ending earlier
+	// shortens the game time
+	_, _, duration := game.schedule()
+	data.GameDuration = duration.Milliseconds()
+	mu.Lock()
+	if team, ok := teams[teamName]; ok && team.StopwatchOn {
+		data.GameDuration = game.endFor(team.Stopwatch).Sub(team.Stopwatch).Milliseconds()
+	}
+	mu.Unlock()
+	data.Quest = newQuestView(teamName, language, quest)
+	data.setSkipState(teamName, quest)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The quest page and its JSON must never carry the answers, nor a hint the
+// team hasn't revealed
+func TestQuestResponsesHideAnswersAndHints(t *testing.T) {
+	handler := newTestServer(t)
+	const (
+		teamName = "TEAM1"
+		text     = "Find the bell of the old church"
+		answer   = "secret-answer-7f3a"
+		hint     = "secret-hint-9c1d"
+	)
+
+	definition := QuestDefinition{
+		Game:              defaultGameKey,
+		Key:               "leak-test",
+		Text:              text,
+		CorrectAnswers:    answer + "|other-" + answer,
+		Hint:              hint,
+		HintTimerRequired: true,
+		HintTimerDuration: time.Hour,
+	}
+	if err := db.Create(&definition).Error; err != nil {
+		t.Fatal(err)
+	}
+	quest := Quest{TeamName: teamName, QuestNumber: 1, DefinitionID: definition.ID}
+	if err := db.Create(&quest).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	teams[teamName].Stopwatch = time.Now()
+	teams[teamName].StopwatchOn = true
+	mu.Unlock()
+
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{"/treasurehunt?team=" + teamName, http.StatusOK},
+		{"/check-quest-status", http.StatusOK},
+		{fmt.Sprintf("/hint/%d", quest.ID), http.StatusForbidden}, // The hint timer is still running
+	} {
+		w := teamGet(handler, teamName, tc.path)
+		body := w.Body.String()
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.path, w.Code, tc.status)
+		}
+		if tc.status == http.StatusOK && !strings.Contains(body, text) {
+			t.Errorf("%s: the quest text is missing, the quest wasn't rendered", tc.path)
+		}
+		if strings.Contains(body, answer) {
+			t.Errorf("%s: the response contains the answer", tc.path)
+		}
+		if strings.Contains(body, hint) {
+			t.Errorf("%s: the response contains the unrevealed hint", tc.path)
+		}
+	}
+}
+
+// The JSON API carries the same quest view, and the hint only once revealed
+func TestAPIResponsesHideAnswersAndHints(t *testing.T) {
+	handler := newTestServer(t)
+	const (
+		teamName = "APILEAK"
+		text     = "Count the windows of the tower"
+		answer   = "secret-answer-51be"
+		hint     = "secret-hint-0d77"
+	)
+	addTestTeam(t, teamName)
+	quest := addTestQuest(t, teamName, 1, QuestDefinition{
+		Key:               "api-leak",
+		Text:              text,
+		CorrectAnswers:    answer + "|other-" + answer,
+		Hint:              hint,
+		HintTimerRequired: true,
+		HintTimerDuration: time.Hour,
+	})
+	token := apiLogin(t, handler, teamName)
+
+	check := func(name string, w *httptest.ResponseRecorder, status int, hintShown bool) {
+		t.Helper()
+		body := w.Body.String()
+		if w.Code != status {
+			t.Errorf("%s: status %d, want %d", name, w.Code, status)
+		}
+		if strings.Contains(body, answer) {
+			t.Errorf("%s: the response contains the answer", name)
+		}
+		if strings.Contains(body, hint) != hintShown {
+			t.Errorf("%s: hint shown %v, want %v", name, !hintShown, hintShown)
+		}
+	}
+
+	quests := apiRequest(handler, http.MethodGet, "/api/v1/quest", token, nil)
+	check("quest", quests, http.StatusOK, false)
+	if !strings.Contains(quests.Body.String(), text) {
+		t.Error("quest: the quest text is missing, the quest wasn't rendered")
+	}
+	check("hint during its timer", apiRequest(handler, http.MethodPost, "/api/v1/hint", token, map[string]interface{}{"questId": quest.ID}), http.StatusForbidden, false)
+	check("wrong answer", apiRequest(handler, http.MethodPost, "/api/v1/submit", token, map[string]interface{}{"questId": quest.ID, "answer": "guess"}), http.StatusOK, false)
+
+	db.Model(&Quest{}).Where("id = ?", quest.ID).Update("hint_timer_end_time", time.Now().Add(-time.Minute))
+	check("hint after its timer", apiRequest(handler, http.MethodPost, "/api/v1/hint", token, map[string]interface{}{"questId": quest.ID}), http.StatusOK, true)
+	check("quest with the hint", apiRequest(handler, http.MethodGet, "/api/v1/quest", token, nil), http.StatusOK, true)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Fixed parts of an XLSX workbook. The workbook, its relationships and the
+// sheets are written for every export.
+const (
+	xlsxHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+	xlsxRootRels = xlsxHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+
+	xlsxSheetType = "application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"
+	xlsxSheetRel  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet"
+	xlsxMainNS    = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+)
+
+// writeXLSX writes the tables as the sheets of an XLSX workbook, with the
+// header in the first row. Only what the exports need is supported: text,
+// numbers and booleans.
+func writeXLSX(w io.Writer, tables []exportTable) error {
+	archive := zip.NewWriter(w)
+
+	var types, sheets, rels bytes.Buffer
+	types.WriteString(xlsxHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	rels.WriteString(xlsxHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+
+	for i, table := range tables {
+		n := i + 1
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="%s"/>`, n, xlsxSheetType)
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlText(table.Name), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s" Target="worksheets/sheet%d.xml"/>`, n, xlsxSheetRel, n)
+	}
+	types.WriteString(`</Types>`)
+	rels.WriteString(`</Relationships>`)
+
+	workbook := xlsxHeader + `<workbook xmlns="` + xlsxMainNS + `" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets>` + sheets.String() + `</sheets></workbook>`
+
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", types.String()},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", workbook},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+	}
+	for i, table := range tables {
+		parts = append(parts, struct {
+			name    string
+			content string
+		}{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), xlsxSheet(table)})
+	}
+
+	for _, part := range parts {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(file, part.content); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// xlsxSheet writes a table as a worksheet
+func xlsxSheet(table exportTable) string {
+	var sheet bytes.Buffer
+	sheet.WriteString(xlsxHeader + `<worksheet xmlns="` + xlsxMainNS + `"><sheetData>`)
+
+	writeRow := func(number int, cells []interface{}) {
+		fmt.Fprintf(&sheet, `<row r="%d">`, number)
+		for i, cell := range cells {
+			ref := xlsxColumn(i) + strconv.Itoa(number)
+			switch value := cell.(type) {
+			case int:
+				fmt.Fprintf(&sheet, `<c r="%s"><v>%d</v></c>`, ref, value)
+			case bool:
+				bit := 0
+				if value {
+					bit = 1
+				}
+				fmt.Fprintf(&sheet, `<c r="%s" t="b"><v>%d</v></c>`, ref, bit)
+			default:
+				fmt.Fprintf(&sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, xmlText(fmt.Sprint(value)))
+			}
+		}
+		sheet.WriteString(`</row>`)
+	}
+
+	header := make([]interface{}, len(table.Header))
+	for i, name := range table.Header {
+		header[i] = name
+	}
+	writeRow(1, header)
+	for i, row := range table.Rows {
+		writeRow(i+2, row)
+	}
+
+	sheet.WriteString(`</sheetData></worksheet>`)
+	return sheet.String()
+}
+
+// xlsxColumn returns the letters of a zero-based column: A … Z, AA, AB …
+func xlsxColumn(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+// xmlText escapes text for XML
+func xmlText(text string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}